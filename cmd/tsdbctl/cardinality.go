@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	pb "github.com/sinnlos-ffff/tsdb-lite/proto"
+)
+
+func cardinality(args []string) error {
+	fs := flag.NewFlagSet("cardinality", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8080", "server address")
+	limit := fs.Int("limit", 10, "entries per ranking")
+	approximate := fs.Bool("approximate", false, "estimate with HyperLogLog, for very large indexes")
+	fs.Parse(args)
+
+	client, conn, err := dial(*addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	resp, err := client.Cardinality(context.Background(), &pb.CardinalityRequest{
+		Limit:       int32(*limit),
+		Approximate: *approximate,
+	})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	estimated := ""
+	if resp.Approximate {
+		estimated = " (estimated)"
+	}
+	fmt.Fprintf(w, "Series: %d\n", resp.Series)
+	printStats(w, "Top metrics by series", "SERIES", resp.TopMetrics)
+	printStats(w, "Top tag keys by distinct values"+estimated, "VALUES", resp.TopTagKeys)
+	printStats(w, "Top tag values by series"+estimated, "SERIES", resp.TopTagValues)
+	printStats(w, "Top metrics by estimated memory", "BYTES", resp.TopMemory)
+	return w.Flush()
+}
+
+func printStats(w *tabwriter.Writer, title, column string, stats []*pb.CardinalityStat) {
+	fmt.Fprintf(w, "\n%s\n", title)
+	fmt.Fprintf(w, "NAME\t%s\n", column)
+	for _, stat := range stats {
+		fmt.Fprintf(w, "%s\t%d\n", stat.Name, stat.Value)
+	}
+}
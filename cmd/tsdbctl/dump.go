@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sinnlos-ffff/tsdb-lite/dump"
+	pb "github.com/sinnlos-ffff/tsdb-lite/proto"
+	"github.com/sinnlos-ffff/tsdb-lite/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+// tagFlags collects repeated -tag key=value flags.
+type tagFlags map[string]string
+
+func (t tagFlags) String() string {
+	var pairs []string
+	for k, v := range t {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (t tagFlags) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("expected key=value, got %q", s)
+	}
+	t[k] = v
+	return nil
+}
+
+func dial(addr string) (pb.TsdbLiteClient, *grpc.ClientConn, error) {
+	creds, err := transportCredentials()
+	if err != nil {
+		return nil, nil, err
+	}
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if token := os.Getenv("TSDB_TOKEN"); token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(bearerToken(token)))
+	}
+	conn, err := grpc.Dial(addr, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pb.NewTsdbLiteClient(conn), conn, nil
+}
+
+// transportCredentials connects over TLS if $TSDB_TLS_CA names the CA to
+// verify the server with, presenting the client certificate in
+// $TSDB_TLS_CERT and $TSDB_TLS_KEY if set.
+func transportCredentials() (credentials.TransportCredentials, error) {
+	caFile := os.Getenv("TSDB_TLS_CA")
+	if caFile == "" {
+		return insecure.NewCredentials(), nil
+	}
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{RootCAs: x509.NewCertPool()}
+	if !config.RootCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no certificates found", caFile)
+	}
+	if certFile := os.Getenv("TSDB_TLS_CERT"); certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, os.Getenv("TSDB_TLS_KEY"))
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return credentials.NewTLS(config), nil
+}
+
+// bearerToken authenticates every RPC with a static token.
+type bearerToken string
+
+func (t bearerToken) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+func (t bearerToken) RequireTransportSecurity() bool {
+	return false
+}
+
+// tenantContext returns a context whose requests act on behalf of tenant,
+// or the default tenant if it is empty.
+func tenantContext(tenant string) context.Context {
+	ctx := context.Background()
+	if tenant != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, server.TenantMetadataKey, tenant)
+	}
+	return ctx
+}
+
+// openOutput returns stdout for "-" and creates the file otherwise.
+func openOutput(path string) (io.WriteCloser, error) {
+	if path == "-" {
+		return os.Stdout, nil
+	}
+	return os.Create(path)
+}
+
+func openInput(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return os.Stdin, nil
+	}
+	return os.Open(path)
+}
+
+func export(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8080", "server address")
+	tenant := fs.String("tenant", "", "tenant to act on behalf of; the default tenant if empty")
+	metric := fs.String("metric", "", "metric to export; all metrics if empty")
+	tags := tagFlags{}
+	fs.Var(tags, "tag", "only export series with this key=value tag; may be repeated")
+	start := fs.Int64("start", 0, "first timestamp to export")
+	end := fs.Int64("end", 0, "last timestamp to export; 0 for no limit")
+	format := fs.String("format", "csv", "output format, csv or ndjson")
+	out := fs.String("o", "-", "file to write to, - for stdout")
+	fs.Parse(args)
+
+	f, err := dump.ParseFormat(*format)
+	if err != nil {
+		return err
+	}
+
+	client, conn, err := dial(*addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	req := &pb.ExportRequest{Metric: *metric, Tags: tags}
+	if *start != 0 {
+		req.Start = start
+	}
+	if *end != 0 {
+		req.End = end
+	}
+	stream, err := client.Export(tenantContext(*tenant), req)
+	if err != nil {
+		return err
+	}
+
+	file, err := openOutput(*out)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := dump.NewWriter(file, f)
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for _, p := range resp.Points {
+			if err := w.Write(&dump.Sample{Metric: resp.Metric, Tags: resp.Tags, Timestamp: p.Timestamp, Value: p.Value}); err != nil {
+				return err
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func importSamples(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8080", "server address")
+	tenant := fs.String("tenant", "", "tenant to act on behalf of; the default tenant if empty")
+	format := fs.String("format", "csv", "input format, csv or ndjson")
+	batchSize := fs.Int("batch", 1000, "samples sent per request")
+	in := fs.String("i", "-", "file to read from, - for stdin")
+	backfill := fs.Bool("backfill", false, "write historical data as sealed chunks; each series' samples must be consecutive and in time order, as export writes them")
+	fs.Parse(args)
+
+	f, err := dump.ParseFormat(*format)
+	if err != nil {
+		return err
+	}
+	if *batchSize <= 0 {
+		return fmt.Errorf("-batch must be positive")
+	}
+
+	file, err := openInput(*in)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	client, conn, err := dial(*addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	r := dump.NewReader(file, f)
+	if *backfill {
+		return importBackfill(tenantContext(*tenant), client, r, *batchSize)
+	}
+
+	stream, err := client.Import(tenantContext(*tenant))
+	if err != nil {
+		return err
+	}
+
+	var progress *pb.ImportResponse
+	lastReport := time.Now()
+	send := func(batch []*pb.Sample) error {
+		// A failed Send returns io.EOF; the server's error comes from Recv
+		if err := stream.Send(&pb.ImportRequest{Samples: batch}); err != nil && err != io.EOF {
+			return err
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		progress = resp
+		if time.Since(lastReport) >= time.Second {
+			log.Printf("imported %d points, created %d series", progress.PointsWritten, progress.SeriesCreated)
+			lastReport = time.Now()
+		}
+		return nil
+	}
+
+	batch := make([]*pb.Sample, 0, *batchSize)
+	for {
+		s, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		batch = append(batch, &pb.Sample{Metric: s.Metric, Tags: s.Tags, Timestamp: s.Timestamp, Value: s.Value})
+		if len(batch) == *batchSize {
+			if err := send(batch); err != nil {
+				return err
+			}
+			batch = make([]*pb.Sample, 0, *batchSize)
+		}
+	}
+	if len(batch) > 0 {
+		if err := send(batch); err != nil {
+			return err
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		return err
+	}
+
+	if progress != nil {
+		log.Printf("imported %d points, created %d series", progress.PointsWritten, progress.SeriesCreated)
+	}
+	return nil
+}
+
+// importBackfill sends runs of consecutive samples of the same series as
+// Backfill batches.
+func importBackfill(ctx context.Context, client pb.TsdbLiteClient, r *dump.Reader, batchSize int) error {
+	stream, err := client.Backfill(ctx)
+	if err != nil {
+		return err
+	}
+
+	var sent int
+	lastReport := time.Now()
+	batch := &pb.BackfillRequest{}
+	send := func() error {
+		if len(batch.Points) == 0 {
+			return nil
+		}
+		if err := stream.Send(batch); err == io.EOF {
+			// The server failed the stream; CloseAndRecv returns why
+			_, err = stream.CloseAndRecv()
+			return err
+		} else if err != nil {
+			return err
+		}
+		sent += len(batch.Points)
+		if time.Since(lastReport) >= time.Second {
+			log.Printf("sent %d points", sent)
+			lastReport = time.Now()
+		}
+		return nil
+	}
+
+	for {
+		s, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(batch.Points) == batchSize || s.Metric != batch.Metric || !maps.Equal(s.Tags, batch.Tags) {
+			if err := send(); err != nil {
+				return err
+			}
+			batch = &pb.BackfillRequest{Metric: s.Metric, Tags: s.Tags, Points: make([]*pb.Point, 0, batchSize)}
+		}
+		batch.Points = append(batch.Points, &pb.Point{Timestamp: s.Timestamp, Value: s.Value})
+	}
+	if err := send(); err != nil {
+		return err
+	}
+
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return err
+	}
+	log.Printf("backfilled %d points, created %d series", resp.PointsWritten, resp.SeriesCreated)
+	return nil
+}
@@ -0,0 +1,453 @@
+// Package config loads the configuration of the server binary from a YAML
+// file, environment variables and command-line flags, each overriding the
+// ones before.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/sinnlos-ffff/tsdb-lite/database"
+	"github.com/sinnlos-ffff/tsdb-lite/server"
+	"github.com/sinnlos-ffff/tsdb-lite/tracing"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix starts the environment variable of each flag, which is the flag
+// name in upper case with dashes replaced by underscores, such as
+// TSDB_DATA_DIR for -data-dir.
+const EnvPrefix = "TSDB_"
+
+// WAL modes.
+const (
+	WALOff      = "off"
+	WALBuffered = "buffered"
+	WALSync     = "sync"
+)
+
+type Config struct {
+	// Listen is the address the gRPC API is served on.
+	Listen string `yaml:"listen"`
+	// HTTPListen is the address /healthz, /readyz and /metrics are served
+	// on, if any.
+	HTTPListen string `yaml:"http_listen"`
+	// DataDir holds the wal, snapshots and backups directories.
+	DataDir   string            `yaml:"data_dir"`
+	Storage   Storage           `yaml:"storage"`
+	WAL       WAL               `yaml:"wal"`
+	Retention Retention         `yaml:"retention"`
+	Limits    Limits            `yaml:"limits"`
+	Tenants   map[string]Tenant `yaml:"tenants"`
+	TLS       TLS               `yaml:"tls"`
+	AuthFile  string            `yaml:"auth_file"`
+	Shutdown  Shutdown          `yaml:"shutdown"`
+	Tracing   Tracing           `yaml:"tracing"`
+	// SelfMonitoring stores the server's own metrics in the database, as
+	// series starting with tsdb_self_.
+	SelfMonitoring SelfMonitoring `yaml:"self_monitoring"`
+}
+
+type Storage struct {
+	ShardCount         int           `yaml:"shard_count"`
+	ChunkSize          int           `yaml:"chunk_size"`
+	CompactionInterval time.Duration `yaml:"compaction_interval"`
+	// OutOfOrderWindow is converted to seconds, the unit of timestamps.
+	// Zero accepts samples however late they are.
+	OutOfOrderWindow        time.Duration     `yaml:"out_of_order_window"`
+	DuplicatePolicy         string            `yaml:"duplicate_policy"`
+	MetricDuplicatePolicies map[string]string `yaml:"metric_duplicate_policies"`
+	Rollups                 []Rollup          `yaml:"rollups"`
+}
+
+type Rollup struct {
+	Resolution time.Duration `yaml:"resolution"`
+	Retention  time.Duration `yaml:"retention"`
+}
+
+type WAL struct {
+	// Mode is WALOff, WALBuffered or WALSync.
+	Mode          string        `yaml:"mode"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+}
+
+type Retention struct {
+	Default time.Duration `yaml:"default"`
+	// Metrics overrides Default by metric name prefix.
+	Metrics map[string]time.Duration `yaml:"metrics"`
+}
+
+type Limits struct {
+	MaxSeries             int           `yaml:"max_series"`
+	MaxSeriesPerMetric    int           `yaml:"max_series_per_metric"`
+	MaxTagsPerSeries      int           `yaml:"max_tags_per_series"`
+	ClientPointsPerSecond float64       `yaml:"client_points_per_second"`
+	ClientBurst           int           `yaml:"client_burst"`
+	MaxHeapBytes          uint64        `yaml:"max_heap_bytes"`
+	MaxWALQueue           int           `yaml:"max_wal_queue"`
+	RetryAfter            time.Duration `yaml:"retry_after"`
+}
+
+// Tenant holds the quotas of one tenant. If any tenants are configured,
+// only they and the default tenant may write.
+type Tenant struct {
+	MaxSeries   int           `yaml:"max_series"`
+	IngestRate  float64       `yaml:"ingest_rate"`
+	IngestBurst int           `yaml:"ingest_burst"`
+	Retention   time.Duration `yaml:"retention"`
+}
+
+type TLS struct {
+	CertFile          string `yaml:"cert_file"`
+	KeyFile           string `yaml:"key_file"`
+	ClientCAFile      string `yaml:"client_ca_file"`
+	RequireClientCert bool   `yaml:"require_client_cert"`
+}
+
+type Shutdown struct {
+	// Timeout bounds how long the server takes to stop on SIGTERM or
+	// SIGINT. Requests still running then are cancelled.
+	Timeout time.Duration `yaml:"timeout"`
+	// Snapshot takes a snapshot before stopping.
+	Snapshot bool `yaml:"snapshot"`
+}
+
+type Tracing struct {
+	// Exporter is "" to disable tracing, "otlp" or "file".
+	Exporter string `yaml:"exporter"`
+	Endpoint string `yaml:"endpoint"`
+	Insecure bool   `yaml:"insecure"`
+	// File is where the file exporter writes spans.
+	File        string  `yaml:"file"`
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+type SelfMonitoring struct {
+	// Interval is how often the metrics are stored; 0 disables
+	// self-monitoring.
+	Interval time.Duration `yaml:"interval"`
+}
+
+func Default() *Config {
+	return &Config{
+		Listen:     ":8080",
+		HTTPListen: ":8081",
+		DataDir:    "data",
+		Storage: Storage{
+			ShardCount:         database.DefaultOptions().ShardCount,
+			ChunkSize:          database.ChunkSize,
+			CompactionInterval: time.Minute,
+			OutOfOrderWindow:   5 * time.Minute,
+			DuplicatePolicy:    database.DuplicateLastWriteWins.String(),
+		},
+		WAL: WAL{
+			Mode:          WALBuffered,
+			FlushInterval: database.DefaultOptions().WALFlushInterval,
+		},
+		Shutdown: Shutdown{Timeout: 30 * time.Second},
+		Tracing:  Tracing{SampleRatio: 1},
+	}
+}
+
+// Load builds the configuration from the defaults, the YAML file named by
+// the -config flag or $TSDB_CONFIG, the environment and then args, and
+// validates it.
+func Load(args []string, getenv func(string) string) (*Config, error) {
+	// A first pass finds the file, which the flags and environment must
+	// then override.
+	var path string
+	fs := flagSet(Default(), &path)
+	fs.SetOutput(io.Discard)
+	if err := applyEnv(fs, getenv); err != nil {
+		return nil, err
+	}
+	if err := fs.Parse(args); err != nil && !errors.Is(err, flag.ErrHelp) {
+		return nil, err
+	}
+
+	c := Default()
+	if path != "" {
+		if err := c.readFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	fs = flagSet(c, &path)
+	if err := applyEnv(fs, getenv); err != nil {
+		return nil, err
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Config) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func flagSet(c *Config, path *string) *flag.FlagSet {
+	fs := flag.NewFlagSet("tsdb-lite", flag.ContinueOnError)
+	fs.StringVar(path, "config", *path, "YAML configuration file")
+	fs.StringVar(&c.Listen, "listen", c.Listen, "gRPC listen address")
+	fs.StringVar(&c.HTTPListen, "http-listen", c.HTTPListen, "HTTP listen address for health checks and metrics; empty to disable")
+	fs.StringVar(&c.DataDir, "data-dir", c.DataDir, "directory holding the wal, snapshots and backups")
+	fs.IntVar(&c.Storage.ShardCount, "shard-count", c.Storage.ShardCount, "number of shards to start with")
+	fs.IntVar(&c.Storage.ChunkSize, "chunk-size", c.Storage.ChunkSize, "points per chunk")
+	fs.DurationVar(&c.Storage.CompactionInterval, "compaction-interval", c.Storage.CompactionInterval, "how often chunks are compacted and retention applied")
+	fs.DurationVar(&c.Storage.OutOfOrderWindow, "out-of-order-window", c.Storage.OutOfOrderWindow, "how far behind the newest point samples are still accepted; 0 accepts them however late")
+	fs.DurationVar(&c.Retention.Default, "retention", c.Retention.Default, "how long points are kept; 0 keeps them forever")
+	fs.StringVar(&c.WAL.Mode, "wal-mode", c.WAL.Mode, "write-ahead log mode: off, buffered or sync")
+	fs.DurationVar(&c.WAL.FlushInterval, "wal-flush-interval", c.WAL.FlushInterval, "how often the write-ahead log is synced to disk")
+	fs.IntVar(&c.Limits.MaxSeries, "max-series", c.Limits.MaxSeries, "most series in the database; 0 for no limit")
+	fs.IntVar(&c.Limits.MaxSeriesPerMetric, "max-series-per-metric", c.Limits.MaxSeriesPerMetric, "most series per metric name; 0 for no limit")
+	fs.IntVar(&c.Limits.MaxTagsPerSeries, "max-tags-per-series", c.Limits.MaxTagsPerSeries, "most tags per series; 0 for no limit")
+	fs.Float64Var(&c.Limits.ClientPointsPerSecond, "client-rate", c.Limits.ClientPointsPerSecond, "points per second each client may write; 0 for no limit")
+	fs.IntVar(&c.Limits.ClientBurst, "client-burst", c.Limits.ClientBurst, "points each client may write at once; 0 for the client rate rounded up")
+	fs.Uint64Var(&c.Limits.MaxHeapBytes, "max-heap-bytes", c.Limits.MaxHeapBytes, "heap size above which writes are rejected; 0 for no limit")
+	fs.IntVar(&c.Limits.MaxWALQueue, "max-wal-queue", c.Limits.MaxWALQueue, "queued WAL writes above which writes are rejected; 0 for no limit")
+	fs.StringVar(&c.TLS.CertFile, "tls-cert", c.TLS.CertFile, "TLS certificate file; enables TLS")
+	fs.StringVar(&c.TLS.KeyFile, "tls-key", c.TLS.KeyFile, "TLS key file")
+	fs.StringVar(&c.TLS.ClientCAFile, "tls-client-ca", c.TLS.ClientCAFile, "CA file to verify client certificates with")
+	fs.BoolVar(&c.TLS.RequireClientCert, "tls-require-client-cert", c.TLS.RequireClientCert, "refuse clients without a certificate")
+	fs.StringVar(&c.AuthFile, "auth-file", c.AuthFile, "identities file; enables authentication")
+	fs.DurationVar(&c.Shutdown.Timeout, "shutdown-timeout", c.Shutdown.Timeout, "how long to wait for requests to finish when stopping")
+	fs.BoolVar(&c.Shutdown.Snapshot, "shutdown-snapshot", c.Shutdown.Snapshot, "take a snapshot when stopping")
+	fs.StringVar(&c.Tracing.Exporter, "tracing-exporter", c.Tracing.Exporter, "trace exporter: otlp or file; empty to disable tracing")
+	fs.StringVar(&c.Tracing.Endpoint, "tracing-endpoint", c.Tracing.Endpoint, "OTLP gRPC collector address")
+	fs.BoolVar(&c.Tracing.Insecure, "tracing-insecure", c.Tracing.Insecure, "send spans to the collector without TLS")
+	fs.StringVar(&c.Tracing.File, "tracing-file", c.Tracing.File, "file the file exporter writes spans to")
+	fs.Float64Var(&c.Tracing.SampleRatio, "tracing-sample-ratio", c.Tracing.SampleRatio, "fraction of new traces to record")
+	fs.DurationVar(&c.SelfMonitoring.Interval, "self-monitor-interval", c.SelfMonitoring.Interval, "how often the server's own metrics are stored as tsdb_self_ series; 0 to disable")
+	return fs
+}
+
+// EnvName returns the environment variable of a flag.
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+func applyEnv(fs *flag.FlagSet, getenv func(string) string) error {
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if value := getenv(EnvName(f.Name)); value != "" {
+			if err := fs.Set(f.Name, value); err != nil {
+				errs = append(errs, fmt.Errorf("$%s: %w", EnvName(f.Name), err))
+			}
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// Validate returns every problem with the configuration.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, field, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+		}
+	}
+	positive := func(field string, v int) {
+		check(v > 0, field, "must be positive, got %d", v)
+	}
+	notNegative := func(field string, v int) {
+		check(v >= 0, field, "must not be negative, got %d", v)
+	}
+	notNegativeDuration := func(field string, d time.Duration) {
+		check(d >= 0, field, "must not be negative, got %s", d)
+	}
+
+	check(c.Listen != "", "listen", "must not be empty")
+	check(c.DataDir != "", "data_dir", "must not be empty")
+
+	positive("storage.shard_count", c.Storage.ShardCount)
+	positive("storage.chunk_size", c.Storage.ChunkSize)
+	check(c.Storage.CompactionInterval > 0, "storage.compaction_interval", "must be positive, got %s", c.Storage.CompactionInterval)
+	notNegativeDuration("storage.out_of_order_window", c.Storage.OutOfOrderWindow)
+	_, err := parseDuplicatePolicy(c.Storage.DuplicatePolicy)
+	check(err == nil, "storage.duplicate_policy", "%v", err)
+	for metric, policy := range c.Storage.MetricDuplicatePolicies {
+		_, err := parseDuplicatePolicy(policy)
+		check(err == nil, "storage.metric_duplicate_policies."+metric, "%v", err)
+	}
+	for i, r := range c.Storage.Rollups {
+		check(r.Resolution > 0, fmt.Sprintf("storage.rollups[%d].resolution", i), "must be positive, got %s", r.Resolution)
+		notNegativeDuration(fmt.Sprintf("storage.rollups[%d].retention", i), r.Retention)
+	}
+
+	check(c.WAL.Mode == WALOff || c.WAL.Mode == WALBuffered || c.WAL.Mode == WALSync,
+		"wal.mode", "must be %s, %s or %s, got %q", WALOff, WALBuffered, WALSync, c.WAL.Mode)
+	check(c.WAL.FlushInterval > 0, "wal.flush_interval", "must be positive, got %s", c.WAL.FlushInterval)
+
+	notNegativeDuration("retention.default", c.Retention.Default)
+	for prefix, r := range c.Retention.Metrics {
+		notNegativeDuration("retention.metrics."+prefix, r)
+	}
+
+	notNegative("limits.max_series", c.Limits.MaxSeries)
+	notNegative("limits.max_series_per_metric", c.Limits.MaxSeriesPerMetric)
+	notNegative("limits.max_tags_per_series", c.Limits.MaxTagsPerSeries)
+	check(c.Limits.ClientPointsPerSecond >= 0, "limits.client_points_per_second", "must not be negative, got %g", c.Limits.ClientPointsPerSecond)
+	notNegative("limits.client_burst", c.Limits.ClientBurst)
+	notNegative("limits.max_wal_queue", c.Limits.MaxWALQueue)
+	notNegativeDuration("limits.retry_after", c.Limits.RetryAfter)
+
+	for name, t := range c.Tenants {
+		field := "tenants." + name
+		check(name != "", "tenants", "tenant IDs must not be empty")
+		notNegative(field+".max_series", t.MaxSeries)
+		check(t.IngestRate >= 0, field+".ingest_rate", "must not be negative, got %g", t.IngestRate)
+		notNegative(field+".ingest_burst", t.IngestBurst)
+		notNegativeDuration(field+".retention", t.Retention)
+	}
+
+	check(c.TLS.CertFile == "" || c.TLS.KeyFile != "", "tls.key_file", "is required with tls.cert_file")
+	check(c.TLS.KeyFile == "" || c.TLS.CertFile != "", "tls.cert_file", "is required with tls.key_file")
+	check(!c.TLS.RequireClientCert || c.TLS.ClientCAFile != "", "tls.client_ca_file", "is required with tls.require_client_cert")
+
+	check(c.Shutdown.Timeout > 0, "shutdown.timeout", "must be positive, got %s", c.Shutdown.Timeout)
+
+	check(c.Tracing.Exporter == tracing.ExporterNone || c.Tracing.Exporter == tracing.ExporterOTLP || c.Tracing.Exporter == tracing.ExporterFile,
+		"tracing.exporter", "must be empty, %s or %s, got %q", tracing.ExporterOTLP, tracing.ExporterFile, c.Tracing.Exporter)
+	check(c.Tracing.Exporter != tracing.ExporterFile || c.Tracing.File != "", "tracing.file", "is required with the file exporter")
+	check(c.SelfMonitoring.Interval == 0 || c.SelfMonitoring.Interval >= time.Second,
+		"self_monitoring.interval", "must be 0 or at least 1s, got %s", c.SelfMonitoring.Interval)
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio", "must be between 0 and 1, got %g", c.Tracing.SampleRatio)
+
+	return errors.Join(errs...)
+}
+
+func parseDuplicatePolicy(s string) (database.DuplicatePolicy, error) {
+	policies := []database.DuplicatePolicy{database.DuplicateLastWriteWins, database.DuplicateFirstWriteWins, database.DuplicateReject}
+	for _, p := range policies {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("must be %s, %s or %s, got %q", policies[0], policies[1], policies[2], s)
+}
+
+// Server returns the server configuration. c must be valid.
+func (c *Config) Server() *server.Config {
+	sc := &server.Config{
+		ShardCount:         c.Storage.ShardCount,
+		ChunkSize:          c.Storage.ChunkSize,
+		CompactionInterval: c.Storage.CompactionInterval,
+		OutOfOrderWindow:   int64(c.Storage.OutOfOrderWindow.Seconds()),
+		Retention:          c.Retention.Default,
+		MetricRetention:    c.Retention.Metrics,
+		Limits: database.Limits{
+			MaxSeries:          c.Limits.MaxSeries,
+			MaxSeriesPerMetric: c.Limits.MaxSeriesPerMetric,
+			MaxTagsPerSeries:   c.Limits.MaxTagsPerSeries,
+		},
+		SnapshotDir: filepath.Join(c.DataDir, "snapshots"),
+		BackupDir:   filepath.Join(c.DataDir, "backups"),
+		TLS: server.TLSConfig{
+			CertFile:          c.TLS.CertFile,
+			KeyFile:           c.TLS.KeyFile,
+			ClientCAFile:      c.TLS.ClientCAFile,
+			RequireClientCert: c.TLS.RequireClientCert,
+		},
+		SnapshotOnShutdown:  c.Shutdown.Snapshot,
+		SelfMonitorInterval: c.SelfMonitoring.Interval,
+		AuthFile:            c.AuthFile,
+		ClientRateLimit: server.RateLimit{
+			PointsPerSecond: c.Limits.ClientPointsPerSecond,
+			Burst:           c.Limits.ClientBurst,
+		},
+		LoadShedding: server.LoadShedding{
+			MaxHeapBytes: c.Limits.MaxHeapBytes,
+			MaxWALQueue:  c.Limits.MaxWALQueue,
+			RetryAfter:   c.Limits.RetryAfter,
+		},
+	}
+
+	sc.DuplicatePolicy, _ = parseDuplicatePolicy(c.Storage.DuplicatePolicy)
+	if len(c.Storage.MetricDuplicatePolicies) > 0 {
+		sc.MetricDuplicatePolicies = make(map[string]database.DuplicatePolicy)
+		for metric, policy := range c.Storage.MetricDuplicatePolicies {
+			sc.MetricDuplicatePolicies[metric], _ = parseDuplicatePolicy(policy)
+		}
+	}
+	for _, r := range c.Storage.Rollups {
+		sc.Rollups = append(sc.Rollups, database.RollupTier{Resolution: r.Resolution, Retention: r.Retention})
+	}
+	if c.WAL.Mode != WALOff {
+		sc.WALDir = filepath.Join(c.DataDir, "wal")
+		sc.WALFlushInterval = c.WAL.FlushInterval
+		sc.WALSync = c.WAL.Mode == WALSync
+	}
+	if len(c.Tenants) > 0 {
+		sc.Tenants = make(map[string]database.TenantLimits)
+		for name, t := range c.Tenants {
+			sc.Tenants[name] = database.TenantLimits{
+				MaxSeries:   t.MaxSeries,
+				IngestRate:  t.IngestRate,
+				IngestBurst: t.IngestBurst,
+				Retention:   t.Retention,
+			}
+		}
+	}
+	return sc
+}
+
+// TracingOptions returns the tracing options. c must be valid.
+func (c *Config) TracingOptions() tracing.Options {
+	return tracing.Options{
+		Exporter:    c.Tracing.Exporter,
+		Endpoint:    c.Tracing.Endpoint,
+		Insecure:    c.Tracing.Insecure,
+		File:        c.Tracing.File,
+		SampleRatio: c.Tracing.SampleRatio,
+	}
+}
+
+// RestartRequired returns the sections of c that differ from running and
+// that Server.Reload cannot apply.
+func (c *Config) RestartRequired(running *Config) []string {
+	var sections []string
+	for _, s := range []struct {
+		name     string
+		new, old any
+	}{
+		{"listen", c.Listen, running.Listen},
+		{"http_listen", c.HTTPListen, running.HTTPListen},
+		{"data_dir", c.DataDir, running.DataDir},
+		{"storage", c.Storage, running.Storage},
+		{"wal", c.WAL, running.WAL},
+		{"tls", c.TLS, running.TLS},
+		{"shutdown", c.Shutdown, running.Shutdown},
+		{"tracing", c.Tracing, running.Tracing},
+		{"self_monitoring", c.SelfMonitoring, running.SelfMonitoring},
+	} {
+		if !reflect.DeepEqual(s.new, s.old) {
+			sections = append(sections, s.name)
+		}
+	}
+	return sections
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sinnlos-ffff/tsdb-lite/database"
+	"github.com/sinnlos-ffff/tsdb-lite/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "tsdb.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func env(vars map[string]string) func(string) string {
+	return func(key string) string { return vars[key] }
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	c, err := Load(nil, env(nil))
+	require.NoError(t, err)
+	assert.Equal(t, Default(), c)
+
+	sc := c.Server()
+	assert.Equal(t, filepath.Join("data", "wal"), sc.WALDir)
+	assert.Equal(t, int64(300), sc.OutOfOrderWindow)
+	assert.False(t, sc.WALSync)
+	assert.False(t, sc.SnapshotOnShutdown)
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeFile(t, `
+listen: ":9000"
+data_dir: /var/lib/tsdb
+storage:
+  shard_count: 8
+  chunk_size: 512
+  rollups:
+    - resolution: 1m
+      retention: 720h
+retention:
+  default: 168h
+  metrics:
+    debug_: 1h
+wal:
+  mode: sync
+limits:
+  max_series: 1000
+tenants:
+  team_a:
+    max_series: 10
+    ingest_rate: 100
+shutdown:
+  timeout: 10s
+  snapshot: true
+tracing:
+  exporter: otlp
+  endpoint: collector:4317
+  sample_ratio: 0.1
+`)
+
+	c, err := Load([]string{"-config", path, "-shard-count", "16"}, env(map[string]string{
+		"TSDB_SHARD_COUNT": "4",
+		"TSDB_MAX_SERIES":  "2000",
+	}))
+	require.NoError(t, err)
+
+	assert.Equal(t, ":9000", c.Listen)
+	assert.Equal(t, 16, c.Storage.ShardCount, "flags override the environment")
+	assert.Equal(t, 2000, c.Limits.MaxSeries, "the environment overrides the file")
+	assert.Equal(t, 512, c.Storage.ChunkSize)
+	assert.Equal(t, 168*time.Hour, c.Retention.Default)
+	assert.Equal(t, 10*time.Second, c.Shutdown.Timeout)
+	assert.Equal(t, tracing.Options{Exporter: tracing.ExporterOTLP, Endpoint: "collector:4317", SampleRatio: 0.1}, c.TracingOptions())
+
+	sc := c.Server()
+	assert.Equal(t, "/var/lib/tsdb/wal", sc.WALDir)
+	assert.True(t, sc.WALSync)
+	assert.True(t, sc.SnapshotOnShutdown)
+	assert.Equal(t, time.Hour, sc.MetricRetention["debug_"])
+	assert.Equal(t, []database.RollupTier{{Resolution: time.Minute, Retention: 720 * time.Hour}}, sc.Rollups)
+	assert.Equal(t, database.TenantLimits{MaxSeries: 10, IngestRate: 100}, sc.Tenants["team_a"])
+
+	// The file can also come from the environment
+	c, err = Load(nil, env(map[string]string{"TSDB_CONFIG": path}))
+	require.NoError(t, err)
+	assert.Equal(t, 8, c.Storage.ShardCount)
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	_, err := Load([]string{"-config", writeFile(t, "storage:\n  shards: 8\n")}, env(nil))
+	assert.ErrorContains(t, err, "field shards not found")
+
+	_, err = Load(nil, env(map[string]string{"TSDB_CHUNK_SIZE": "many"}))
+	assert.ErrorContains(t, err, "$TSDB_CHUNK_SIZE")
+
+	_, err = Load([]string{"-shard-count", "0", "-wal-mode", "fast", "-tls-cert", "server.crt", "-shutdown-timeout", "0s", "-tracing-exporter", "file", "-self-monitor-interval", "10ms"}, env(nil))
+	assert.ErrorContains(t, err, "storage.shard_count: must be positive, got 0")
+	assert.ErrorContains(t, err, `wal.mode: must be off, buffered or sync, got "fast"`)
+	assert.ErrorContains(t, err, "tls.key_file: is required with tls.cert_file")
+	assert.ErrorContains(t, err, "shutdown.timeout: must be positive, got 0s")
+	assert.ErrorContains(t, err, "tracing.file: is required with the file exporter")
+	assert.ErrorContains(t, err, "self_monitoring.interval: must be 0 or at least 1s, got 10ms")
+
+	_, err = Load([]string{"extra"}, env(nil))
+	assert.ErrorContains(t, err, "unexpected arguments")
+}
+
+func TestRestartRequired(t *testing.T) {
+	running := Default()
+	c := Default()
+	c.Retention.Default = time.Hour
+	c.Limits.MaxSeries = 10
+	assert.Empty(t, c.RestartRequired(running))
+
+	c.Listen = ":9000"
+	c.WAL.Mode = WALSync
+	assert.Equal(t, []string{"listen", "wal"}, c.RestartRequired(running))
+}
@@ -0,0 +1,293 @@
+package database
+
+import (
+	"math"
+	"sort"
+)
+
+// MaxBackfillPoints is the most points one Backfill call accepts, which
+// keeps its WAL record well below maxRecordSize.
+const MaxBackfillPoints = 1 << 18
+
+// Backfill writes time-ordered historical points to a series as sealed
+// chunks, bypassing the head and the out-of-order window. All points must
+// be older than the oldest point not yet sealed by the compactor, so the
+// live write head is left alone; sealed chunks they overlap are merged with
+// them and re-cut. Points collide with stored ones according to the series'
+// duplicate policy, and rollup buckets holding points they replace are
+// rebuilt.
+func (db *Database) Backfill(metric string, tags map[string]string, points []Point) error {
+	if len(points) == 0 {
+		return nil
+	}
+	if len(points) > MaxBackfillPoints {
+		return seriesError(ErrLimitExceeded, metric, tags)
+	}
+
+	commit, err := db.backfill(metric, tags, points)
+	if err != nil {
+		return err
+	}
+	if err := commit(); err != nil {
+		return err
+	}
+	db.countIngest(metric, tags, len(points))
+	return nil
+}
+
+func (db *Database) backfill(metric string, tags map[string]string, points []Point) (commit func() error, err error) {
+	ts, err := db.lookup(metric, tags)
+	if err != nil {
+		return nil, err
+	}
+
+	ts.Lock()
+	defer ts.Unlock()
+
+	if ts.removed {
+		return nil, seriesError(ErrSeriesNotFound, metric, tags)
+	}
+	if !isSelfMetric(metric) {
+		if err := db.allowIngest(TenantOf(tags), len(points)); err != nil {
+			return nil, seriesError(err, metric, tags)
+		}
+	}
+
+	rec := walRecord{Type: walBackfill, Metric: metric, Tags: tags, Points: points}
+	err = ts.backfill(points, false, func() error {
+		commit, err = db.log(&rec)
+		return err
+	})
+	if err != nil {
+		return nil, seriesError(err, metric, tags)
+	}
+	ts.lsn = rec.LSN
+	return commit, nil
+}
+
+// backfill checks points, calls reserve if it is not nil and, once that
+// succeeds, splices them into the series' sealed chunks. Compaction is not
+// logged, so on replay the chunks a backfill reached into may not be sealed
+// yet; replay seals them instead of failing the head check. Must be called
+// with ts locked.
+func (ts *TimeSeries) backfill(points []Point, replay bool, reserve func() error) error {
+	defer ts.recountChunks()
+	for i := 1; i < len(points); i++ {
+		if points[i].Timestamp < points[i-1].Timestamp {
+			return ErrNotSorted
+		}
+		if points[i].Timestamp == points[i-1].Timestamp && ts.duplicatePolicy == DuplicateReject &&
+			math.Float64bits(points[i].Value) != math.Float64bits(points[i-1].Value) {
+			return ErrDuplicate
+		}
+	}
+
+	head := ts.head()
+	for head < len(ts.Chunks) && len(ts.Chunks[head].Points) > 0 &&
+		points[len(points)-1].Timestamp >= ts.Chunks[head].Points[0].Timestamp {
+		if !replay {
+			return ErrOutOfBounds
+		}
+		ts.seal(ts.Chunks[head])
+		head++
+	}
+
+	if ts.duplicatePolicy == DuplicateReject {
+		for _, p := range points {
+			if existing, _ := ts.findPoint(p.Timestamp); existing != nil {
+				if err := ts.checkDuplicate(existing, p.Value); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if reserve != nil {
+		if err := reserve(); err != nil {
+			return err
+		}
+	}
+
+	// Tombstones mask by time range, so they must be gone before points in
+	// their range are written again.
+	ts.applyTombstones()
+	head = ts.head()
+
+	minT, maxT := points[0].Timestamp, points[len(points)-1].Timestamp
+	lo := sort.Search(head, func(i int) bool {
+		p := ts.Chunks[i].Points
+		return len(p) > 0 && p[len(p)-1].Timestamp >= minT
+	})
+	hi := lo
+	for hi < head && len(ts.Chunks[hi].Points) > 0 && ts.Chunks[hi].Points[0].Timestamp <= maxT {
+		hi++
+	}
+
+	var existing []Point
+	for _, chunk := range ts.Chunks[lo:hi] {
+		existing = append(existing, chunk.Points...)
+	}
+	merged := dedupe(mergePoints(existing, points), ts.duplicatePolicy)
+	added, replaced := diffPoints(existing, merged)
+	stale := ts.staleBuckets(replaced)
+
+	chunks := make([]*Chunk, 0, len(ts.Chunks)-(hi-lo)+len(merged)/ts.maxChunkSize()+1)
+	chunks = append(chunks, ts.Chunks[:lo]...)
+	for len(merged) > 0 {
+		n := min(len(merged), ts.maxChunkSize())
+		p := make([]Point, n)
+		copy(p, merged[:n])
+		chunks = append(chunks, &Chunk{Points: p, Count: n, Compacted: true})
+		merged = merged[n:]
+	}
+	ts.Chunks = append(chunks, ts.Chunks[hi:]...)
+	if len(ts.Chunks) == 0 {
+		ts.Chunks = []*Chunk{{Points: make([]Point, 0, ts.maxChunkSize())}}
+	}
+
+	for i, r := range ts.Rollups {
+		r.backfill(added)
+		r.replace(replaced, stale[i], ts.rawPoints)
+	}
+	return nil
+}
+
+// head returns the index of the first chunk the compactor has not sealed.
+// Sealed chunks always precede it. Must be called with ts locked.
+func (ts *TimeSeries) head() int {
+	for i, chunk := range ts.Chunks {
+		if !chunk.Compacted {
+			return i
+		}
+	}
+	return len(ts.Chunks)
+}
+
+// replacement is a stored point whose value a backfill overwrote.
+type replacement struct {
+	Timestamp int64
+	Old, New  float64
+}
+
+// diffPoints compares the time-ordered points a series held with the
+// deduplicated points it holds after a backfill, returning the points with
+// new timestamps and the ones whose value changed.
+func diffPoints(before, after []Point) ([]Point, []replacement) {
+	var added []Point
+	var replaced []replacement
+	i := 0
+	for _, p := range after {
+		for i < len(before) && before[i].Timestamp < p.Timestamp {
+			i++
+		}
+		switch {
+		case i == len(before) || before[i].Timestamp != p.Timestamp:
+			added = append(added, p)
+		case math.Float64bits(before[i].Value) != math.Float64bits(p.Value):
+			replaced = append(replaced, replacement{Timestamp: p.Timestamp, Old: before[i].Value, New: p.Value})
+		}
+	}
+	return added, replaced
+}
+
+// staleBuckets returns, for each rollup, the buckets holding a replaced
+// point, each mapped to whether the series still has all of the bucket's
+// raw points to rebuild it from. Must be called with ts locked, before the
+// points are replaced.
+func (ts *TimeSeries) staleBuckets(replaced []replacement) []map[int64]bool {
+	stale := make([]map[int64]bool, len(ts.Rollups))
+	for i, r := range ts.Rollups {
+		stale[i] = make(map[int64]bool)
+		for _, p := range replaced {
+			bucket := r.bucket(p.Timestamp)
+			if _, ok := stale[i][bucket]; ok || p.Timestamp > r.rolledUp {
+				continue
+			}
+			if a := r.find(bucket); a != nil {
+				points, _ := ts.points(bucket, min(bucket+r.Resolution-1, r.rolledUp))
+				stale[i][bucket] = int64(len(points)) >= a.Count
+			}
+		}
+	}
+	return stale
+}
+
+// rawPoints returns the series' points in [start, end] for rebuilding rollup
+// buckets. Must be called with ts locked.
+func (ts *TimeSeries) rawPoints(start, end int64) []Point {
+	points, _ := ts.points(start, end)
+	return points
+}
+
+// rolledUpPoints returns the prefix of time-ordered points at or before the
+// rollup's watermark, which add would ignore.
+func (r *Rollup) rolledUpPoints(points []Point) []Point {
+	return points[:sort.Search(len(points), func(i int) bool {
+		return points[i].Timestamp > r.rolledUp
+	})]
+}
+
+// find returns the aggregate of the bucket starting at bucket, or nil if
+// the rollup has none.
+func (r *Rollup) find(bucket int64) *Aggregate {
+	if r.pending.Count > 0 && r.pending.Timestamp == bucket {
+		return &r.pending
+	}
+	i := sort.Search(len(r.Aggregates), func(i int) bool {
+		return r.Aggregates[i].Timestamp >= bucket
+	})
+	if i < len(r.Aggregates) && r.Aggregates[i].Timestamp == bucket {
+		return &r.Aggregates[i]
+	}
+	return nil
+}
+
+// backfill folds time-ordered points into the rollup. Unlike add, it also
+// folds points at or before the watermark into the buckets they belong to.
+func (r *Rollup) backfill(points []Point) {
+	for _, p := range points {
+		if p.Timestamp > r.rolledUp {
+			r.add(p)
+			continue
+		}
+
+		bucket := r.bucket(p.Timestamp)
+		if a := r.find(bucket); a != nil {
+			a.add(pointAggregate(p, bucket))
+			continue
+		}
+
+		i := sort.Search(len(r.Aggregates), func(i int) bool {
+			return r.Aggregates[i].Timestamp >= bucket
+		})
+		r.Aggregates = append(r.Aggregates, Aggregate{})
+		copy(r.Aggregates[i+1:], r.Aggregates[i:])
+		r.Aggregates[i] = pointAggregate(p, bucket)
+	}
+}
+
+// replace brings the buckets in stale up to date with the replaced points.
+// Buckets whose raw points are all still there are rebuilt from them. The
+// others keep the replaced values in their minimum and maximum, but have
+// their sum corrected.
+func (r *Rollup) replace(replaced []replacement, stale map[int64]bool, points func(start, end int64) []Point) {
+	for bucket, complete := range stale {
+		if !complete {
+			continue
+		}
+		var rebuilt Aggregate
+		for _, p := range points(bucket, min(bucket+r.Resolution-1, r.rolledUp)) {
+			rebuilt.add(pointAggregate(p, bucket))
+		}
+		*r.find(bucket) = rebuilt
+	}
+	for _, p := range replaced {
+		bucket := r.bucket(p.Timestamp)
+		if complete, ok := stale[bucket]; ok && !complete {
+			a := r.find(bucket)
+			a.Min = math.Min(a.Min, p.New)
+			a.Max = math.Max(a.Max, p.New)
+			a.Sum += p.New - p.Old
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackfill(t *testing.T) {
+	db := NewDatabaseWithOptions(&Options{Rollups: []RollupTier{{Resolution: 100 * time.Second}}})
+	metric := "test_metric"
+	tags := map[string]string{"tag1": "value1"}
+	key := GenerateKey(metric, tags)
+	require.NoError(t, db.AddTimeSeries(metric, tags))
+
+	// Live data from 10000 on, none of it sealed yet
+	for i := range 10 {
+		require.NoError(t, db.AddPoint(metric, tags, int64(10000+i), 1.0))
+	}
+
+	var history []Point
+	for i := range ChunkSize + 10 {
+		history = append(history, Point{int64(i * 2), 2.0})
+	}
+	require.NoError(t, db.Backfill(metric, tags, history))
+
+	ts := db.GetShard(key).Series[key]
+	require.Len(t, ts.Chunks, 3)
+	assert.True(t, ts.Chunks[0].Compacted)
+	assert.Len(t, ts.Chunks[0].Points, ChunkSize)
+	assert.True(t, ts.Chunks[1].Compacted)
+	assert.Len(t, ts.Chunks[1].Points, 10)
+	assert.False(t, ts.Chunks[2].Compacted)
+	assert.Len(t, ts.Chunks[2].Points, 10)
+
+	// A second batch fills the gaps of the first and is merged with it
+	require.NoError(t, db.Backfill(metric, tags, []Point{{1, 3.0}, {3, 3.0}, {4, 4.0}}))
+	points, err := db.GetRange(metric, tags, 0, 5)
+	require.NoError(t, err)
+	assert.Equal(t, []Point{{0, 2.0}, {1, 3.0}, {2, 2.0}, {3, 3.0}, {4, 4.0}}, points)
+
+	// Backfilled points reach the rollups, including ones that replaced
+	// an already stored point
+	sums, err := db.GetRangeStep(metric, tags, 0, 99, 100, AggregateSum)
+	require.NoError(t, err)
+	assert.Equal(t, []Point{{0, 49*2.0 + 2*3.0 + 4.0}}, sums)
+	maxes, err := db.GetRangeStep(metric, tags, 0, 99, 100, AggregateMax)
+	require.NoError(t, err)
+	assert.Equal(t, []Point{{0, 4.0}}, maxes)
+	require.NoError(t, db.Backfill(metric, tags, []Point{{4, 1.0}}))
+	maxes, err = db.GetRangeStep(metric, tags, 0, 99, 100, AggregateMax)
+	require.NoError(t, err)
+	assert.Equal(t, []Point{{0, 3.0}}, maxes)
+
+	points, err = db.GetRange(metric, tags, 0, 1<<40)
+	require.NoError(t, err)
+	assert.Len(t, points, ChunkSize+10+2+10)
+
+	err = db.Backfill(metric, tags, []Point{{9999, 1.0}, {10000, 1.0}})
+	assert.ErrorIs(t, err, ErrOutOfBounds)
+	err = db.Backfill(metric, tags, []Point{{5, 1.0}, {3, 1.0}})
+	assert.ErrorIs(t, err, ErrNotSorted)
+	err = db.Backfill("missing", nil, []Point{{5, 1.0}})
+	assert.ErrorIs(t, err, ErrSeriesNotFound)
+}
+
+func TestBackfill_Tombstones(t *testing.T) {
+	db := NewDatabase()
+	metric := "test_metric"
+	require.NoError(t, db.AddTimeSeries(metric, nil))
+	require.NoError(t, db.Backfill(metric, nil, []Point{{1, 1.0}, {2, 2.0}, {3, 3.0}}))
+
+	_, err := db.Delete(metric, nil, 2, 3)
+	require.NoError(t, err)
+
+	// Points written back into a deleted range are not masked
+	require.NoError(t, db.Backfill(metric, nil, []Point{{3, 4.0}}))
+	points, err := db.GetRange(metric, nil, 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []Point{{1, 1.0}, {3, 4.0}}, points)
+}
+
+func TestBackfill_Reject(t *testing.T) {
+	db := NewDatabaseWithOptions(&Options{DuplicatePolicy: DuplicateReject})
+	metric := "test_metric"
+	require.NoError(t, db.AddTimeSeries(metric, nil))
+	require.NoError(t, db.Backfill(metric, nil, []Point{{1, 1.0}, {2, 2.0}}))
+
+	// Resending the same batch is fine, changing a value is not
+	require.NoError(t, db.Backfill(metric, nil, []Point{{1, 1.0}, {2, 2.0}}))
+	err := db.Backfill(metric, nil, []Point{{0, 0.0}, {2, 5.0}})
+	assert.ErrorIs(t, err, ErrDuplicate)
+
+	points, err := db.GetRange(metric, nil, 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []Point{{1, 1.0}, {2, 2.0}}, points)
+}
+
+func TestBackfill_Replay(t *testing.T) {
+	opts := &Options{WALDir: t.TempDir()}
+	db, err := Open(opts)
+	require.NoError(t, err)
+
+	metric := "test_metric"
+	require.NoError(t, db.AddTimeSeries(metric, nil))
+	require.NoError(t, db.AddPoint(metric, nil, 100, 1.0))
+	require.NoError(t, db.Backfill(metric, nil, []Point{{1, 1.0}, {2, 2.0}}))
+	require.NoError(t, db.Close())
+
+	db, err = Open(opts)
+	require.NoError(t, err)
+	defer db.Close()
+
+	points, err := db.GetRange(metric, nil, 0, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []Point{{1, 1.0}, {2, 2.0}, {100, 1.0}}, points)
+}
+
+func TestBackfill_ReplaySealed(t *testing.T) {
+	opts := &Options{ChunkSize: 4, WALDir: t.TempDir()}
+	db, err := Open(opts)
+	require.NoError(t, err)
+	metric := "test_metric"
+	require.NoError(t, db.AddTimeSeries(metric, nil))
+	for i := 10; i < 16; i++ {
+		require.NoError(t, db.AddPoint(metric, nil, int64(i), 1.0))
+	}
+	db.GetShard(GenerateKey(metric, nil)).CompactChunks()
+
+	// The backfill overlaps a chunk sealed by the compactor, which the WAL
+	// does not know about
+	require.NoError(t, db.Backfill(metric, nil, []Point{{11, 5.0}}))
+	want, err := db.GetRange(metric, nil, 0, 100)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	db, err = Open(opts)
+	require.NoError(t, err)
+	defer db.Close()
+	got, err := db.GetRange(metric, nil, 0, 100)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+	assert.Equal(t, Point{11, 5.0}, got[1])
+}
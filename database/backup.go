@@ -0,0 +1,384 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	backupPrefix   = "backup-"
+	backupManifest = "manifest.json"
+	backupIndex    = "index.gob"
+	backupChunks   = "chunks.gob"
+	backupVersion  = 1
+)
+
+// BackupManifest describes a backup directory. A full backup has no Parent;
+// an incremental one names the backup, in the same parent directory, it
+// builds on and only stores the sealed chunks that chain does not have.
+type BackupManifest struct {
+	Version       int                       `json:"version"`
+	Parent        string                    `json:"parent,omitempty"`
+	LSN           uint64                    `json:"lsn"`
+	Created       time.Time                 `json:"created"`
+	Series        int                       `json:"series"`
+	ChunksWritten int                       `json:"chunks_written"`
+	ChunksReused  int                       `json:"chunks_reused"`
+	Files         []SnapshotFile            `json:"files"`
+	Metadata      map[string]metricMetadata `json:"metadata,omitempty"`
+}
+
+// backupSeries is a series in a backup index. Sealed chunks are stored by
+// reference, open ones inline.
+type backupSeries struct {
+	Series snapshotSeries
+	// ChunkRefs holds, for each of Series.Chunks in order, the
+	// reference of the sealed chunk it stands for, or "" for an open
+	// chunk stored inline.
+	ChunkRefs []string
+}
+
+type backupChunk struct {
+	Ref    string
+	Points []Point
+}
+
+// chunkRef identifies a sealed chunk by the SHA-256 of its points, so a
+// chunk that changes after it was backed up, for example because a delete
+// was compacted into it, is backed up again.
+func chunkRef(points []Point) string {
+	h := sha256.New()
+	buf := make([]byte, 16)
+	for _, p := range points {
+		binary.LittleEndian.PutUint64(buf, uint64(p.Timestamp))
+		binary.LittleEndian.PutUint64(buf[8:], math.Float64bits(p.Value))
+		h.Write(buf)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Backup writes a backup of every series to a new directory under
+// opts.BackupDir and returns its path. If incremental is set and a previous
+// backup exists, only sealed chunks that are not already in that backup's
+// chain are copied; open heads are always copied in full.
+func (db *Database) Backup(incremental bool) (string, *BackupManifest, error) {
+	if db.opts.BackupDir == "" {
+		return "", nil, fmt.Errorf("backup directory: %w", ErrNotConfigured)
+	}
+
+	db.snapshotMu.Lock()
+	defer db.snapshotMu.Unlock()
+
+	known := make(map[string]bool)
+	var parent string
+	if incremental {
+		latest, err := latestDir(db.opts.BackupDir, backupPrefix)
+		if err != nil {
+			return "", nil, err
+		}
+		if latest != "" {
+			parent = filepath.Base(latest)
+			if err := backupRefs(latest, known); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+
+	lsn := db.lsn.Load()
+	metadata := db.copyMetadata()
+
+	manifest := &BackupManifest{
+		Version:  backupVersion,
+		Parent:   parent,
+		LSN:      lsn,
+		Created:  time.Now().UTC(),
+		Metadata: metadata,
+	}
+	path := filepath.Join(db.opts.BackupDir, fmt.Sprintf("%s%020d", backupPrefix, manifest.Created.UnixNano()))
+	tmp := path + ".tmp"
+	if err := os.MkdirAll(tmp, 0o755); err != nil {
+		return "", nil, err
+	}
+
+	if err := db.writeBackup(tmp, manifest, known); err != nil {
+		os.RemoveAll(tmp)
+		return "", nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", nil, err
+	}
+
+	return path, manifest, nil
+}
+
+func (db *Database) writeBackup(dir string, manifest *BackupManifest, known map[string]bool) error {
+	index, err := createChecksumFile(filepath.Join(dir, backupIndex))
+	if err != nil {
+		return err
+	}
+	defer index.Abort()
+
+	chunks, err := createChecksumFile(filepath.Join(dir, backupChunks))
+	if err != nil {
+		return err
+	}
+	defer chunks.Abort()
+
+	for _, shard := range db.shards() {
+		for _, ts := range shard.series() {
+			s, sealed, ok := ts.backup(known)
+			if !ok {
+				continue
+			}
+			for _, c := range sealed {
+				if err := chunks.Encode(&c); err != nil {
+					return err
+				}
+				known[c.Ref] = true
+			}
+			if err := index.Encode(&s); err != nil {
+				return err
+			}
+
+			manifest.Series++
+			manifest.ChunksWritten += len(sealed)
+			for _, ref := range s.ChunkRefs {
+				if ref != "" {
+					manifest.ChunksReused++
+				}
+			}
+		}
+	}
+	manifest.ChunksReused -= manifest.ChunksWritten
+
+	indexSum, err := index.Close()
+	if err != nil {
+		return err
+	}
+	chunksSum, err := chunks.Close()
+	if err != nil {
+		return err
+	}
+	manifest.Files = []SnapshotFile{
+		{Name: backupIndex, Series: manifest.Series, SHA256: indexSum},
+		{Name: backupChunks, SHA256: chunksSum},
+	}
+
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, backupManifest), b, 0o644)
+}
+
+// backup copies the series for a backup. Sealed chunks whose reference is
+// in known are stored by reference only; the others are returned to be
+// written to the backup's chunk file.
+func (ts *TimeSeries) backup(known map[string]bool) (backupSeries, []backupChunk, bool) {
+	ts.RLock()
+	defer ts.RUnlock()
+
+	if ts.removed {
+		return backupSeries{}, nil, false
+	}
+
+	s := backupSeries{
+		Series: snapshotSeries{
+			Metric:     ts.Metric,
+			Tags:       ts.Tags,
+			LSN:        ts.lsn,
+			Chunks:     make([]snapshotChunk, len(ts.Chunks)),
+			OutOfOrder: append([]Point(nil), ts.OutOfOrder...),
+			Tombstones: append([]Tombstone(nil), ts.Tombstones...),
+			Rollups:    ts.snapshotRollups(),
+		},
+		ChunkRefs: make([]string, len(ts.Chunks)),
+	}
+
+	var sealed []backupChunk
+	for i, chunk := range ts.Chunks {
+		if !chunk.Compacted {
+			s.Series.Chunks[i] = snapshotChunk{Points: append([]Point(nil), chunk.Points...)}
+			continue
+		}
+
+		// Only chunks sealed or changed since the last backup are hashed
+		if chunk.ref == "" {
+			chunk.ref = chunkRef(chunk.Points)
+		}
+		ref := chunk.ref
+		s.Series.Chunks[i] = snapshotChunk{Compacted: true}
+		s.ChunkRefs[i] = ref
+		if !known[ref] {
+			sealed = append(sealed, backupChunk{Ref: ref, Points: append([]Point(nil), chunk.Points...)})
+		}
+	}
+	return s, sealed, true
+}
+
+func readBackupManifest(dir string) (*BackupManifest, error) {
+	b, err := os.ReadFile(filepath.Join(dir, backupManifest))
+	if err != nil {
+		return nil, err
+	}
+	var manifest BackupManifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Version != backupVersion {
+		return nil, fmt.Errorf("unsupported backup version %d", manifest.Version)
+	}
+	return &manifest, nil
+}
+
+func (m *BackupManifest) file(name string) (SnapshotFile, error) {
+	for _, f := range m.Files {
+		if f.Name == name {
+			return f, nil
+		}
+	}
+	return SnapshotFile{}, fmt.Errorf("backup manifest lists no %s", name)
+}
+
+// backupChain returns the backup at dir followed by its ancestors, newest
+// first.
+func backupChain(dir string) ([]string, []*BackupManifest, error) {
+	var dirs []string
+	var manifests []*BackupManifest
+	seen := make(map[string]bool)
+	for dir != "" {
+		if seen[dir] {
+			return nil, nil, fmt.Errorf("backup chain loops at %s", dir)
+		}
+		seen[dir] = true
+
+		manifest, err := readBackupManifest(dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		dirs = append(dirs, dir)
+		manifests = append(manifests, manifest)
+
+		if manifest.Parent == "" {
+			break
+		}
+		dir = filepath.Join(filepath.Dir(dir), manifest.Parent)
+	}
+	return dirs, manifests, nil
+}
+
+// backupRefs adds the reference of every sealed chunk the backup at dir
+// points to, all of which its chain holds, to refs. Only the backup's index
+// is read, not the chunk files of the chain.
+func backupRefs(dir string, refs map[string]bool) error {
+	manifest, err := readBackupManifest(dir)
+	if err != nil {
+		return err
+	}
+	return readBackupIndex(dir, manifest, func(s *backupSeries) error {
+		for _, ref := range s.ChunkRefs {
+			if ref != "" {
+				refs[ref] = true
+			}
+		}
+		return nil
+	})
+}
+
+func readBackupIndex(dir string, manifest *BackupManifest, fn func(*backupSeries) error) error {
+	file, err := manifest.file(backupIndex)
+	if err != nil {
+		return err
+	}
+	dec, err := openChecksumFile(filepath.Join(dir, file.Name), file.SHA256)
+	if err != nil {
+		return err
+	}
+	for {
+		var s backupSeries
+		if err := dec.Decode(&s); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(&s); err != nil {
+			return err
+		}
+	}
+}
+
+func readBackupChunks(dir string, manifest *BackupManifest, fn func(*backupChunk) error) error {
+	file, err := manifest.file(backupChunks)
+	if err != nil {
+		return err
+	}
+	dec, err := openChecksumFile(filepath.Join(dir, file.Name), file.SHA256)
+	if err != nil {
+		return err
+	}
+	for {
+		var c backupChunk
+		if err := dec.Decode(&c); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(&c); err != nil {
+			return err
+		}
+	}
+}
+
+// RestoreBackup verifies the backup at dir and every backup it builds on,
+// stitches their chunks back together and adds the resulting series to
+// the database, which should be empty.
+func (db *Database) RestoreBackup(dir string) error {
+	dirs, manifests, err := backupChain(dir)
+	if err != nil {
+		return err
+	}
+
+	chunks := make(map[string][]Point)
+	for i, dir := range dirs {
+		if err := readBackupChunks(dir, manifests[i], func(c *backupChunk) error {
+			if chunkRef(c.Points) != c.Ref {
+				return fmt.Errorf("%s: chunk %s does not match its checksum", filepath.Base(dir), c.Ref)
+			}
+			chunks[c.Ref] = c.Points
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	if err := readBackupIndex(dir, manifests[0], func(s *backupSeries) error {
+		for i, ref := range s.ChunkRefs {
+			if ref == "" {
+				continue
+			}
+			points, ok := chunks[ref]
+			if !ok {
+				return fmt.Errorf("series %s: chunk %s missing from backup chain", GenerateKey(s.Series.Metric, s.Series.Tags), ref)
+			}
+			s.Series.Chunks[i].Points = points
+		}
+		db.restoreSeries(&s.Series, false)
+		return nil
+	}); err != nil {
+		return err
+	}
+	db.restoreMetadata(manifests[0].Metadata)
+
+	if manifests[0].LSN > db.lsn.Load() {
+		db.lsn.Store(manifests[0].LSN)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackup_Incremental(t *testing.T) {
+	opts := &Options{BackupDir: t.TempDir(), OutOfOrderWindow: 1 << 20}
+	db := NewDatabaseWithOptions(opts)
+
+	metric := "test_metric"
+	tags := map[string]string{"tag1": "value1"}
+	key := GenerateKey(metric, tags)
+	require.NoError(t, db.AddTimeSeries(metric, tags))
+	for i := range ChunkSize + 5 {
+		require.NoError(t, db.AddPoint(metric, tags, int64(i), float64(i)))
+	}
+	db.GetShard(key).CompactChunks()
+
+	full, manifest, err := db.Backup(true)
+	require.NoError(t, err)
+	assert.Empty(t, manifest.Parent)
+	assert.Equal(t, 1, manifest.ChunksWritten)
+	assert.Equal(t, 0, manifest.ChunksReused)
+
+	// Fill and seal the second chunk, then start a third
+	for i := ChunkSize + 5; i < 2*ChunkSize+10; i++ {
+		require.NoError(t, db.AddPoint(metric, tags, int64(i), float64(i)))
+	}
+	db.GetShard(key).CompactChunks()
+	require.NoError(t, db.AddTimeSeries("later_metric", nil))
+	require.NoError(t, db.AddPoint("later_metric", nil, 1, 1.0))
+
+	incr, manifest, err := db.Backup(true)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Base(full), manifest.Parent)
+	assert.Equal(t, 1, manifest.ChunksWritten)
+	assert.Equal(t, 1, manifest.ChunksReused)
+	assert.Equal(t, 2, manifest.Series)
+
+	restored := NewDatabase()
+	require.NoError(t, restored.RestoreBackup(incr))
+
+	want, err := db.GetRange(metric, tags, 0, 1<<40)
+	require.NoError(t, err)
+	got, err := restored.GetRange(metric, tags, 0, 1<<40)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	ts := restored.GetShard(key).Series[key]
+	require.Len(t, ts.Chunks, 3)
+	assert.True(t, ts.Chunks[1].Compacted)
+	assert.False(t, ts.Chunks[2].Compacted)
+
+	points, err := restored.GetRange("later_metric", nil, 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []Point{{1, 1.0}}, points)
+	assert.Equal(t, db.lsn.Load(), restored.lsn.Load())
+
+	// Overwriting a point of a sealed chunk backs that chunk up again
+	require.NoError(t, db.AddPoint(metric, tags, ChunkSize+5, -1))
+	_, manifest, err = db.Backup(true)
+	require.NoError(t, err)
+	assert.Equal(t, 1, manifest.ChunksWritten)
+	assert.Equal(t, 1, manifest.ChunksReused)
+
+	// A non-incremental backup copies every sealed chunk again
+	_, manifest, err = db.Backup(false)
+	require.NoError(t, err)
+	assert.Empty(t, manifest.Parent)
+	assert.Equal(t, 2, manifest.ChunksWritten)
+}
+
+func TestRestoreBackup_Checksum(t *testing.T) {
+	opts := &Options{BackupDir: t.TempDir()}
+	db := NewDatabaseWithOptions(opts)
+	require.NoError(t, db.AddTimeSeries("test_metric", nil))
+	for i := range ChunkSize {
+		require.NoError(t, db.AddPoint("test_metric", nil, int64(i), float64(i)))
+	}
+	db.GetShard(GenerateKey("test_metric", nil)).CompactChunks()
+
+	full, _, err := db.Backup(true)
+	require.NoError(t, err)
+	incr, _, err := db.Backup(true)
+	require.NoError(t, err)
+
+	// Corrupting the full backup breaks every incremental built on it
+	require.NoError(t, os.WriteFile(filepath.Join(full, backupChunks), []byte("garbage"), 0o644))
+	err = NewDatabase().RestoreBackup(incr)
+	assert.ErrorContains(t, err, "checksum mismatch")
+
+	require.NoError(t, os.RemoveAll(full))
+	err = NewDatabase().RestoreBackup(incr)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestBackup_NotConfigured(t *testing.T) {
+	_, _, err := NewDatabase().Backup(false)
+	assert.ErrorIs(t, err, ErrNotConfigured)
+}
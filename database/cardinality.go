@@ -0,0 +1,174 @@
+package database
+
+import (
+	"sort"
+	"unsafe"
+
+	"github.com/axiomhq/hyperloglog"
+)
+
+// CardinalityStat is one entry of a CardinalityReport ranking.
+type CardinalityStat struct {
+	Name  string
+	Value uint64
+}
+
+// CardinalityReport breaks down where the database's series, and the
+// memory they take up, come from.
+type CardinalityReport struct {
+	Series int
+	// Approximate is set if TopTagKeys and TopTagValues were estimated.
+	Approximate bool
+	// TopMetrics ranks metric names by series count.
+	TopMetrics []CardinalityStat
+	// TopTagKeys ranks tag names by distinct values.
+	TopTagKeys []CardinalityStat
+	// TopTagValues ranks name=value tag pairs by series count.
+	TopTagValues []CardinalityStat
+	// TopMemory ranks metric names by the estimated bytes their series'
+	// chunks, out-of-order heads and rollups take up.
+	TopMemory []CardinalityStat
+}
+
+// approximateCapacity is how many tag pairs per requested result the
+// approximate mode keeps counters for.
+const approximateCapacity = 10
+
+// Cardinality walks the series of tenant, "" being the default tenant, and
+// returns the top limit entries of each ranking. The tenant label itself is
+// left out of the tag rankings. In approximate mode, distinct tag values are
+// counted with HyperLogLog sketches and tag pairs with a bounded
+// heavy-hitters summary, so memory use does not grow with the number of
+// distinct values.
+func (db *Database) Cardinality(tenant string, limit int, approximate bool) *CardinalityReport {
+	if limit <= 0 {
+		limit = 10
+	}
+
+	report := &CardinalityReport{Approximate: approximate}
+	seriesPerMetric := make(map[string]uint64)
+	bytesPerMetric := make(map[string]uint64)
+
+	exactValues := make(map[string]map[string]struct{})
+	exactPairs := make(map[string]uint64)
+	sketches := make(map[string]*hyperloglog.Sketch)
+	pairs := newHeavyHitters(limit * approximateCapacity)
+
+	for _, ts := range db.index.selectSeries([]*Matcher{{Type: MatchEqual, Name: TenantLabel, Value: tenant}}) {
+		report.Series++
+		seriesPerMetric[ts.Metric]++
+		bytesPerMetric[ts.Metric] += uint64(ts.memory())
+
+		for name, value := range ts.Tags {
+			if name == TenantLabel {
+				continue
+			}
+			pair := name + "=" + value
+			if approximate {
+				sk := sketches[name]
+				if sk == nil {
+					sk = hyperloglog.New14()
+					sketches[name] = sk
+				}
+				sk.Insert([]byte(value))
+				pairs.add(pair)
+				continue
+			}
+
+			values := exactValues[name]
+			if values == nil {
+				values = make(map[string]struct{})
+				exactValues[name] = values
+			}
+			values[value] = struct{}{}
+			exactPairs[pair]++
+		}
+	}
+
+	valuesPerKey := make(map[string]uint64)
+	if approximate {
+		for name, sk := range sketches {
+			valuesPerKey[name] = sk.Estimate()
+		}
+		exactPairs = pairs.counts
+	} else {
+		for name, values := range exactValues {
+			valuesPerKey[name] = uint64(len(values))
+		}
+	}
+
+	report.TopMetrics = topStats(seriesPerMetric, limit)
+	report.TopTagKeys = topStats(valuesPerKey, limit)
+	report.TopTagValues = topStats(exactPairs, limit)
+	report.TopMemory = topStats(bytesPerMetric, limit)
+	return report
+}
+
+// memory estimates the bytes the series' data takes up.
+func (ts *TimeSeries) memory() int {
+	ts.RLock()
+	defer ts.RUnlock()
+
+	pointSize := int(unsafe.Sizeof(Point{}))
+	n := int(unsafe.Sizeof(TimeSeries{})) + len(ts.Metric)
+	for name, value := range ts.Tags {
+		n += len(name) + len(value)
+	}
+	for _, chunk := range ts.Chunks {
+		n += int(unsafe.Sizeof(Chunk{})) + cap(chunk.Points)*pointSize
+	}
+	n += cap(ts.OutOfOrder) * pointSize
+	for _, r := range ts.Rollups {
+		n += cap(r.Aggregates) * int(unsafe.Sizeof(Aggregate{}))
+	}
+	return n
+}
+
+// topStats returns the limit entries of counts with the highest values,
+// breaking ties by name.
+func topStats(counts map[string]uint64, limit int) []CardinalityStat {
+	stats := make([]CardinalityStat, 0, len(counts))
+	for name, value := range counts {
+		stats = append(stats, CardinalityStat{Name: name, Value: value})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Value != stats[j].Value {
+			return stats[i].Value > stats[j].Value
+		}
+		return stats[i].Name < stats[j].Name
+	})
+	if len(stats) > limit {
+		stats = stats[:limit]
+	}
+	return stats
+}
+
+// heavyHitters is a Space-Saving summary: it counts at most capacity items
+// and, when full, hands the slot of the least counted item to a new one.
+// Every item seen more than total/capacity times is guaranteed to be kept,
+// with a count that overestimates by at most that much.
+type heavyHitters struct {
+	capacity int
+	counts   map[string]uint64
+}
+
+func newHeavyHitters(capacity int) *heavyHitters {
+	return &heavyHitters{capacity: capacity, counts: make(map[string]uint64, capacity)}
+}
+
+func (h *heavyHitters) add(item string) {
+	if _, ok := h.counts[item]; ok || len(h.counts) < h.capacity {
+		h.counts[item]++
+		return
+	}
+
+	var minItem string
+	minCount := ^uint64(0)
+	for k, c := range h.counts {
+		if c < minCount {
+			minItem, minCount = k, c
+		}
+	}
+	delete(h.counts, minItem)
+	h.counts[item] = minCount + 1
+}
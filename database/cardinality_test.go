@@ -0,0 +1,63 @@
+package database
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCardinality(t *testing.T) {
+	db := NewDatabase()
+	for i := range 100 {
+		require.NoError(t, db.AddTimeSeries("http_requests", map[string]string{
+			"request_id": fmt.Sprint(i),
+			"method":     []string{"GET", "POST"}[i%2],
+		}))
+	}
+	for i := range 3 {
+		tags := map[string]string{"host": fmt.Sprint(i), "method": "GET"}
+		require.NoError(t, db.AddTimeSeries("cpu_usage", tags))
+		for j := range ChunkSize + 1 {
+			require.NoError(t, db.AddPoint("cpu_usage", tags, int64(j), 1.0))
+		}
+	}
+
+	report := db.Cardinality("", 2, false)
+	assert.Equal(t, 103, report.Series)
+	assert.Equal(t, []CardinalityStat{{"http_requests", 100}, {"cpu_usage", 3}}, report.TopMetrics)
+	assert.Equal(t, []CardinalityStat{{"request_id", 100}, {"host", 3}}, report.TopTagKeys)
+	assert.Equal(t, []CardinalityStat{{"method=GET", 53}, {"method=POST", 50}}, report.TopTagValues)
+	require.Len(t, report.TopMemory, 2)
+	// Every series preallocates a whole head chunk, so the many empty
+	// http_requests series outweigh the three two-chunk cpu_usage ones
+	assert.Equal(t, "cpu_usage", report.TopMemory[1].Name)
+	assert.Greater(t, report.TopMemory[1].Value, uint64(3*2*ChunkSize*16))
+
+	tags := map[string]string{TenantLabel: "team_a", "host": "other"}
+	require.NoError(t, db.AddTimeSeries("secret_metric", tags))
+	report = db.Cardinality("", 2, false)
+	assert.Equal(t, 103, report.Series)
+	report = db.Cardinality("team_a", 2, false)
+	assert.Equal(t, 1, report.Series)
+	assert.Equal(t, []CardinalityStat{{"secret_metric", 1}}, report.TopMetrics)
+	assert.Equal(t, []CardinalityStat{{"host", 1}}, report.TopTagKeys)
+
+	report = db.Cardinality("", 2, true)
+	assert.True(t, report.Approximate)
+	assert.Equal(t, "request_id", report.TopTagKeys[0].Name)
+	assert.InDelta(t, 100, report.TopTagKeys[0].Value, 5)
+	assert.Equal(t, "method=GET", report.TopTagValues[0].Name)
+}
+
+func TestHeavyHitters(t *testing.T) {
+	h := newHeavyHitters(3)
+	for i := range 1000 {
+		h.add("frequent")
+		h.add(fmt.Sprint("rare", i))
+	}
+
+	assert.Len(t, h.counts, 3)
+	assert.GreaterOrEqual(t, h.counts["frequent"], uint64(1000))
+}
@@ -0,0 +1,107 @@
+package database
+
+import (
+	"strconv"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sinnlos-ffff/tsdb-lite/metrics"
+)
+
+// Collector returns a collector of the database's current state, which is
+// read on every scrape.
+func (db *Database) Collector() prometheus.Collector {
+	return LazyCollector(func() *Database { return db })
+}
+
+// LazyCollector returns a collector of the state of the database db
+// returns on every scrape, which collects nothing while that is nil. It can
+// be registered before the database is opened.
+func LazyCollector(db func() *Database) prometheus.Collector {
+	return collector{db}
+}
+
+type collector struct {
+	db func() *Database
+}
+
+// chunkStates names the states chunks are counted under.
+var chunkStates = [...]string{"open", "compacted", "persisted"}
+
+// chunkCounts counts chunks by state, in the order of chunkStates.
+type chunkCounts [len(chunkStates)]int64
+
+// chunkTally keeps the database's chunkCounts up to date as series change,
+// so scrapes do not have to walk every series.
+type chunkTally [len(chunkStates)]atomic.Int64
+
+func (t *chunkTally) load() chunkCounts {
+	var counts chunkCounts
+	for i := range t {
+		counts[i] = t[i].Load()
+	}
+	return counts
+}
+
+func (c *Chunk) state() int {
+	switch {
+	case !c.Compacted:
+		return 0
+	case c.persisted.Load():
+		return 2
+	default:
+		return 1
+	}
+}
+
+// recountChunks brings the database's chunk tally up to date with the
+// series' chunks, none if it has been removed. It is called after anything
+// that adds, drops, seals or persists chunks. Must be called with ts locked.
+func (ts *TimeSeries) recountChunks() {
+	if ts.tally == nil {
+		return
+	}
+	var counts chunkCounts
+	if !ts.removed {
+		for _, chunk := range ts.Chunks {
+			counts[chunk.state()]++
+		}
+	}
+	for i := range counts {
+		if d := counts[i] - ts.counted[i]; d != 0 {
+			ts.tally[i].Add(d)
+		}
+	}
+	ts.counted = counts
+}
+
+func (c collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- metrics.ShardSeriesDesc
+	ch <- metrics.ChunksDesc
+	ch <- metrics.WALQueueDepthDesc
+	ch <- metrics.TenantSeriesDesc
+	ch <- metrics.MaxMetricSeriesDesc
+}
+
+func (c collector) Collect(ch chan<- prometheus.Metric) {
+	db := c.db()
+	if db == nil {
+		return
+	}
+
+	for i, shard := range db.shards() {
+		shard.RLock()
+		n := len(shard.Series)
+		shard.RUnlock()
+		ch <- prometheus.MustNewConstMetric(metrics.ShardSeriesDesc, prometheus.GaugeValue, float64(n), strconv.Itoa(i))
+	}
+
+	for state, n := range db.chunks.load() {
+		ch <- prometheus.MustNewConstMetric(metrics.ChunksDesc, prometheus.GaugeValue, float64(n), chunkStates[state])
+	}
+	ch <- prometheus.MustNewConstMetric(metrics.WALQueueDepthDesc, prometheus.GaugeValue, float64(db.WALQueueDepth()))
+	for tenant, n := range db.seriesPerTenant() {
+		ch <- prometheus.MustNewConstMetric(metrics.TenantSeriesDesc, prometheus.GaugeValue, float64(n), tenant)
+	}
+	ch <- prometheus.MustNewConstMetric(metrics.MaxMetricSeriesDesc, prometheus.GaugeValue, float64(db.index.maxMetricSeries()))
+}
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollector(t *testing.T) {
+	opts := &Options{ShardCount: 2, ChunkSize: 4, SnapshotDir: t.TempDir()}
+	db, err := Open(opts)
+	require.NoError(t, err)
+
+	require.NoError(t, db.AddTimeSeries("cpu_usage", nil))
+	for i := range 10 {
+		require.NoError(t, db.AddPoint("cpu_usage", nil, int64(i), float64(i)))
+	}
+
+	expectChunks := func(open, compacted, persisted int) {
+		t.Helper()
+		expected := fmt.Sprintf(`
+# HELP tsdb_chunks Current number of chunks: open ones still taking points, compacted ones sealed since the last snapshot, and persisted ones held by a snapshot
+# TYPE tsdb_chunks gauge
+tsdb_chunks{state="compacted"} %d
+tsdb_chunks{state="open"} %d
+tsdb_chunks{state="persisted"} %d
+`, compacted, open, persisted)
+		assert.NoError(t, testutil.CollectAndCompare(db.Collector(), strings.NewReader(expected), "tsdb_chunks"))
+	}
+	expectChunks(3, 0, 0)
+
+	db.GetShard(GenerateKey("cpu_usage", nil)).CompactChunks()
+	expectChunks(1, 2, 0)
+
+	_, _, err = db.Snapshot()
+	require.NoError(t, err)
+	expectChunks(1, 0, 2)
+
+	// Chunks restored from a snapshot stay persisted
+	db, err = Open(opts)
+	require.NoError(t, err)
+	expectChunks(1, 0, 2)
+
+	assert.Equal(t, 3, testutil.CollectAndCount(db.Collector(), "tsdb_shard_series", "tsdb_wal_queue_depth"))
+
+	require.NoError(t, db.AddTimeSeries("cpu_usage", map[string]string{"host": "a"}))
+	require.NoError(t, db.AddTimeSeries("cpu_usage", map[string]string{"host": "a", TenantLabel: "team_a"}))
+	assert.NoError(t, testutil.CollectAndCompare(db.Collector(), strings.NewReader(`
+# HELP tsdb_max_metric_series Current number of series of the metric name with the most series in any one tenant
+# TYPE tsdb_max_metric_series gauge
+tsdb_max_metric_series 2
+`), "tsdb_max_metric_series"))
+}
+
+func TestChunkTally(t *testing.T) {
+	opts := &Options{ChunkSize: 4, WALDir: t.TempDir(), Retention: time.Hour, OutOfOrderWindow: 100}
+	db, err := Open(opts)
+	require.NoError(t, err)
+
+	// The tally always matches a walk of every series
+	expectTally := func() {
+		t.Helper()
+		var want chunkCounts
+		for _, shard := range db.shards() {
+			for _, ts := range shard.series() {
+				ts.RLock()
+				for _, chunk := range ts.Chunks {
+					want[chunk.state()]++
+				}
+				ts.RUnlock()
+			}
+		}
+		assert.Equal(t, want, db.chunks.load())
+	}
+
+	for _, metric := range []string{"cpu_usage", "memory_usage"} {
+		require.NoError(t, db.AddTimeSeries(metric, nil))
+		for i := range 10 {
+			require.NoError(t, db.AddPoint(metric, nil, int64(100+i), float64(i)))
+		}
+	}
+	compact := func() {
+		for _, shard := range db.shards() {
+			shard.CompactChunks()
+		}
+	}
+	expectTally()
+	compact()
+	expectTally()
+	require.NoError(t, db.AddPoint("cpu_usage", nil, 50, 1.0))
+	require.NoError(t, db.Backfill("memory_usage", nil, []Point{{1, 1.0}, {2, 2.0}}))
+	compact()
+	expectTally()
+
+	_, err = db.Delete("cpu_usage", nil, 100, 104)
+	require.NoError(t, err)
+	expectTally()
+	_, err = db.Delete("memory_usage", nil, math.MinInt64, math.MaxInt64)
+	require.NoError(t, err)
+	expectTally()
+
+	db.ExpireChunks(time.Unix(3600+106, 0))
+	expectTally()
+	require.NoError(t, db.Close())
+
+	db, err = Open(opts)
+	require.NoError(t, err)
+	expectTally()
+}
@@ -1,13 +1,16 @@
 package database
 
 import (
-	"errors"
+	"context"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cespare/xxhash/v2"
 	"github.com/sinnlos-ffff/tsdb-lite/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Point struct {
@@ -15,12 +18,26 @@ type Point struct {
 	Value     float64
 }
 
+// ChunkSize is the default number of points per chunk.
 const ChunkSize = 2048
 
 type Chunk struct {
 	Points    []Point
 	Count     int
 	Compacted bool
+	// persisted is set once a snapshot on disk holds the compacted chunk.
+	persisted atomic.Bool
+	// ref caches the chunkRef of a compacted chunk for Backup, which
+	// db.snapshotMu serializes, or is "" if it has not been computed.
+	ref string
+}
+
+// changed records that the points of a compacted chunk were modified in
+// place, so that neither a snapshot nor a backup reference describes it any
+// more. Must be called with the series locked.
+func (c *Chunk) changed() {
+	c.persisted.Store(false)
+	c.ref = ""
 }
 
 type TimeSeries struct {
@@ -28,6 +45,24 @@ type TimeSeries struct {
 	Metric string
 	Tags   map[string]string
 	Chunks []*Chunk
+	// OutOfOrder holds samples older than the newest point in Chunks,
+	// sorted by timestamp, until the compactor merges them into Chunks.
+	OutOfOrder []Point
+	Rollups    []*Rollup
+	Tombstones []Tombstone
+
+	duplicatePolicy DuplicatePolicy
+	// chunkSize is the number of points per chunk, ChunkSize if zero.
+	chunkSize int
+	// lsn is the LSN of the newest write applied to the series.
+	lsn uint64
+	// removed is set once the series has been dropped from its shard, so
+	// writers that looked it up just before can tell.
+	removed bool
+	// tally is the database's chunk tally, and counted what the series
+	// last added to it.
+	tally   *chunkTally
+	counted chunkCounts
 }
 
 type Shard struct {
@@ -35,126 +70,463 @@ type Shard struct {
 	Series map[string]*TimeSeries
 }
 
-func (s *Shard) CompactChunks() {
-	s.Lock()
-	defer s.Unlock()
+// CompactChunks compacts every series in the shard and returns how many
+// series it went through and how many chunks it sealed.
+func (s *Shard) CompactChunks() (series, sealed int) {
+	return s.compactChunks(context.Background())
+}
+
+// compactChunks is CompactChunks, stopping between series once ctx is done.
+func (s *Shard) compactChunks(ctx context.Context) (series, sealed int) {
+	s.RLock()
+	defer s.RUnlock()
 
 	for _, ts := range s.Series {
-		ts.Lock()
-		defer ts.Unlock()
+		if ctx.Err() != nil {
+			break
+		}
+		sealed += ts.compact()
+		series++
+	}
+	return series, sealed
+}
 
-		for _, chunk := range ts.Chunks {
-			if chunk.Compacted || chunk.Count < ChunkSize {
-				continue
-			}
+func (ts *TimeSeries) compact() int {
+	ts.Lock()
+	defer ts.Unlock()
+	defer ts.recountChunks()
 
-			sort.Slice(chunk.Points[:ChunkSize], func(i, j int) bool {
-				return chunk.Points[i].Timestamp < chunk.Points[j].Timestamp
-			})
+	ts.applyTombstones()
+	ts.mergeOutOfOrder()
 
-			chunk.Compacted = true
-			metrics.CompactedChunksTotal.Inc()
+	// Only the last chunk takes new points, so every other one is sealed
+	// even if merging late points split it short
+	sealed := 0
+	for i, chunk := range ts.Chunks {
+		if chunk.Compacted || (i == len(ts.Chunks)-1 && chunk.Count < ts.maxChunkSize()) {
+			continue
 		}
+		ts.seal(chunk)
+		sealed++
 	}
+	return sealed
 }
 
-func (s *Shard) RunCompactor(interval time.Duration) {
-	go func() {
-		ticker := time.NewTicker(interval)
-		defer ticker.Stop()
+// seal sorts and deduplicates an open chunk, marks it compacted and feeds
+// it to the rollups. Must be called with ts locked.
+func (ts *TimeSeries) seal(chunk *Chunk) {
+	sort.SliceStable(chunk.Points, func(i, j int) bool {
+		return chunk.Points[i].Timestamp < chunk.Points[j].Timestamp
+	})
+	chunk.Points = dedupe(chunk.Points, ts.duplicatePolicy)
+	chunk.Count = len(chunk.Points)
 
-		for range ticker.C {
-			s.CompactChunks()
-		}
-	}()
+	chunk.Compacted = true
+	ts.rollupChunk(chunk)
+	metrics.CompactedChunksTotal.Inc()
+}
+
+type Options struct {
+	// ShardCount is the number of shards the database starts with. It can
+	// be changed later with Reshard.
+	ShardCount int
+	// ChunkSize is the number of points per chunk, ChunkSize if zero.
+	ChunkSize int
+	// OutOfOrderWindow is how far behind the newest point of a series a
+	// sample may be, in timestamp units, and still be accepted. Older
+	// samples are rejected with ErrOutOfBounds. Zero accepts samples
+	// however late they are.
+	OutOfOrderWindow int64
+	// DuplicatePolicy decides what happens to a sample whose timestamp is
+	// already present in its series. MetricDuplicatePolicies overrides it
+	// for individual metric names.
+	DuplicatePolicy         DuplicatePolicy
+	MetricDuplicatePolicies map[string]DuplicatePolicy
+	// Retention is how long points are kept, with timestamps read as Unix
+	// seconds. MetricRetention overrides it for metric names starting with
+	// a given prefix; the longest matching prefix wins. Zero keeps points
+	// forever.
+	Retention       time.Duration
+	MetricRetention map[string]time.Duration
+	// Rollups lists the lower-resolution tiers kept for every series.
+	Rollups []RollupTier
+	Limits  Limits
+	// Tenants holds the quotas of tenants, by tenant ID. If it is not
+	// empty, only the tenants it lists and the default tenant may write.
+	Tenants map[string]TenantLimits
+
+	// WALDir, if set, is where Open logs writes and replays them from.
+	// Writes return once their record is buffered, or, with WALSync, once
+	// the group commit that syncs it to disk is done. They are visible to
+	// readers from before then, and stay so if the WAL fails them.
+	WALDir           string
+	WALFlushInterval time.Duration
+	WALSync          bool
+	// SnapshotDir is where Snapshot writes to and, unless RestoreSnapshot
+	// names a specific snapshot, where Open restores the newest one from.
+	SnapshotDir     string
+	RestoreSnapshot string
+	// BackupDir is where Backup writes full and incremental backups to.
+	BackupDir string
+}
+
+func DefaultOptions() *Options {
+	return &Options{
+		ShardCount:       32,
+		WALFlushInterval: 20 * time.Millisecond,
+	}
 }
 
 type Database struct {
+	// mu guards Shards. Operations that add or remove series hold it for
+	// reading, so Reshard can swap in a new array under the write lock.
+	mu     sync.RWMutex
 	Shards []*Shard
+	opts   Options
+	// generation counts how many times Shards has been replaced.
+	generation int
+	index      *index
+	// live holds the options Reload can change.
+	live atomic.Pointer[ReloadableOptions]
+
+	// lsn is the LSN of the newest write. Writes are assigned LSNs even
+	// without a WAL, so snapshots can tell them apart.
+	lsn        atomic.Uint64
+	wal        *WAL
+	snapshotMu sync.Mutex
+
+	metadataMu sync.RWMutex
+	// metadata holds the Metadata of each metric name that has some.
+	metadata map[string]metricMetadata
+
+	limiters tenantLimiters
+	// background tracks the compactors and retention, so Close can wait
+	// for them.
+	background sync.WaitGroup
+
+	// chunks counts the chunks of every series by state.
+	chunks chunkTally
+
+	reshardMu sync.Mutex
+	changesMu sync.Mutex
+	// changes collects the keys of series added or removed while a
+	// Reshard is copying the current shards.
+	changes map[string]struct{}
 }
 
 func NewDatabase() *Database {
+	return NewDatabaseWithOptions(DefaultOptions())
+}
+
+func NewDatabaseWithOptions(opts *Options) *Database {
+	shardCount := opts.ShardCount
+	if shardCount <= 0 {
+		shardCount = DefaultOptions().ShardCount
+	}
+	metrics.Shards.Set(float64(shardCount))
+	opts.Limits.export()
 	db := &Database{
-		// TODO: Dynamically update shard length.
-		Shards: make([]*Shard, 32),
+		Shards: newShards(shardCount),
+		opts:   *opts,
+		index:  newIndex(),
 	}
-	for i := range db.Shards {
-		db.Shards[i] = &Shard{
+	db.live.Store(&ReloadableOptions{
+		Retention:       opts.Retention,
+		MetricRetention: opts.MetricRetention,
+		Limits:          opts.Limits,
+		Tenants:         opts.Tenants,
+	})
+	return db
+}
+
+// ReloadableOptions are the Options that Reload can change while the
+// database is running.
+type ReloadableOptions struct {
+	Retention       time.Duration
+	MetricRetention map[string]time.Duration
+	Limits          Limits
+	Tenants         map[string]TenantLimits
+}
+
+// Reload replaces the reloadable options. Series already over a new limit
+// are kept, and tenants' ingestion budgets start afresh.
+func (db *Database) Reload(opts ReloadableOptions) {
+	db.live.Store(&opts)
+	opts.Limits.export()
+
+	db.limiters.Lock()
+	db.limiters.limiters = nil
+	db.limiters.Unlock()
+}
+
+func newShards(n int) []*Shard {
+	shards := make([]*Shard, n)
+	for i := range shards {
+		shards[i] = &Shard{
 			Series: make(map[string]*TimeSeries),
 		}
 	}
-	return db
+	return shards
+}
+
+func shardIndex(key string, n int) int {
+	return int(xxhash.Sum64String(key) % uint64(n))
 }
 
 func (db *Database) GetShard(key string) *Shard {
-	shardIndex := xxhash.Sum64String(key) % uint64(len(db.Shards))
-	return db.Shards[shardIndex]
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.getShard(key)
 }
 
-func (db *Database) StartCompactors(interval time.Duration) {
-	for i := range db.Shards {
-		db.Shards[i].RunCompactor(interval)
+// getShard is GetShard for callers already holding db.mu.
+func (db *Database) getShard(key string) *Shard {
+	return db.Shards[shardIndex(key, len(db.Shards))]
+}
+
+// shards returns the current shard array. Series in a shard it returns may
+// move to a new array at any time, so callers that add or remove series
+// must hold db.mu instead.
+func (db *Database) shards() []*Shard {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.Shards
+}
+
+// StartCompactors compacts every shard, in parallel, once per interval
+// until ctx is done.
+func (db *Database) StartCompactors(ctx context.Context, interval time.Duration) {
+	db.background.Add(1)
+	go func() {
+		defer db.background.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			db.compact(ctx)
+		}
+	}()
+}
+
+// compact compacts every shard in parallel, tracing the pass as a new root
+// span.
+func (db *Database) compact(ctx context.Context) {
+	_, span := tracer.Start(ctx, "Database.Compact", trace.WithNewRoot())
+	defer span.End()
+
+	start := time.Now()
+	shards := db.shards()
+	var series, sealed atomic.Int64
+	var wg sync.WaitGroup
+	for _, shard := range shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n, m := shard.compactChunks(ctx)
+			series.Add(int64(n))
+			sealed.Add(int64(m))
+		}()
 	}
+	wg.Wait()
+	metrics.CompactionDuration.Observe(time.Since(start).Seconds())
+	span.SetAttributes(attribute.Int("shards", len(shards)), attribute.Int64("series", series.Load()), attribute.Int64("chunks_sealed", sealed.Load()))
 }
 
 func (db *Database) AddTimeSeries(metric string, tags map[string]string) error {
+	commit, err := db.addTimeSeries(metric, tags)
+	if err != nil {
+		return err
+	}
+	return commit()
+}
+
+func (db *Database) addTimeSeries(metric string, tags map[string]string) (commit func() error, err error) {
 	key := GenerateKey(metric, tags)
-	shard := db.GetShard(key)
 
-	// TODO: error handling
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	shard := db.getShard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
 	if _, ok := shard.Series[key]; ok {
-		return errors.New("time series already exists")
+		return nil, seriesError(ErrSeriesExists, metric, tags)
+	}
+	limits := &db.live.Load().Limits
+	var tenantLimits TenantLimits
+	if isSelfMetric(metric) {
+		limits = &Limits{}
+	} else {
+		if err := limits.checkTags(tags); err != nil {
+			return nil, seriesError(err, metric, tags)
+		}
+		if tenantLimits, err = db.tenantLimits(TenantOf(tags)); err != nil {
+			return nil, seriesError(err, metric, tags)
+		}
+	}
+
+	// Reserve the series' place in the index first, as that is where the
+	// cardinality limits are enforced.
+	ts := db.newTimeSeries(metric, tags, 0)
+	if err := db.index.addLimited(key, ts, limits, tenantLimits); err != nil {
+		return nil, seriesError(err, metric, tags)
 	}
 
-	ts := TimeSeries{
+	rec := walRecord{Type: walSeries, Metric: metric, Tags: tags}
+	commit, err = db.log(&rec)
+	if err != nil {
+		db.index.remove(key, ts)
+		return nil, err
+	}
+
+	ts.lsn = rec.LSN
+	shard.Series[key] = ts
+	db.trackChange(key)
+	ts.Lock()
+	ts.recountChunks()
+	ts.Unlock()
+
+	return commit, nil
+}
+
+func (db *Database) chunkSize() int {
+	if db.opts.ChunkSize > 0 {
+		return db.opts.ChunkSize
+	}
+	return ChunkSize
+}
+
+// maxChunkSize returns the number of points per chunk of the series.
+func (ts *TimeSeries) maxChunkSize() int {
+	if ts.chunkSize > 0 {
+		return ts.chunkSize
+	}
+	return ChunkSize
+}
+
+func (db *Database) newTimeSeries(metric string, tags map[string]string, lsn uint64) *TimeSeries {
+	return &TimeSeries{
 		Metric: metric,
 		Tags:   tags,
 		Chunks: []*Chunk{{
-			Points: make([]Point, 0, ChunkSize),
+			Points: make([]Point, 0, db.chunkSize()),
 		}},
+		Rollups:         newRollups(db.opts.Rollups),
+		duplicatePolicy: db.duplicatePolicy(metric),
+		chunkSize:       db.chunkSize(),
+		lsn:             lsn,
+		tally:           &db.chunks,
 	}
+}
 
-	shard.Lock()
-	defer shard.Unlock()
+func (db *Database) AddPoint(metric string, tags map[string]string, timestamp int64, value float64) error {
+	return db.AddPointContext(context.Background(), metric, tags, timestamp, value)
+}
+
+// AddPointContext is AddPoint, traced as part of the span in ctx.
+func (db *Database) AddPointContext(ctx context.Context, metric string, tags map[string]string, timestamp int64, value float64) error {
+	ctx, span := tracer.Start(ctx, "Database.AddPoint", trace.WithAttributes(
+		attribute.String("metric", metric), attribute.Int64("timestamp", timestamp)))
+	defer span.End()
+
+	start := time.Now()
+	commit, err := db.addPoint(ctx, metric, tags, Point{Timestamp: timestamp, Value: value})
+	if err != nil {
+		return spanError(span, err)
+	}
+	if err := commitContext(ctx, commit); err != nil {
+		return spanError(span, err)
+	}
 
-	shard.Series[key] = &ts
+	metrics.IngestLatency.Observe(time.Since(start).Seconds())
+	db.countIngest(metric, tags, 1)
 
 	return nil
 }
 
-func (db *Database) AddPoint(metric string, tags map[string]string, timestamp int64, value float64) error {
-	start := time.Now()
-	key := GenerateKey(metric, tags)
-	shard := db.GetShard(key)
+func (db *Database) addPoint(ctx context.Context, metric string, tags map[string]string, p Point) (commit func() error, err error) {
+	ts, err := db.lookupContext(ctx, metric, tags)
+	if err != nil {
+		return nil, err
+	}
 
-	shard.RLock()
-	ts, ok := shard.Series[key]
-	shard.RUnlock()
+	ts.lockContext(ctx)
+	defer ts.Unlock()
 
-	if !ok {
-		return errors.New("time series does not exist")
+	if ts.removed {
+		return nil, seriesError(ErrSeriesNotFound, metric, tags)
+	}
+	if !isSelfMetric(metric) {
+		if err := db.allowIngest(TenantOf(tags), 1); err != nil {
+			return nil, seriesError(err, metric, tags)
+		}
 	}
 
-	ts.Lock()
-	defer ts.Unlock()
+	rec := walRecord{Type: walPoint, Metric: metric, Tags: tags, Timestamp: p.Timestamp, Value: p.Value}
+	err = ts.add(p, db.opts.OutOfOrderWindow, func() error {
+		commit, err = db.log(&rec)
+		return err
+	})
+	if err != nil {
+		return nil, seriesError(err, metric, tags)
+	}
+	ts.lsn = rec.LSN
+	return commit, nil
+}
 
-	if ts.Chunks[len(ts.Chunks)-1].Count == ChunkSize || ts.Chunks[len(ts.Chunks)-1].Compacted {
+// add checks p against the out-of-order window and the duplicate policy,
+// calls reserve if it is not nil and, once that succeeds, stores p. Must be
+// called with ts locked.
+func (ts *TimeSeries) add(p Point, window int64, reserve func() error) error {
+	maxT, ok := ts.maxTimestamp()
+	if ok && p.Timestamp < minTimestamp(maxT, window) {
+		return ErrOutOfBounds
+	}
+
+	existing, holder := ts.findPoint(p.Timestamp)
+	if existing != nil {
+		if err := ts.checkDuplicate(existing, p.Value); err != nil {
+			return err
+		}
+	}
+
+	if reserve != nil {
+		if err := reserve(); err != nil {
+			return err
+		}
+	}
+
+	if existing != nil {
+		ts.resolveDuplicate(existing, holder, p.Value)
+		return nil
+	}
+
+	if ok && p.Timestamp < maxT {
+		ts.insertOutOfOrder(p)
+		return nil
+	}
+
+	if ts.Chunks[len(ts.Chunks)-1].Count >= ts.maxChunkSize() || ts.Chunks[len(ts.Chunks)-1].Compacted {
 		ts.Chunks = append(ts.Chunks, &Chunk{
-			Points:    make([]Point, 0, ChunkSize),
+			Points:    make([]Point, 0, ts.maxChunkSize()),
 			Count:     0,
 			Compacted: false,
 		})
+		ts.recountChunks()
 	}
 
 	chunk := ts.Chunks[len(ts.Chunks)-1]
-	chunk.Points = append(chunk.Points, Point{
-		Timestamp: timestamp,
-		Value:     value,
-	})
+	chunk.Points = append(chunk.Points, p)
 	chunk.Count++
 
-	metrics.IngestLatency.Observe(time.Since(start).Seconds())
-	metrics.IngestTotal.Inc()
-
 	return nil
 }
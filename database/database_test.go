@@ -1,10 +1,12 @@
 package database
 
 import (
+	"context"
 	"math/rand"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestAddTimeSeries(t *testing.T) {
@@ -81,3 +83,36 @@ func TestCompactChunks(t *testing.T) {
 		assert.True(t, ts.Chunks[0].Points[i].Timestamp > ts.Chunks[0].Points[i-1].Timestamp)
 	}
 }
+
+func TestAddTimeSeries_Exists(t *testing.T) {
+	db := NewDatabase()
+	metric := "test_metric"
+	tags := map[string]string{"tag1": "value1"}
+
+	assert.NoError(t, db.AddTimeSeries(metric, tags))
+
+	err := db.AddTimeSeries(metric, tags)
+	assert.ErrorIs(t, err, ErrSeriesExists)
+
+	var seriesErr *SeriesError
+	assert.ErrorAs(t, err, &seriesErr)
+	assert.Equal(t, GenerateKey(metric, tags), seriesErr.Key())
+}
+
+func TestCompactChunks_Cancelled(t *testing.T) {
+	db := NewDatabaseWithOptions(&Options{ChunkSize: 4})
+	require.NoError(t, db.AddTimeSeries("test_metric", nil))
+	for i := range 8 {
+		require.NoError(t, db.AddPoint("test_metric", nil, int64(i), 1))
+	}
+
+	// A cancelled pass stops before the next series
+	key := GenerateKey("test_metric", nil)
+	shard := db.GetShard(key)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	series, sealed := shard.compactChunks(ctx)
+	assert.Zero(t, series)
+	assert.Zero(t, sealed)
+	assert.False(t, shard.Series[key].Chunks[0].Compacted)
+}
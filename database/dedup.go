@@ -0,0 +1,131 @@
+package database
+
+import (
+	"math"
+	"sort"
+
+	"github.com/sinnlos-ffff/tsdb-lite/metrics"
+)
+
+type DuplicatePolicy int
+
+const (
+	// DuplicateLastWriteWins overwrites the stored value.
+	DuplicateLastWriteWins DuplicatePolicy = iota
+	// DuplicateFirstWriteWins keeps the stored value and drops the new one.
+	DuplicateFirstWriteWins
+	// DuplicateReject returns ErrDuplicate unless the new value is identical
+	// to the stored one, in which case it is dropped like a retry.
+	DuplicateReject
+)
+
+func (p DuplicatePolicy) String() string {
+	switch p {
+	case DuplicateLastWriteWins:
+		return "last-write-wins"
+	case DuplicateFirstWriteWins:
+		return "first-write-wins"
+	case DuplicateReject:
+		return "reject"
+	default:
+		return "unknown"
+	}
+}
+
+func (db *Database) duplicatePolicy(metric string) DuplicatePolicy {
+	if p, ok := db.opts.MetricDuplicatePolicies[metric]; ok {
+		return p
+	}
+	return db.opts.DuplicatePolicy
+}
+
+// findPoint returns the stored point with the given timestamp and the
+// chunk holding it, which is nil for the out-of-order head, or nil if there
+// is no such point. Must be called with ts locked.
+func (ts *TimeSeries) findPoint(timestamp int64) (*Point, *Chunk) {
+	i := sort.Search(len(ts.OutOfOrder), func(i int) bool {
+		return ts.OutOfOrder[i].Timestamp >= timestamp
+	})
+	if i < len(ts.OutOfOrder) && ts.OutOfOrder[i].Timestamp == timestamp {
+		return &ts.OutOfOrder[i], nil
+	}
+
+	c := sort.Search(len(ts.Chunks), func(i int) bool {
+		points := ts.Chunks[i].Points
+		return len(points) == 0 || points[len(points)-1].Timestamp >= timestamp
+	})
+	if c == len(ts.Chunks) {
+		return nil, nil
+	}
+
+	chunk := ts.Chunks[c]
+	points := chunk.Points
+	i = sort.Search(len(points), func(i int) bool {
+		return points[i].Timestamp >= timestamp
+	})
+	if i < len(points) && points[i].Timestamp == timestamp && !(chunk.Compacted && ts.masked(timestamp)) {
+		return &points[i], chunk
+	}
+	return nil, nil
+}
+
+// checkDuplicate reports whether the series' duplicate policy rejects a new
+// value for an already stored point.
+func (ts *TimeSeries) checkDuplicate(existing *Point, value float64) error {
+	if ts.duplicatePolicy == DuplicateReject && math.Float64bits(existing.Value) != math.Float64bits(value) {
+		return ErrDuplicate
+	}
+	return nil
+}
+
+// resolveDuplicate applies the series' duplicate policy to a new value for
+// an already stored point, held by chunk unless chunk is nil. Must be
+// called with ts locked. Rollup buckets holding an overwritten sealed point
+// are rebuilt.
+func (ts *TimeSeries) resolveDuplicate(existing *Point, chunk *Chunk, value float64) {
+	if ts.duplicatePolicy == DuplicateLastWriteWins && math.Float64bits(existing.Value) != math.Float64bits(value) {
+		sealed := chunk != nil && chunk.Compacted
+		var replaced []replacement
+		var stale []map[int64]bool
+		if sealed {
+			replaced = []replacement{{Timestamp: existing.Timestamp, Old: existing.Value, New: value}}
+			stale = ts.staleBuckets(replaced)
+		}
+		existing.Value = value
+		if sealed {
+			persisted := chunk.persisted.Load()
+			chunk.changed()
+			if persisted {
+				ts.recountChunks()
+			}
+			for i, r := range ts.Rollups {
+				r.replace(replaced, stale[i], ts.rawPoints)
+			}
+		}
+	}
+	metrics.DuplicateSamplesTotal.Inc()
+}
+
+// dedupe removes points with repeated timestamps from a time-ordered slice
+// in place. Points with equal timestamps are assumed to be in write order.
+// DuplicateReject keeps the first point, as there is no writer left to
+// reject at that stage.
+func dedupe(points []Point, policy DuplicatePolicy) []Point {
+	if len(points) < 2 {
+		return points
+	}
+
+	n := 1
+	for i := 1; i < len(points); i++ {
+		if points[i].Timestamp != points[n-1].Timestamp {
+			points[n] = points[i]
+			n++
+			continue
+		}
+		if policy == DuplicateLastWriteWins {
+			points[n-1] = points[i]
+		}
+		metrics.DuplicateSamplesTotal.Inc()
+	}
+	return points[:n]
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddPoint_DuplicatePolicy(t *testing.T) {
+	tests := []struct {
+		policy  DuplicatePolicy
+		want    float64
+		wantErr error
+	}{
+		{DuplicateLastWriteWins, 2.0, nil},
+		{DuplicateFirstWriteWins, 1.0, nil},
+		{DuplicateReject, 1.0, ErrDuplicate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			db := NewDatabaseWithOptions(&Options{OutOfOrderWindow: 100, DuplicatePolicy: tt.policy})
+			metric := "test_metric"
+			tags := map[string]string{"tag1": "value1"}
+			require.NoError(t, db.AddTimeSeries(metric, tags))
+
+			// Duplicate of the newest point in the head
+			require.NoError(t, db.AddPoint(metric, tags, 1000, 1.0))
+			assert.ErrorIs(t, db.AddPoint(metric, tags, 1000, 2.0), tt.wantErr)
+
+			// Duplicate of an out-of-order point
+			require.NoError(t, db.AddPoint(metric, tags, 1100, 0.0))
+			require.NoError(t, db.AddPoint(metric, tags, 1050, 1.0))
+			assert.ErrorIs(t, db.AddPoint(metric, tags, 1050, 2.0), tt.wantErr)
+
+			// Resending the identical sample is never an error
+			assert.NoError(t, db.AddPoint(metric, tags, 1000, tt.want))
+
+			points, err := db.GetRange(metric, tags, 0, 2000)
+			require.NoError(t, err)
+			assert.Equal(t, []Point{{1000, tt.want}, {1050, tt.want}, {1100, 0.0}}, points)
+		})
+	}
+}
+
+func TestAddPoint_MetricDuplicatePolicy(t *testing.T) {
+	db := NewDatabaseWithOptions(&Options{
+		DuplicatePolicy:         DuplicateLastWriteWins,
+		MetricDuplicatePolicies: map[string]DuplicatePolicy{"strict_metric": DuplicateReject},
+	})
+	tags := map[string]string{"tag1": "value1"}
+	require.NoError(t, db.AddTimeSeries("strict_metric", tags))
+	require.NoError(t, db.AddTimeSeries("test_metric", tags))
+
+	require.NoError(t, db.AddPoint("strict_metric", tags, 1000, 1.0))
+	assert.ErrorIs(t, db.AddPoint("strict_metric", tags, 1000, 2.0), ErrDuplicate)
+
+	require.NoError(t, db.AddPoint("test_metric", tags, 1000, 1.0))
+	assert.NoError(t, db.AddPoint("test_metric", tags, 1000, 2.0))
+}
+
+func TestCompactChunks_Dedupe(t *testing.T) {
+	shard := &Shard{
+		Series: make(map[string]*TimeSeries),
+	}
+
+	ts := &TimeSeries{
+		Metric: "test_metric",
+		Chunks: []*Chunk{{
+			Points: make([]Point, ChunkSize),
+			Count:  ChunkSize,
+		}},
+		duplicatePolicy: DuplicateFirstWriteWins,
+	}
+	for i := range ChunkSize {
+		ts.Chunks[0].Points[i] = Point{Timestamp: int64(ChunkSize - i/2), Value: float64(i)}
+	}
+	shard.Series[GenerateKey(ts.Metric, nil)] = ts
+
+	shard.CompactChunks()
+
+	chunk := ts.Chunks[0]
+	assert.True(t, chunk.Compacted)
+	assert.Len(t, chunk.Points, ChunkSize/2)
+	assert.Equal(t, ChunkSize/2, chunk.Count)
+	for i := 1; i < len(chunk.Points); i++ {
+		assert.Greater(t, chunk.Points[i].Timestamp, chunk.Points[i-1].Timestamp)
+	}
+	// The first written value for each timestamp is kept
+	assert.Equal(t, Point{Timestamp: ChunkSize, Value: 0}, chunk.Points[len(chunk.Points)-1])
+}
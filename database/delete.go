@@ -0,0 +1,302 @@
+package database
+
+import "math"
+
+// Tombstone marks the points of a series' compacted chunks with
+// Start <= timestamp <= End as deleted until the compactor removes them.
+type Tombstone struct {
+	Start int64
+	End   int64
+}
+
+func (t Tombstone) contains(timestamp int64) bool {
+	return timestamp >= t.Start && timestamp <= t.End
+}
+
+func (t Tombstone) overlaps(start, end int64) bool {
+	return start <= t.End && end >= t.Start
+}
+
+// Delete removes the points with start <= timestamp <= end from every
+// series of the given metric that carries all of the given tags, and
+// returns how many series matched. Series left without data are removed.
+// Points in the head are dropped right away; points in compacted chunks
+// are masked by a tombstone and dropped on the next compaction.
+func (db *Database) Delete(metric string, tags map[string]string, start, end int64) (int, error) {
+	if metric == "" {
+		return 0, ErrInvalidSelector
+	}
+
+	// delete holds every shard lock while logging, so wait for room in
+	// the WAL queue before taking them.
+	if err := db.reserveLog(); err != nil {
+		return 0, err
+	}
+	var commit func() error
+	matched, err := db.delete(metric, tags, start, end, func() (uint64, error) {
+		rec := walRecord{Type: walDelete, Metric: metric, Tags: tags, Start: start, End: end}
+		var err error
+		commit, err = db.logReserved(&rec)
+		return rec.LSN, err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return matched, commit()
+}
+
+// delete applies a delete request to every matching series and returns how
+// many there are. It locks every shard, so no series comes or goes in the
+// meantime, and every matching series before calling lsn for the request's
+// LSN, so each series sees the request in LSN order with its own writes.
+// lsn is called exactly once.
+// Series that have seen the LSN already, which can only happen on replay,
+// are left alone.
+func (db *Database) delete(metric string, tags map[string]string, start, end int64, lsn func() (uint64, error)) (int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	for _, shard := range db.Shards {
+		shard.Lock()
+	}
+	defer func() {
+		for _, shard := range db.Shards {
+			shard.Unlock()
+		}
+	}()
+
+	matched := make(map[string]*TimeSeries)
+	for _, ts := range db.index.selectSeries(deleteMatchers(metric, tags)) {
+		// Retention drops series from the index only after their shard.
+		key := GenerateKey(ts.Metric, ts.Tags)
+		if db.getShard(key).Series[key] == ts {
+			matched[key] = ts
+		}
+	}
+	for _, ts := range matched {
+		ts.Lock()
+	}
+	defer func() {
+		for _, ts := range matched {
+			ts.Unlock()
+		}
+	}()
+
+	n, err := lsn()
+	if err != nil {
+		return 0, err
+	}
+	for key, ts := range matched {
+		if ts.lsn >= n {
+			continue
+		}
+		if ts.delete(start, end, n) {
+			delete(db.getShard(key).Series, key)
+			db.index.remove(key, ts)
+			db.trackChange(key)
+		}
+	}
+
+	return len(matched), nil
+}
+
+// deleteMatchers returns the matchers selecting the series Delete applies
+// to.
+func deleteMatchers(metric string, tags map[string]string) []*Matcher {
+	matchers := []*Matcher{{Type: MatchEqual, Name: MetricNameLabel, Value: metric}}
+	for name, value := range tags {
+		matchers = append(matchers, &Matcher{Type: MatchEqual, Name: name, Value: value})
+	}
+	return matchers
+}
+
+// matches reports whether the series is of metric, or metric is empty, and
+// carries all of tags. A tag with an empty value matches series without it.
+func (ts *TimeSeries) matches(metric string, tags map[string]string) bool {
+	if metric != "" && ts.Metric != metric {
+		return false
+	}
+	for k, v := range tags {
+		if ts.Tags[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// delete applies a delete request to the series and reports whether it
+// covered all of its data, in which case the series is marked removed.
+// Must be called with ts locked.
+func (ts *TimeSeries) delete(start, end int64, lsn uint64) bool {
+	defer ts.recountChunks()
+	ts.lsn = lsn
+
+	minT, maxT, ok := ts.bounds()
+	if (start == math.MinInt64 && end == math.MaxInt64) || (ok && start <= minT && end >= maxT) {
+		ts.removed = true
+		return true
+	}
+
+	tombstone := Tombstone{Start: start, End: end}
+	covered := ts.coveredBuckets(tombstone)
+
+	ts.OutOfOrder = removePoints(ts.OutOfOrder, tombstone)
+	masked := false
+	for _, chunk := range ts.Chunks {
+		if len(chunk.Points) == 0 {
+			continue
+		}
+		if chunk.Compacted {
+			if tombstone.overlaps(chunk.Points[0].Timestamp, chunk.Points[len(chunk.Points)-1].Timestamp) {
+				masked = true
+			}
+			continue
+		}
+		chunk.Points = removePoints(chunk.Points, tombstone)
+		chunk.Count = len(chunk.Points)
+	}
+	ts.dropEmptyChunks()
+	if masked {
+		ts.Tombstones = append(ts.Tombstones, tombstone)
+	}
+
+	for i, r := range ts.Rollups {
+		r.delete(tombstone, covered[i], ts.rawPoints)
+	}
+
+	return false
+}
+
+// coveredBuckets returns, for each rollup, which of its buckets overlapping
+// t still have every point they summarise in the raw data, and so can be
+// rebuilt from it once t is applied. Retention may have dropped the raw
+// points of the others. Must be called with ts locked.
+func (ts *TimeSeries) coveredBuckets(t Tombstone) []map[int64]bool {
+	covered := make([]map[int64]bool, len(ts.Rollups))
+	for i, r := range ts.Rollups {
+		covered[i] = make(map[int64]bool)
+		for _, a := range append(r.Aggregates[:len(r.Aggregates):len(r.Aggregates)], r.pending) {
+			if a.Count > 0 && t.overlaps(a.Timestamp, a.Timestamp+r.Resolution-1) {
+				points, _ := ts.points(a.Timestamp, min(a.Timestamp+r.Resolution-1, r.rolledUp))
+				covered[i][a.Timestamp] = int64(len(points)) >= a.Count
+			}
+		}
+	}
+	return covered
+}
+
+// bounds returns the oldest and newest timestamps held by the series,
+// including its rollups. Must be called with ts locked.
+func (ts *TimeSeries) bounds() (int64, int64, bool) {
+	minT, maxT := int64(math.MaxInt64), int64(math.MinInt64)
+	include := func(start, end int64) {
+		minT = min(minT, start)
+		maxT = max(maxT, end)
+	}
+
+	for _, chunk := range ts.Chunks {
+		if len(chunk.Points) > 0 {
+			include(chunk.Points[0].Timestamp, chunk.Points[len(chunk.Points)-1].Timestamp)
+		}
+	}
+	if len(ts.OutOfOrder) > 0 {
+		include(ts.OutOfOrder[0].Timestamp, ts.OutOfOrder[len(ts.OutOfOrder)-1].Timestamp)
+	}
+	for _, r := range ts.Rollups {
+		for _, a := range append(r.Aggregates[:len(r.Aggregates):len(r.Aggregates)], r.pending) {
+			if a.Count > 0 {
+				include(a.Timestamp, a.Timestamp+r.Resolution-1)
+			}
+		}
+	}
+
+	return minT, maxT, minT <= maxT
+}
+
+// masked reports whether a point of a compacted chunk is covered by a
+// tombstone. Must be called with ts read-locked.
+func (ts *TimeSeries) masked(timestamp int64) bool {
+	for _, t := range ts.Tombstones {
+		if t.contains(timestamp) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyTombstones physically removes masked points from compacted chunks
+// and drops chunks left empty. Must be called with ts locked.
+func (ts *TimeSeries) applyTombstones() {
+	if len(ts.Tombstones) == 0 {
+		return
+	}
+
+	for _, chunk := range ts.Chunks {
+		if chunk.Compacted {
+			n := len(chunk.Points)
+			for _, t := range ts.Tombstones {
+				chunk.Points = removePoints(chunk.Points, t)
+			}
+			if len(chunk.Points) != n {
+				chunk.Count = len(chunk.Points)
+				chunk.changed()
+			}
+		}
+	}
+
+	ts.dropEmptyChunks()
+	ts.Tombstones = nil
+}
+
+// dropEmptyChunks removes chunks emptied by deletes, keeping the last one as
+// the head. Must be called with ts locked.
+func (ts *TimeSeries) dropEmptyChunks() {
+	chunks := ts.Chunks[:0]
+	for i, chunk := range ts.Chunks {
+		if len(chunk.Points) > 0 || i == len(ts.Chunks)-1 {
+			chunks = append(chunks, chunk)
+		}
+	}
+	ts.Chunks = chunks
+}
+
+// delete takes the points t covers out of the rollup. Buckets it overlaps
+// are rebuilt from the raw points left in them, up to the watermark, if
+// covered says raw data still holds all of theirs; the others are dropped,
+// as the deleted points cannot be taken back out of them.
+func (r *Rollup) delete(t Tombstone, covered map[int64]bool, points func(start, end int64) []Point) {
+	rebuild := func(a Aggregate) Aggregate {
+		if !t.overlaps(a.Timestamp, a.Timestamp+r.Resolution-1) {
+			return a
+		}
+		var rebuilt Aggregate
+		if covered[a.Timestamp] {
+			for _, p := range points(a.Timestamp, min(a.Timestamp+r.Resolution-1, r.rolledUp)) {
+				rebuilt.add(pointAggregate(p, a.Timestamp))
+			}
+		}
+		return rebuilt
+	}
+
+	aggregates := r.Aggregates[:0]
+	for _, a := range r.Aggregates {
+		if a = rebuild(a); a.Count > 0 {
+			aggregates = append(aggregates, a)
+		}
+	}
+	r.Aggregates = aggregates
+
+	if r.pending.Count > 0 {
+		r.pending = rebuild(r.pending)
+	}
+}
+
+func removePoints(points []Point, t Tombstone) []Point {
+	kept := points[:0]
+	for _, p := range points {
+		if !t.contains(p.Timestamp) {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
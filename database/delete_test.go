@@ -0,0 +1,213 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDelete_Range(t *testing.T) {
+	db := NewDatabase()
+	metric := "test_metric"
+	tags := map[string]string{"host": "a", "region": "eu"}
+	require.NoError(t, db.AddTimeSeries(metric, tags))
+
+	for i := range ChunkSize + 10 {
+		require.NoError(t, db.AddPoint(metric, tags, int64(i), float64(i)))
+	}
+
+	key := GenerateKey(metric, tags)
+	shard := db.GetShard(key)
+	shard.CompactChunks()
+
+	// The range spans the compacted chunk and the head
+	matched, err := db.Delete(metric, map[string]string{"host": "a"}, ChunkSize-5, ChunkSize+4)
+	require.NoError(t, err)
+	assert.Equal(t, 1, matched)
+
+	ts := shard.Series[key]
+	assert.Len(t, ts.Tombstones, 1)
+	assert.Equal(t, ChunkSize, ts.Chunks[0].Count)
+	assert.Equal(t, 5, ts.Chunks[1].Count)
+
+	points, err := db.GetRange(metric, tags, 0, math.MaxInt64)
+	require.NoError(t, err)
+	assert.Len(t, points, ChunkSize)
+	assert.Equal(t, int64(ChunkSize-6), points[ChunkSize-6].Timestamp)
+	assert.Equal(t, int64(ChunkSize+5), points[ChunkSize-5].Timestamp)
+
+	// Compaction physically removes the masked points
+	shard.CompactChunks()
+	assert.Empty(t, ts.Tombstones)
+	assert.Equal(t, ChunkSize-5, ts.Chunks[0].Count)
+
+	after, err := db.GetRange(metric, tags, 0, math.MaxInt64)
+	require.NoError(t, err)
+	assert.Equal(t, points, after)
+}
+
+func TestDelete_Series(t *testing.T) {
+	db := NewDatabase()
+	metric := "test_metric"
+	tagsA := map[string]string{"host": "a"}
+	tagsB := map[string]string{"host": "b"}
+	require.NoError(t, db.AddTimeSeries(metric, tagsA))
+	require.NoError(t, db.AddTimeSeries(metric, tagsB))
+	require.NoError(t, db.AddPoint(metric, tagsA, 100, 1.0))
+	require.NoError(t, db.AddPoint(metric, tagsB, 100, 1.0))
+
+	// A range covering all of a series' data removes it
+	matched, err := db.Delete(metric, tagsA, 0, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, 1, matched)
+
+	_, err = db.GetRange(metric, tagsA, 0, 1000)
+	assert.ErrorIs(t, err, ErrSeriesNotFound)
+	assert.ErrorIs(t, db.AddPoint(metric, tagsA, 200, 1.0), ErrSeriesNotFound)
+
+	// So does an unbounded one, even on a series without points
+	require.NoError(t, db.AddTimeSeries("empty_metric", nil))
+	matched, err = db.Delete("empty_metric", nil, math.MinInt64, math.MaxInt64)
+	require.NoError(t, err)
+	assert.Equal(t, 1, matched)
+	_, err = db.GetRange("empty_metric", nil, 0, 1000)
+	assert.ErrorIs(t, err, ErrSeriesNotFound)
+
+	points, err := db.GetRange(metric, tagsB, 0, 1000)
+	require.NoError(t, err)
+	assert.Len(t, points, 1)
+
+	// A tag with an empty value matches series without it
+	matched, err = db.Delete(metric, map[string]string{"region": ""}, 500, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, 1, matched)
+	matched, err = db.Delete(metric, map[string]string{"host": "c"}, 500, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, 0, matched)
+
+	_, err = db.Delete("", nil, 0, 1000)
+	assert.ErrorIs(t, err, ErrInvalidSelector)
+}
+
+func TestDelete_ConcurrentAdds(t *testing.T) {
+	opts := &Options{WALDir: t.TempDir()}
+	db, err := Open(opts)
+	require.NoError(t, err)
+
+	var series []map[string]string
+	for i := range 8 {
+		tags := map[string]string{"host": fmt.Sprint(i)}
+		require.NoError(t, db.AddTimeSeries("cpu", tags))
+		for j := range 100 {
+			require.NoError(t, db.AddPoint("cpu", tags, int64(j), 1.0))
+		}
+		require.NoError(t, db.AddPoint("cpu", tags, 500, 1.0))
+		series = append(series, tags)
+	}
+
+	// Writers keep advancing every series while the delete runs, which
+	// must not make it skip any of them
+	var wg sync.WaitGroup
+	for _, tags := range series {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 500 {
+				assert.NoError(t, db.AddPoint("cpu", tags, int64(1000+j), 2.0))
+			}
+		}()
+	}
+	matched, err := db.Delete("cpu", nil, 0, 99)
+	require.NoError(t, err)
+	assert.Equal(t, 8, matched)
+	wg.Wait()
+
+	live := make(map[string][]Point)
+	for _, tags := range series {
+		points, err := db.GetRange("cpu", tags, 0, 99)
+		require.NoError(t, err)
+		assert.Empty(t, points, "host %s", tags["host"])
+
+		live[tags["host"]], err = db.GetRange("cpu", tags, math.MinInt64, math.MaxInt64)
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.Close())
+
+	// Replaying the WAL ends up where the live database did
+	db, err = Open(opts)
+	require.NoError(t, err)
+	defer db.Close()
+	for _, tags := range series {
+		points, err := db.GetRange("cpu", tags, math.MinInt64, math.MaxInt64)
+		require.NoError(t, err)
+		assert.Equal(t, live[tags["host"]], points, "host %s", tags["host"])
+	}
+}
+
+func TestDelete_Replay(t *testing.T) {
+	dir := t.TempDir()
+	w, err := OpenWAL(dir, time.Millisecond, false)
+	require.NoError(t, err)
+
+	// Records of different series may be out of LSN order in the WAL, but
+	// those of each series never are
+	a := map[string]string{"host": "a"}
+	for _, rec := range []walRecord{
+		{LSN: 1, Type: walSeries, Metric: "cpu", Tags: a},
+		{LSN: 2, Type: walPoint, Metric: "cpu", Tags: a, Timestamp: 5, Value: 1},
+		{LSN: 3, Type: walPoint, Metric: "cpu", Tags: a, Timestamp: 20, Value: 1},
+		{LSN: 4, Type: walSeries, Metric: "mem", Tags: a},
+		{LSN: 7, Type: walPoint, Metric: "mem", Tags: a, Timestamp: 5, Value: 1},
+		{LSN: 5, Type: walDelete, Metric: "cpu", Start: 0, End: 10},
+		{LSN: 6, Type: walPoint, Metric: "cpu", Tags: a, Timestamp: 7, Value: 2},
+	} {
+		require.NoError(t, w.Log(rec))
+	}
+	require.NoError(t, w.Close())
+
+	db, err := Open(&Options{WALDir: dir})
+	require.NoError(t, err)
+	defer db.Close()
+
+	points, err := db.GetRange("cpu", a, 0, 100)
+	require.NoError(t, err)
+	assert.Equal(t, []Point{{7, 2}, {20, 1}}, points)
+	points, err = db.GetRange("mem", a, 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []Point{{5, 1}}, points)
+}
+
+func TestDelete_PartialRollupBucket(t *testing.T) {
+	db := NewDatabaseWithOptions(&Options{
+		Retention: 24 * time.Hour,
+		Rollups:   []RollupTier{{Resolution: time.Hour}},
+	})
+	metric := "test_metric"
+	tags := map[string]string{"tag1": "value1"}
+	require.NoError(t, db.AddTimeSeries(metric, tags))
+	for i := range ChunkSize + 10 {
+		require.NoError(t, db.AddPoint(metric, tags, int64(i*60), 1.0))
+	}
+	db.GetShard(GenerateKey(metric, tags)).CompactChunks()
+
+	// The points of the first hour that survive the delete still count
+	_, err := db.Delete(metric, tags, 0, 1799)
+	require.NoError(t, err)
+	points, err := db.GetRangeStep(metric, tags, 0, 7199, 3600, AggregateCount)
+	require.NoError(t, err)
+	assert.Equal(t, []Point{{0, 30}, {3600, 60}}, points)
+
+	// Once retention has dropped the raw points of a bucket, it can only
+	// be dropped as a whole
+	db.ExpireChunks(time.Unix(int64(ChunkSize*60)+24*3600, 0))
+	_, err = db.Delete(metric, tags, 3600, 3600)
+	require.NoError(t, err)
+	points, err = db.GetRangeStep(metric, tags, 0, 10799, 3600, AggregateCount)
+	require.NoError(t, err)
+	assert.Equal(t, []Point{{0, 30}, {7200, 60}}, points)
+}
@@ -0,0 +1,43 @@
+package database
+
+import "errors"
+
+var (
+	ErrSeriesExists      = errors.New("time series already exists")
+	ErrSeriesNotFound    = errors.New("time series not found")
+	ErrOutOfBounds       = errors.New("sample out of bounds")
+	ErrDuplicate         = errors.New("duplicate sample for timestamp")
+	ErrNotSorted         = errors.New("samples not in time order")
+	ErrLimitExceeded     = errors.New("limit exceeded")
+	ErrInvalidSelector   = errors.New("invalid series selector")
+	ErrInvalidShardCount = errors.New("invalid shard count")
+	ErrInvalidMetadata   = errors.New("invalid metadata")
+	ErrNotConfigured     = errors.New("not configured")
+	ErrClosed            = errors.New("database closed")
+	ErrUnknownTenant     = errors.New("unknown tenant")
+)
+
+// SeriesError wraps one of the sentinel errors above with the series that
+// caused it, so callers can match on the sentinel with errors.Is and still
+// report which series was affected.
+type SeriesError struct {
+	Err    error
+	Metric string
+	Tags   map[string]string
+}
+
+func (e *SeriesError) Error() string {
+	return e.Err.Error() + ": " + e.Key()
+}
+
+func (e *SeriesError) Unwrap() error {
+	return e.Err
+}
+
+func (e *SeriesError) Key() string {
+	return GenerateKey(e.Metric, e.Tags)
+}
+
+func seriesError(err error, metric string, tags map[string]string) error {
+	return &SeriesError{Err: err, Metric: metric, Tags: tags}
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"context"
+	"sort"
+
+	"github.com/sinnlos-ffff/tsdb-lite/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SeriesPoints is a series together with some of its points.
+type SeriesPoints struct {
+	Metric string
+	Tags   map[string]string
+	Points []Point
+}
+
+// Select returns every series of metric that carries all of the given tags,
+// or every series at all if metric is empty, sorted by key.
+func (db *Database) Select(metric string, tags map[string]string) []*TimeSeries {
+	var matchers []*Matcher
+	if metric != "" {
+		matchers = append(matchers, &Matcher{Type: MatchEqual, Name: MetricNameLabel, Value: metric})
+	}
+	for name, value := range tags {
+		matchers = append(matchers, &Matcher{Type: MatchEqual, Name: name, Value: value})
+	}
+
+	var series []*TimeSeries
+	for _, ts := range db.index.selectSeries(matchers) {
+		if ts.matches(metric, tags) {
+			series = append(series, ts)
+		}
+	}
+	sort.Slice(series, func(i, j int) bool {
+		return GenerateKey(series[i].Metric, series[i].Tags) < GenerateKey(series[j].Metric, series[j].Tags)
+	})
+	return series
+}
+
+// Export calls fn with the points in [start, end] of every series Select
+// returns for metric and tags, one series at a time. Series without points
+// in the range are skipped. Each series is only locked while its points are
+// copied, so fn may be slow.
+func (db *Database) Export(metric string, tags map[string]string, start, end int64, fn func(*SeriesPoints) error) error {
+	return db.ExportContext(context.Background(), metric, tags, start, end, fn)
+}
+
+// ExportContext is Export, traced as part of the span in ctx.
+func (db *Database) ExportContext(ctx context.Context, metric string, tags map[string]string, start, end int64, fn func(*SeriesPoints) error) error {
+	_, span := tracer.Start(ctx, "Database.Export", trace.WithAttributes(
+		attribute.String("metric", metric), attribute.Int64("start", start), attribute.Int64("end", end)))
+	defer span.End()
+
+	scanned, exported := 0, 0
+	defer func() {
+		metrics.QueryPointsScanned.WithLabelValues("export").Observe(float64(scanned))
+		span.SetAttributes(attribute.Int("points_scanned", scanned), attribute.Int("series_exported", exported))
+	}()
+
+	series := db.Select(metric, tags)
+	span.SetAttributes(attribute.Int("series", len(series)))
+	for _, ts := range series {
+		ts.RLock()
+		s := &SeriesPoints{Metric: ts.Metric, Tags: ts.Tags}
+		if !ts.removed {
+			var n int
+			s.Points, n = ts.points(start, end)
+			scanned += n
+		}
+		ts.RUnlock()
+
+		if len(s.Points) == 0 {
+			continue
+		}
+		if err := fn(s); err != nil {
+			return spanError(span, err)
+		}
+		exported++
+	}
+	return nil
+}
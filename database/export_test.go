@@ -0,0 +1,41 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExport(t *testing.T) {
+	db := NewDatabase()
+	for _, host := range []string{"b", "a", "c"} {
+		tags := map[string]string{"host": host}
+		require.NoError(t, db.AddTimeSeries("cpu_usage", tags))
+		require.NoError(t, db.AddPoint("cpu_usage", tags, 10, 1.0))
+		require.NoError(t, db.AddPoint("cpu_usage", tags, 20, 2.0))
+	}
+	require.NoError(t, db.AddTimeSeries("mem_usage", map[string]string{"host": "a"}))
+	require.NoError(t, db.AddPoint("mem_usage", map[string]string{"host": "a"}, 10, 3.0))
+	require.NoError(t, db.AddTimeSeries("empty", nil))
+
+	var got []SeriesPoints
+	collect := func(s *SeriesPoints) error {
+		got = append(got, *s)
+		return nil
+	}
+
+	require.NoError(t, db.Export("cpu_usage", nil, 15, 30, collect))
+	require.Len(t, got, 3)
+	for i, host := range []string{"a", "b", "c"} {
+		assert.Equal(t, map[string]string{"host": host}, got[i].Tags)
+		assert.Equal(t, []Point{{20, 2.0}}, got[i].Points)
+	}
+
+	// An empty metric selects every series; ones without points are skipped
+	got = nil
+	require.NoError(t, db.Export("", map[string]string{"host": "a"}, 0, 100, collect))
+	require.Len(t, got, 2)
+	assert.Equal(t, "cpu_usage", got[0].Metric)
+	assert.Equal(t, "mem_usage", got[1].Metric)
+}
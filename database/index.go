@@ -0,0 +1,405 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/sinnlos-ffff/tsdb-lite/metrics"
+)
+
+// MetricNameLabel is the label name matchers and LabelValues use to refer
+// to a series' metric name.
+const MetricNameLabel = "__name__"
+
+type MatchType int
+
+const (
+	MatchEqual MatchType = iota
+	MatchNotEqual
+	MatchRegexp
+	MatchNotRegexp
+)
+
+// Matcher selects series by one of their tags, or by metric name if Name is
+// MetricNameLabel. A missing tag matches like an empty value.
+type Matcher struct {
+	Type  MatchType
+	Name  string
+	Value string
+
+	re *regexp.Regexp
+}
+
+// NewMatcher returns a matcher, compiling Value if it is a regular
+// expression. Regular expressions must match the whole value.
+func NewMatcher(t MatchType, name, value string) (*Matcher, error) {
+	m := &Matcher{Type: t, Name: name, Value: value}
+	switch t {
+	case MatchEqual, MatchNotEqual:
+	case MatchRegexp, MatchNotRegexp:
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSelector, err)
+		}
+		m.re = re
+	default:
+		return nil, fmt.Errorf("%w: unknown match type %d", ErrInvalidSelector, t)
+	}
+	return m, nil
+}
+
+func (m *Matcher) matches(value string) bool {
+	switch m.Type {
+	case MatchEqual:
+		return value == m.Value
+	case MatchNotEqual:
+		return value != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(value)
+	default:
+		return !m.re.MatchString(value)
+	}
+}
+
+func (m *Matcher) matchesSeries(ts *TimeSeries) bool {
+	if m.Name == MetricNameLabel {
+		return m.matches(ts.Metric)
+	}
+	return m.matches(ts.Tags[m.Name])
+}
+
+type postings map[string]struct{}
+
+// index maps metric names and tags to the keys of the series carrying them,
+// so series can be found without walking every shard. It is independent of
+// the shard layout and so survives Reshard untouched.
+type index struct {
+	sync.RWMutex
+	series  map[string]*TimeSeries
+	metrics map[string]postings
+	// tags maps a tag name to its values and each value to its series.
+	tags map[string]map[string]postings
+	// defaultTenant holds the series without a TenantLabel tag, which an
+	// empty value would otherwise leave out of tags.
+	defaultTenant postings
+	// metricSeries counts the series of each tenant's metrics.
+	metricSeries map[tenantMetric]int
+	// selfSeries counts the series of the database's own metrics, which
+	// the limits leave out.
+	selfSeries int
+}
+
+type tenantMetric struct {
+	tenant string
+	metric string
+}
+
+func metricOf(ts *TimeSeries) tenantMetric {
+	return tenantMetric{tenant: TenantOf(ts.Tags), metric: ts.Metric}
+}
+
+// maxMetricSeries returns the series count of the metric name with the
+// most series in any one tenant, the count MaxSeriesPerMetric limits.
+func (idx *index) maxMetricSeries() int {
+	idx.RLock()
+	defer idx.RUnlock()
+
+	n := 0
+	for _, count := range idx.metricSeries {
+		n = max(n, count)
+	}
+	return n
+}
+
+func newIndex() *index {
+	return &index{
+		series:  make(map[string]*TimeSeries),
+		metrics: make(map[string]postings),
+		tags:    make(map[string]map[string]postings),
+
+		defaultTenant: make(postings),
+		metricSeries:  make(map[tenantMetric]int),
+	}
+}
+
+func (idx *index) add(key string, ts *TimeSeries) {
+	idx.Lock()
+	defer idx.Unlock()
+
+	idx.insert(key, ts)
+}
+
+// insert adds ts to the index. Must be called with idx locked.
+func (idx *index) insert(key string, ts *TimeSeries) {
+	if old, ok := idx.series[key]; ok {
+		idx.unlink(key, old)
+	}
+	idx.series[key] = ts
+	metrics.Series.Set(float64(len(idx.series)))
+	idx.metricSeries[metricOf(ts)]++
+	if isSelfMetric(ts.Metric) {
+		idx.selfSeries++
+	}
+
+	if idx.metrics[ts.Metric] == nil {
+		idx.metrics[ts.Metric] = make(postings)
+	}
+	idx.metrics[ts.Metric][key] = struct{}{}
+	if _, ok := ts.Tags[TenantLabel]; !ok {
+		idx.defaultTenant[key] = struct{}{}
+	}
+	for name, value := range ts.Tags {
+		values := idx.tags[name]
+		if values == nil {
+			values = make(map[string]postings)
+			idx.tags[name] = values
+		}
+		if values[value] == nil {
+			values[value] = make(postings)
+		}
+		values[value][key] = struct{}{}
+	}
+}
+
+// remove drops the series with the given key, unless the key has since been
+// taken by a different series.
+func (idx *index) remove(key string, ts *TimeSeries) {
+	idx.Lock()
+	defer idx.Unlock()
+
+	if idx.series[key] != ts {
+		return
+	}
+	delete(idx.series, key)
+	idx.unlink(key, ts)
+	metrics.Series.Set(float64(len(idx.series)))
+}
+
+func (idx *index) unlink(key string, ts *TimeSeries) {
+	if idx.metricSeries[metricOf(ts)]--; idx.metricSeries[metricOf(ts)] == 0 {
+		delete(idx.metricSeries, metricOf(ts))
+	}
+	if isSelfMetric(ts.Metric) {
+		idx.selfSeries--
+	}
+	if p := idx.metrics[ts.Metric]; p != nil {
+		delete(p, key)
+		if len(p) == 0 {
+			delete(idx.metrics, ts.Metric)
+		}
+	}
+	delete(idx.defaultTenant, key)
+	for name, value := range ts.Tags {
+		values := idx.tags[name]
+		if values == nil {
+			continue
+		}
+		if p := values[value]; p != nil {
+			delete(p, key)
+			if len(p) == 0 {
+				delete(values, value)
+			}
+		}
+		if len(values) == 0 {
+			delete(idx.tags, name)
+		}
+	}
+}
+
+// postings returns the keys equality matcher m selects, or nil if m cannot
+// be answered from the index. Must be called with idx read-locked.
+func (idx *index) postings(m *Matcher) (postings, bool) {
+	if m.Type == MatchEqual && m.Name == TenantLabel && m.Value == "" {
+		return idx.defaultTenant, true
+	}
+	if m.Type != MatchEqual || m.Value == "" {
+		return nil, false
+	}
+	if m.Name == MetricNameLabel {
+		return idx.metrics[m.Value], true
+	}
+	return idx.tags[m.Name][m.Value], true
+}
+
+// selectSeries returns the series every matcher selects. Equality matchers
+// narrow the candidates down through the index; the others are checked
+// against each candidate.
+func (idx *index) selectSeries(matchers []*Matcher) []*TimeSeries {
+	idx.RLock()
+	defer idx.RUnlock()
+
+	var candidates postings
+	for _, m := range matchers {
+		if p, ok := idx.postings(m); ok && (candidates == nil || len(p) < len(candidates)) {
+			candidates = p
+			if candidates == nil {
+				return nil
+			}
+		}
+	}
+
+	var result []*TimeSeries
+	check := func(key string) {
+		ts := idx.series[key]
+		for _, m := range matchers {
+			if !m.matchesSeries(ts) {
+				return
+			}
+		}
+		result = append(result, ts)
+	}
+	if candidates != nil {
+		for key := range candidates {
+			check(key)
+		}
+	} else {
+		for key := range idx.series {
+			check(key)
+		}
+	}
+	return result
+}
+
+// SeriesQuery selects series for LabelNames, LabelValues and Series. If
+// Start or End is set, only series holding data in [Start, End] are
+// selected. Limit caps the number of results if positive.
+type SeriesQuery struct {
+	Matchers []*Matcher
+	Start    *int64
+	End      *int64
+	Limit    int
+}
+
+func (q *SeriesQuery) hasRange() bool {
+	return q.Start != nil || q.End != nil
+}
+
+// selectSeries returns the series q selects, without applying Limit.
+func (db *Database) selectSeries(q *SeriesQuery) []*TimeSeries {
+	series := db.index.selectSeries(q.Matchers)
+	if !q.hasRange() {
+		return series
+	}
+
+	start, end := int64(math.MinInt64), int64(math.MaxInt64)
+	if q.Start != nil {
+		start = *q.Start
+	}
+	if q.End != nil {
+		end = *q.End
+	}
+	result := series[:0]
+	for _, ts := range series {
+		ts.RLock()
+		minT, maxT, ok := ts.bounds()
+		ts.RUnlock()
+		if ok && minT <= end && maxT >= start {
+			result = append(result, ts)
+		}
+	}
+	return result
+}
+
+// selectsAll reports whether matchers select every series, which they do
+// if there are none, or if they only restrict the query to the default
+// tenant and there are no others.
+func (idx *index) selectsAll(matchers []*Matcher) bool {
+	idx.RLock()
+	defer idx.RUnlock()
+
+	for _, m := range matchers {
+		if m.Type != MatchEqual || m.Name != TenantLabel || m.Value != "" || len(idx.tags[TenantLabel]) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// LabelNames returns the sorted tag names of the series q selects.
+func (db *Database) LabelNames(q *SeriesQuery) []string {
+	if !q.hasRange() && db.index.selectsAll(q.Matchers) {
+		db.index.RLock()
+		names := make([]string, 0, len(db.index.tags))
+		for name := range db.index.tags {
+			names = append(names, name)
+		}
+		db.index.RUnlock()
+		return sortLimit(names, q.Limit)
+	}
+
+	seen := make(map[string]struct{})
+	for _, ts := range db.selectSeries(q) {
+		for name := range ts.Tags {
+			seen[name] = struct{}{}
+		}
+	}
+	return sortLimit(mapKeys(seen), q.Limit)
+}
+
+// LabelValues returns the sorted values of tag name, or the metric names if
+// name is MetricNameLabel, among the series q selects.
+func (db *Database) LabelValues(name string, q *SeriesQuery) []string {
+	if !q.hasRange() && db.index.selectsAll(q.Matchers) {
+		db.index.RLock()
+		var values []string
+		if name == MetricNameLabel {
+			values = mapKeys(db.index.metrics)
+		} else {
+			values = mapKeys(db.index.tags[name])
+		}
+		db.index.RUnlock()
+		return sortLimit(values, q.Limit)
+	}
+
+	seen := make(map[string]struct{})
+	for _, ts := range db.selectSeries(q) {
+		if name == MetricNameLabel {
+			seen[ts.Metric] = struct{}{}
+		} else if value, ok := ts.Tags[name]; ok {
+			seen[value] = struct{}{}
+		}
+	}
+	return sortLimit(mapKeys(seen), q.Limit)
+}
+
+// SeriesLabels identifies a series.
+type SeriesLabels struct {
+	Metric string
+	Tags   map[string]string
+}
+
+// Series returns the series q selects, sorted by key.
+func (db *Database) Series(q *SeriesQuery) []SeriesLabels {
+	series := db.selectSeries(q)
+	sort.Slice(series, func(i, j int) bool {
+		return GenerateKey(series[i].Metric, series[i].Tags) < GenerateKey(series[j].Metric, series[j].Tags)
+	})
+	if q.Limit > 0 && len(series) > q.Limit {
+		series = series[:q.Limit]
+	}
+
+	result := make([]SeriesLabels, len(series))
+	for i, ts := range series {
+		result[i] = SeriesLabels{Metric: ts.Metric, Tags: ts.Tags}
+	}
+	return result
+}
+
+func mapKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func sortLimit(s []string, limit int) []string {
+	sort.Strings(s)
+	if limit > 0 && len(s) > limit {
+		s = s[:limit]
+	}
+	return s
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustMatcher(t *testing.T, mt MatchType, name, value string) *Matcher {
+	m, err := NewMatcher(mt, name, value)
+	require.NoError(t, err)
+	return m
+}
+
+func TestLabelQueries(t *testing.T) {
+	db := NewDatabase()
+	for i := range 4 {
+		tags := map[string]string{"host": fmt.Sprintf("host_%d", i), "region": []string{"eu", "us"}[i%2]}
+		require.NoError(t, db.AddTimeSeries("cpu_usage", tags))
+		require.NoError(t, db.AddPoint("cpu_usage", tags, int64(i*100), 1.0))
+	}
+	require.NoError(t, db.AddTimeSeries("mem_usage", map[string]string{"host": "host_0", "dc": "a"}))
+
+	assert.Equal(t, []string{"dc", "host", "region"}, db.LabelNames(&SeriesQuery{}))
+	assert.Equal(t, []string{"host", "region"}, db.LabelNames(&SeriesQuery{
+		Matchers: []*Matcher{mustMatcher(t, MatchEqual, MetricNameLabel, "cpu_usage")},
+	}))
+
+	assert.Equal(t, []string{"cpu_usage", "mem_usage"}, db.LabelValues(MetricNameLabel, &SeriesQuery{}))
+	assert.Equal(t, []string{"host_1", "host_3"}, db.LabelValues("host", &SeriesQuery{
+		Matchers: []*Matcher{
+			mustMatcher(t, MatchEqual, MetricNameLabel, "cpu_usage"),
+			mustMatcher(t, MatchNotEqual, "region", "eu"),
+		},
+	}))
+	assert.Equal(t, []string{"host_0"}, db.LabelValues("host", &SeriesQuery{Limit: 1}))
+
+	// Only series with data in the range
+	start, end := int64(150), int64(250)
+	assert.Equal(t, []string{"host_2"}, db.LabelValues("host", &SeriesQuery{Start: &start, End: &end}))
+
+	series := db.Series(&SeriesQuery{
+		Matchers: []*Matcher{mustMatcher(t, MatchRegexp, "host", "host_[01]")},
+	})
+	assert.Equal(t, []SeriesLabels{
+		{Metric: "cpu_usage", Tags: map[string]string{"host": "host_0", "region": "eu"}},
+		{Metric: "cpu_usage", Tags: map[string]string{"host": "host_1", "region": "us"}},
+		{Metric: "mem_usage", Tags: map[string]string{"host": "host_0", "dc": "a"}},
+	}, series)
+
+	// A missing tag matches the empty value
+	series = db.Series(&SeriesQuery{Matchers: []*Matcher{mustMatcher(t, MatchEqual, "dc", "")}})
+	assert.Len(t, series, 4)
+
+	_, err := NewMatcher(MatchRegexp, "host", "(")
+	assert.ErrorIs(t, err, ErrInvalidSelector)
+}
+
+func TestIndex_Removal(t *testing.T) {
+	db := NewDatabase()
+	tags := map[string]string{"host": "a"}
+	require.NoError(t, db.AddTimeSeries("cpu_usage", tags))
+	require.NoError(t, db.Reshard(4))
+	assert.Equal(t, []string{"a"}, db.LabelValues("host", &SeriesQuery{}))
+
+	_, err := db.Delete("cpu_usage", tags, math.MinInt64, math.MaxInt64)
+	require.NoError(t, err)
+	assert.Empty(t, db.LabelNames(&SeriesQuery{}))
+	assert.Empty(t, db.LabelValues(MetricNameLabel, &SeriesQuery{}))
+	assert.Empty(t, db.Series(&SeriesQuery{}))
+}
+
+func TestIndex_DefaultTenant(t *testing.T) {
+	db := NewDatabase()
+	require.NoError(t, db.AddTimeSeries("cpu_usage", map[string]string{"host": "a"}))
+	require.NoError(t, db.AddTimeSeries("cpu_usage", map[string]string{"host": "b"}))
+	defaultTenant := mustMatcher(t, MatchEqual, TenantLabel, "")
+
+	// Without other tenants, the default tenant's queries take the same
+	// path as unrestricted ones
+	assert.True(t, db.index.selectsAll([]*Matcher{defaultTenant}))
+	assert.Equal(t, []string{"a", "b"}, db.LabelValues("host", &SeriesQuery{Matchers: []*Matcher{defaultTenant}}))
+
+	require.NoError(t, db.AddTimeSeries("cpu_usage", map[string]string{TenantLabel: "team_c", "host": "c"}))
+	assert.False(t, db.index.selectsAll([]*Matcher{defaultTenant}))
+
+	// The default tenant's series have postings of their own
+	db.index.RLock()
+	p, ok := db.index.postings(defaultTenant)
+	db.index.RUnlock()
+	assert.True(t, ok)
+	assert.Len(t, p, 2)
+	assert.Equal(t, []string{"a", "b"}, db.LabelValues("host", &SeriesQuery{Matchers: []*Matcher{defaultTenant}}))
+
+	_, err := db.Delete("cpu_usage", map[string]string{"host": "a"}, math.MinInt64, math.MaxInt64)
+	require.NoError(t, err)
+	assert.Equal(t, 1, db.index.tenantSeries(""))
+}
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/sinnlos-ffff/tsdb-lite/metrics"
+)
+
+// Limits caps series cardinality. AddTimeSeries fails with ErrLimitExceeded
+// rather than create a series past any of them. Zero means no limit.
+type Limits struct {
+	MaxSeries          int
+	MaxSeriesPerMetric int
+	MaxTagsPerSeries   int
+}
+
+func (l *Limits) export() {
+	metrics.SeriesLimit.WithLabelValues("total").Set(float64(l.MaxSeries))
+	metrics.SeriesLimit.WithLabelValues("per_metric").Set(float64(l.MaxSeriesPerMetric))
+	metrics.SeriesLimit.WithLabelValues("tags_per_series").Set(float64(l.MaxTagsPerSeries))
+}
+
+func limitError(limit, format string, args ...any) error {
+	metrics.SeriesLimitRejectionsTotal.WithLabelValues(limit).Inc()
+	return fmt.Errorf("%w: "+format, append([]any{ErrLimitExceeded}, args...)...)
+}
+
+// checkTags checks the number of tags a series has, not counting the
+// TenantLabel the server adds.
+func (l *Limits) checkTags(tags map[string]string) error {
+	n := len(tags)
+	if _, ok := tags[TenantLabel]; ok {
+		n--
+	}
+	if l.MaxTagsPerSeries > 0 && n > l.MaxTagsPerSeries {
+		return limitError("tags_per_series", "%d tags, at most %d allowed per series", n, l.MaxTagsPerSeries)
+	}
+	return nil
+}
+
+// addLimited adds a series to the index unless that would exceed l. The
+// check and the insert happen under one lock, so concurrent creations in
+// different shards cannot overshoot a limit together. MaxSeriesPerMetric
+// applies to each tenant separately.
+func (idx *index) addLimited(key string, ts *TimeSeries, l *Limits, tenant TenantLimits) error {
+	idx.Lock()
+	defer idx.Unlock()
+
+	if l.MaxSeries > 0 && len(idx.series)-idx.selfSeries >= l.MaxSeries {
+		return limitError("total", "series limit of %d reached", l.MaxSeries)
+	}
+	if n := idx.metricSeries[metricOf(ts)]; l.MaxSeriesPerMetric > 0 && n >= l.MaxSeriesPerMetric {
+		return limitError("per_metric", "limit of %d series for metric %q reached", l.MaxSeriesPerMetric, ts.Metric)
+	}
+
+	if name := TenantOf(ts.Tags); tenant.MaxSeries > 0 && idx.tenantSeries(name) >= tenant.MaxSeries {
+		return limitError("tenant", "limit of %d series for tenant %q reached", tenant.MaxSeries, tenantName(name))
+	}
+
+	idx.insert(key, ts)
+	return nil
+}
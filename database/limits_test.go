@@ -0,0 +1,77 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sinnlos-ffff/tsdb-lite/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimits(t *testing.T) {
+	db := NewDatabaseWithOptions(&Options{Limits: Limits{
+		MaxSeries:          5,
+		MaxSeriesPerMetric: 3,
+		MaxTagsPerSeries:   2,
+	}})
+
+	for i := range 3 {
+		require.NoError(t, db.AddTimeSeries("cpu_usage", map[string]string{"host": fmt.Sprint(i)}))
+	}
+	rejected := testutil.ToFloat64(metrics.SeriesLimitRejectionsTotal.WithLabelValues("per_metric"))
+	err := db.AddTimeSeries("cpu_usage", map[string]string{"host": "3"})
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+	assert.ErrorContains(t, err, `limit of 3 series for metric "cpu_usage" reached`)
+	assert.Equal(t, rejected+1, testutil.ToFloat64(metrics.SeriesLimitRejectionsTotal.WithLabelValues("per_metric")))
+
+	err = db.AddTimeSeries("mem_usage", map[string]string{"a": "1", "b": "2", "c": "3"})
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+
+	require.NoError(t, db.AddTimeSeries("mem_usage", nil))
+	require.NoError(t, db.AddTimeSeries("disk_usage", nil))
+	err = db.AddTimeSeries("net_usage", nil)
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+
+	// Deleting a series frees up its slot
+	_, err = db.Delete("disk_usage", nil, math.MinInt64, math.MaxInt64)
+	require.NoError(t, err)
+	assert.NoError(t, db.AddTimeSeries("net_usage", nil))
+}
+
+func TestLimits_Concurrent(t *testing.T) {
+	db := NewDatabaseWithOptions(&Options{Limits: Limits{MaxSeries: 50}})
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := 0
+	for i := range 200 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if db.AddTimeSeries("cpu_usage", map[string]string{"host": fmt.Sprint(i)}) == nil {
+				mu.Lock()
+				created++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 50, created)
+}
+
+func TestReload(t *testing.T) {
+	db := NewDatabaseWithOptions(&Options{Limits: Limits{MaxSeries: 1}})
+	require.NoError(t, db.AddTimeSeries("cpu_usage", map[string]string{"host": "a"}))
+	assert.ErrorIs(t, db.AddTimeSeries("cpu_usage", map[string]string{"host": "b"}), ErrLimitExceeded)
+	assert.False(t, db.hasRetention())
+
+	db.Reload(ReloadableOptions{Limits: Limits{MaxSeries: 2}, Retention: time.Hour})
+	assert.NoError(t, db.AddTimeSeries("cpu_usage", map[string]string{"host": "b"}))
+	assert.True(t, db.hasRetention())
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.SeriesLimit.WithLabelValues("total")))
+}
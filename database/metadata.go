@@ -0,0 +1,167 @@
+package database
+
+import "fmt"
+
+type MetricType int
+
+const (
+	MetricTypeUnknown MetricType = iota
+	MetricTypeGauge
+	MetricTypeCounter
+	MetricTypeHistogram
+	MetricTypeSummary
+)
+
+func (t MetricType) String() string {
+	switch t {
+	case MetricTypeGauge:
+		return "gauge"
+	case MetricTypeCounter:
+		return "counter"
+	case MetricTypeHistogram:
+		return "histogram"
+	case MetricTypeSummary:
+		return "summary"
+	default:
+		return "unknown"
+	}
+}
+
+// Metadata describes every series of a metric name.
+type Metadata struct {
+	Type MetricType `json:"type"`
+	Unit string     `json:"unit,omitempty"`
+	Help string     `json:"help,omitempty"`
+}
+
+// metricMetadata is the stored form of a metric's Metadata, with the LSN
+// that set it so WAL replay can skip older records.
+type metricMetadata struct {
+	Metric   string   `json:"metric"`
+	Tenant   string   `json:"tenant,omitempty"`
+	Metadata Metadata `json:"metadata"`
+	LSN      uint64   `json:"lsn"`
+}
+
+// TenantTags returns the tags that place a series in tenant, which are none
+// for the default tenant.
+func TenantTags(tenant string) map[string]string {
+	if tenant == "" {
+		return nil
+	}
+	return map[string]string{TenantLabel: tenant}
+}
+
+// metadataKey keys the metadata of a tenant's metric.
+func metadataKey(tenant, metric string) string {
+	return GenerateKey(metric, TenantTags(tenant))
+}
+
+// SetMetadata sets the metadata of a tenant's metric name, replacing what
+// was there. The metric does not need to have any series yet.
+func (db *Database) SetMetadata(tenant, metric string, md Metadata) error {
+	if metric == "" {
+		return ErrInvalidSelector
+	}
+	if md.Type < MetricTypeUnknown || md.Type > MetricTypeSummary {
+		return fmt.Errorf("%w: unknown metric type %d", ErrInvalidMetadata, md.Type)
+	}
+	if _, err := db.tenantLimits(tenant); err != nil {
+		return err
+	}
+
+	db.metadataMu.Lock()
+	rec := walRecord{Type: walMetadata, Metric: metric, Tags: TenantTags(tenant), Metadata: md}
+	commit, err := db.log(&rec)
+	if err == nil {
+		db.setMetadata(metricMetadata{Metric: metric, Tenant: tenant, Metadata: md, LSN: rec.LSN})
+	}
+	db.metadataMu.Unlock()
+	if err != nil {
+		return err
+	}
+	return commit()
+}
+
+// setMetadata stores m unless the metric's metadata was already set at or
+// after m.LSN. Must be called with db.metadataMu locked.
+func (db *Database) setMetadata(m metricMetadata) {
+	if db.metadata == nil {
+		db.metadata = make(map[string]metricMetadata)
+	}
+	key := metadataKey(m.Tenant, m.Metric)
+	if cur, ok := db.metadata[key]; ok && cur.LSN >= m.LSN {
+		return
+	}
+	db.metadata[key] = m
+}
+
+// GetMetadata returns the metadata of a tenant's metric name, if it has
+// been set.
+func (db *Database) GetMetadata(tenant, metric string) (Metadata, bool) {
+	db.metadataMu.RLock()
+	defer db.metadataMu.RUnlock()
+
+	m, ok := db.metadata[metadataKey(tenant, metric)]
+	return m.Metadata, ok
+}
+
+// ListMetadata returns the metadata of every metric name of the tenant that
+// has some, keyed by metric name, or only that of metric if it is not
+// empty.
+func (db *Database) ListMetadata(tenant, metric string) map[string]Metadata {
+	db.metadataMu.RLock()
+	defer db.metadataMu.RUnlock()
+
+	result := make(map[string]Metadata)
+	for _, m := range db.metadata {
+		if m.Tenant == tenant && (metric == "" || m.Metric == metric) {
+			result[m.Metric] = m.Metadata
+		}
+	}
+	return result
+}
+
+// copyMetadata returns a copy of the stored metadata for a snapshot or
+// backup.
+func (db *Database) copyMetadata() map[string]metricMetadata {
+	db.metadataMu.RLock()
+	defer db.metadataMu.RUnlock()
+
+	if len(db.metadata) == 0 {
+		return nil
+	}
+	result := make(map[string]metricMetadata, len(db.metadata))
+	for name, m := range db.metadata {
+		result[name] = m
+	}
+	return result
+}
+
+func (db *Database) restoreMetadata(metadata map[string]metricMetadata) {
+	db.metadataMu.Lock()
+	defer db.metadataMu.Unlock()
+
+	for _, m := range metadata {
+		db.setMetadata(m)
+	}
+}
+
+// CheckAggregation returns warnings about applying fn to a tenant's metric,
+// based on its metadata, such as taking the rate of a gauge. A metric
+// without metadata gets no warnings.
+func (db *Database) CheckAggregation(tenant, metric string, fn Aggregation) []string {
+	md, ok := db.GetMetadata(tenant, metric)
+	if !ok {
+		return nil
+	}
+
+	var warnings []string
+	if fn == AggregateRate && md.Type != MetricTypeCounter && md.Type != MetricTypeUnknown {
+		warnings = append(warnings, fmt.Sprintf("rate applied to %s metric %q; rate is only meaningful for counters", md.Type, metric))
+	}
+	if fn == AggregateSum && md.Type == MetricTypeCounter {
+		warnings = append(warnings, fmt.Sprintf("sum applied to counter metric %q; use rate to get its increase", metric))
+	}
+	return warnings
+}
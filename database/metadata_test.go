@@ -0,0 +1,77 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadata(t *testing.T) {
+	opts := &Options{WALDir: t.TempDir(), SnapshotDir: t.TempDir()}
+	db, err := Open(opts)
+	require.NoError(t, err)
+
+	cpu := Metadata{Type: MetricTypeGauge, Unit: "percent", Help: "CPU usage"}
+	require.NoError(t, db.SetMetadata("", "cpu_usage", cpu))
+	_, _, err = db.Snapshot()
+	require.NoError(t, err)
+
+	// Set after the snapshot, so only in the WAL
+	requests := Metadata{Type: MetricTypeCounter, Help: "Requests served"}
+	require.NoError(t, db.SetMetadata("", "requests_total", requests))
+	require.NoError(t, db.Close())
+
+	db, err = Open(opts)
+	require.NoError(t, err)
+	defer db.Close()
+
+	md, ok := db.GetMetadata("", "cpu_usage")
+	assert.True(t, ok)
+	assert.Equal(t, cpu, md)
+	assert.Equal(t, map[string]Metadata{"cpu_usage": cpu, "requests_total": requests}, db.ListMetadata("", ""))
+	assert.Equal(t, map[string]Metadata{"requests_total": requests}, db.ListMetadata("", "requests_total"))
+
+	_, ok = db.GetMetadata("", "missing")
+	assert.False(t, ok)
+	assert.ErrorIs(t, db.SetMetadata("", "", cpu), ErrInvalidSelector)
+	assert.ErrorIs(t, db.SetMetadata("", "cpu_usage", Metadata{Type: 42}), ErrInvalidMetadata)
+
+	// Tenants each have their own
+	require.NoError(t, db.SetMetadata("team_a", "cpu_usage", Metadata{Type: MetricTypeCounter}))
+	md, _ = db.GetMetadata("team_a", "cpu_usage")
+	assert.Equal(t, MetricTypeCounter, md.Type)
+	md, _ = db.GetMetadata("", "cpu_usage")
+	assert.Equal(t, cpu, md)
+	assert.Len(t, db.ListMetadata("team_a", ""), 1)
+}
+
+func TestCheckAggregation(t *testing.T) {
+	db := NewDatabase()
+	require.NoError(t, db.SetMetadata("", "cpu_usage", Metadata{Type: MetricTypeGauge}))
+	require.NoError(t, db.SetMetadata("", "requests_total", Metadata{Type: MetricTypeCounter}))
+
+	assert.Len(t, db.CheckAggregation("", "cpu_usage", AggregateRate), 1)
+	assert.Empty(t, db.CheckAggregation("", "cpu_usage", AggregateAvg))
+	assert.Empty(t, db.CheckAggregation("", "requests_total", AggregateRate))
+	assert.Len(t, db.CheckAggregation("", "requests_total", AggregateSum), 1)
+	assert.Empty(t, db.CheckAggregation("", "unknown", AggregateRate))
+}
+
+func TestGetRangeStep_Rate(t *testing.T) {
+	db := NewDatabase()
+	metric := "requests_total"
+	require.NoError(t, db.AddTimeSeries(metric, nil))
+	// The counter resets between 30 and 40
+	for i, v := range []float64{0, 10, 20, 30, 5, 15} {
+		require.NoError(t, db.AddPoint(metric, nil, int64(i*10), v))
+	}
+
+	points, err := db.GetRangeStep(metric, nil, 0, 100, 0, AggregateRate)
+	require.NoError(t, err)
+	assert.Equal(t, []Point{{10, 1}, {20, 1}, {30, 1}, {40, 0.5}, {50, 1}}, points)
+
+	points, err = db.GetRangeStep(metric, nil, 0, 100, 30, AggregateRate)
+	require.NoError(t, err)
+	assert.Equal(t, []Point{{0, 20.0 / 30}, {30, 25.0 / 30}}, points)
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"math"
+	"sort"
+)
+
+// maxTimestamp returns the newest timestamp stored in the series' chunks.
+// Chunks are kept in time order, so this is the last point of the last
+// non-empty chunk. Must be called with ts locked.
+func (ts *TimeSeries) maxTimestamp() (int64, bool) {
+	for i := len(ts.Chunks) - 1; i >= 0; i-- {
+		if points := ts.Chunks[i].Points; len(points) > 0 {
+			return points[len(points)-1].Timestamp, true
+		}
+	}
+	return 0, false
+}
+
+// minTimestamp returns the oldest timestamp window behind maxT, saturating
+// at math.MinInt64. A window of zero or less has no bound.
+func minTimestamp(maxT, window int64) int64 {
+	if window <= 0 || maxT < math.MinInt64+window {
+		return math.MinInt64
+	}
+	return maxT - window
+}
+
+// insertOutOfOrder adds p to the out-of-order head, keeping it sorted.
+// Must be called with ts locked.
+func (ts *TimeSeries) insertOutOfOrder(p Point) {
+	i := sort.Search(len(ts.OutOfOrder), func(i int) bool {
+		return ts.OutOfOrder[i].Timestamp > p.Timestamp
+	})
+	ts.OutOfOrder = append(ts.OutOfOrder, Point{})
+	copy(ts.OutOfOrder[i+1:], ts.OutOfOrder[i:])
+	ts.OutOfOrder[i] = p
+}
+
+// mergeOutOfOrder merges each point of the out-of-order head into the
+// chunk whose time range it falls in, or the last chunk if it falls in
+// none, splitting chunks that outgrow the series' chunk size. Chunks that
+// take no points are kept as they are. Points landing at or before a
+// rollup's watermark are folded into it, and the buckets whose points they
+// overwrite are rebuilt. Must be called with ts locked.
+func (ts *TimeSeries) mergeOutOfOrder() {
+	if len(ts.OutOfOrder) == 0 {
+		return
+	}
+
+	size := ts.maxChunkSize()
+	outOfOrder := ts.OutOfOrder
+	chunks := make([]*Chunk, 0, len(ts.Chunks))
+	var added []Point
+	var replaced []replacement
+	for i, chunk := range ts.Chunks {
+		n := len(outOfOrder)
+		if i < len(ts.Chunks)-1 && len(chunk.Points) > 0 {
+			maxT := chunk.Points[len(chunk.Points)-1].Timestamp
+			n = sort.Search(len(outOfOrder), func(j int) bool {
+				return outOfOrder[j].Timestamp > maxT
+			})
+		}
+		if n == 0 {
+			chunks = append(chunks, chunk)
+			continue
+		}
+
+		merged := dedupe(mergePoints(chunk.Points, outOfOrder[:n]), ts.duplicatePolicy)
+		outOfOrder = outOfOrder[n:]
+		a, r := diffPoints(chunk.Points, merged)
+		added = append(added, a...)
+		replaced = append(replaced, r...)
+		for len(merged) > 0 {
+			n := min(len(merged), size)
+			points := make([]Point, n, size)
+			copy(points, merged[:n])
+			chunks = append(chunks, &Chunk{Points: points, Count: n, Compacted: chunk.Compacted})
+			merged = merged[n:]
+		}
+	}
+
+	stale := ts.staleBuckets(replaced)
+	ts.Chunks = chunks
+	ts.OutOfOrder = nil
+	for i, r := range ts.Rollups {
+		r.backfill(r.rolledUpPoints(added))
+		r.replace(replaced, stale[i], ts.rawPoints)
+	}
+}
+
+// mergePoints merges two time-ordered slices into a new time-ordered slice.
+// On equal timestamps, points from a come first.
+func mergePoints(a, b []Point) []Point {
+	result := make([]Point, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if b[j].Timestamp < a[i].Timestamp {
+			result = append(result, b[j])
+			j++
+		} else {
+			result = append(result, a[i])
+			i++
+		}
+	}
+	result = append(result, a[i:]...)
+	return append(result, b[j:]...)
+}
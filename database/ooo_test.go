@@ -0,0 +1,110 @@
+package database
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddPoint_OutOfOrder(t *testing.T) {
+	db := NewDatabaseWithOptions(&Options{OutOfOrderWindow: 100})
+	metric := "test_metric"
+	tags := map[string]string{"tag1": "value1"}
+	require.NoError(t, db.AddTimeSeries(metric, tags))
+
+	require.NoError(t, db.AddPoint(metric, tags, 1000, 1.0))
+	require.NoError(t, db.AddPoint(metric, tags, 1100, 3.0))
+
+	// Within the window: goes to the out-of-order head
+	require.NoError(t, db.AddPoint(metric, tags, 1050, 2.0))
+
+	// Older than the window: rejected
+	err := db.AddPoint(metric, tags, 999, 0.0)
+	assert.ErrorIs(t, err, ErrOutOfBounds)
+
+	key := GenerateKey(metric, tags)
+	ts := db.GetShard(key).Series[key]
+	assert.Len(t, ts.Chunks[0].Points, 2)
+	assert.Len(t, ts.OutOfOrder, 1)
+
+	points, err := db.GetRange(metric, tags, 0, 2000)
+	require.NoError(t, err)
+	assert.Equal(t, []Point{{1000, 1.0}, {1050, 2.0}, {1100, 3.0}}, points)
+}
+
+func TestAddPoint_UnboundedByDefault(t *testing.T) {
+	db := NewDatabase()
+	metric := "test_metric"
+	tags := map[string]string{"tag1": "value1"}
+	require.NoError(t, db.AddTimeSeries(metric, tags))
+
+	require.NoError(t, db.AddPoint(metric, tags, 1000, 1.0))
+	require.NoError(t, db.AddPoint(metric, tags, 1000, 1.0))
+	require.NoError(t, db.AddPoint(metric, tags, math.MinInt64, 2.0))
+
+	points, err := db.GetRange(metric, tags, math.MinInt64, 2000)
+	require.NoError(t, err)
+	assert.Equal(t, []Point{{math.MinInt64, 2.0}, {1000, 1.0}}, points)
+}
+
+func TestCompactChunks_MergesOutOfOrder(t *testing.T) {
+	db := NewDatabaseWithOptions(&Options{OutOfOrderWindow: ChunkSize * 2})
+	metric := "test_metric"
+	tags := map[string]string{"tag1": "value1"}
+	require.NoError(t, db.AddTimeSeries(metric, tags))
+
+	// Fill one chunk and a half with even timestamps, then backfill odd ones
+	for i := 0; i < ChunkSize*3/2; i++ {
+		require.NoError(t, db.AddPoint(metric, tags, int64(i*2), float64(i*2)))
+	}
+	for i := ChunkSize / 2; i < ChunkSize*3/2; i++ {
+		require.NoError(t, db.AddPoint(metric, tags, int64(i*2-1), float64(i*2-1)))
+	}
+
+	key := GenerateKey(metric, tags)
+	shard := db.GetShard(key)
+	shard.CompactChunks()
+
+	// The first chunk took half a chunk of late points and was split, the
+	// head took the other half and filled up
+	ts := shard.Series[key]
+	assert.Empty(t, ts.OutOfOrder)
+	require.Len(t, ts.Chunks, 3)
+	for i, count := range []int{ChunkSize, ChunkSize / 2, ChunkSize} {
+		assert.True(t, ts.Chunks[i].Compacted)
+		assert.Equal(t, count, ts.Chunks[i].Count)
+	}
+
+	var prev int64 = -1
+	for _, chunk := range ts.Chunks {
+		assert.Equal(t, len(chunk.Points), chunk.Count)
+		for _, p := range chunk.Points {
+			assert.Greater(t, p.Timestamp, prev)
+			prev = p.Timestamp
+		}
+	}
+
+	// In-order points go to a new head after the merge
+	require.NoError(t, db.AddPoint(metric, tags, prev+1, 0))
+	require.Len(t, ts.Chunks, 4)
+	assert.Equal(t, 1, ts.Chunks[3].Count)
+
+	// Chunks that take no late points are kept as they are
+	first := ts.Chunks[0]
+	first.persisted.Store(true)
+	require.NoError(t, db.AddPoint(metric, tags, prev+3, 0))
+	require.NoError(t, db.AddPoint(metric, tags, prev+2, 0))
+	require.Len(t, ts.OutOfOrder, 1)
+	shard.CompactChunks()
+	assert.Same(t, first, ts.Chunks[0])
+	assert.True(t, first.persisted.Load())
+}
+
+func TestAddPoint_WindowOverflow(t *testing.T) {
+	db := NewDatabaseWithOptions(&Options{OutOfOrderWindow: 100})
+	require.NoError(t, db.AddTimeSeries("test_metric", nil))
+	require.NoError(t, db.AddPoint("test_metric", nil, math.MaxInt64, 1.0))
+	assert.ErrorIs(t, db.AddPoint("test_metric", nil, math.MinInt64, 1.0), ErrOutOfBounds)
+}
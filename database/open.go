@@ -0,0 +1,211 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+// Open creates a database from opts, restoring state from disk: first the
+// snapshot given by opts.RestoreSnapshot, or else the newest one in
+// opts.SnapshotDir, then every write in opts.WALDir the snapshot does not
+// already contain. Writes are logged to opts.WALDir from then on.
+func Open(opts *Options) (*Database, error) {
+	return OpenContext(context.Background(), opts)
+}
+
+// OpenContext is Open, giving up on replaying the WAL with ctx.Err() once
+// ctx is done.
+func OpenContext(ctx context.Context, opts *Options) (*Database, error) {
+	db := NewDatabaseWithOptions(opts)
+
+	path := opts.RestoreSnapshot
+	if path == "" && opts.SnapshotDir != "" {
+		latest, err := LatestSnapshot(opts.SnapshotDir)
+		if err != nil {
+			return nil, err
+		}
+		path = latest
+	}
+	if path != "" {
+		if err := db.LoadSnapshot(path); err != nil {
+			return nil, err
+		}
+		log.Printf("Restored snapshot %s at LSN %d\n", path, db.lsn.Load())
+	}
+
+	if opts.WALDir == "" {
+		return db, nil
+	}
+
+	replayed := 0
+	if err := replayWAL(ctx, opts.WALDir, func(rec walRecord) {
+		db.replay(rec)
+		replayed++
+	}); err != nil {
+		return nil, err
+	}
+	if replayed > 0 {
+		log.Printf("Replayed %d WAL records up to LSN %d\n", replayed, db.lsn.Load())
+	}
+	// Expiry is not logged, so the WAL brings back points retention had
+	// already dropped.
+	if db.hasRetention() {
+		db.ExpireChunks(time.Now())
+	}
+
+	flushInterval := opts.WALFlushInterval
+	if flushInterval <= 0 {
+		flushInterval = DefaultOptions().WALFlushInterval
+	}
+	wal, err := OpenWAL(opts.WALDir, flushInterval, opts.WALSync)
+	if err != nil {
+		return nil, err
+	}
+	db.wal = wal
+
+	return db, nil
+}
+
+// Close waits for the compactors and retention to stop, which the contexts
+// they were started with must have asked them to, then flushes and syncs
+// the WAL.
+func (db *Database) Close() error {
+	return db.CloseContext(context.Background())
+}
+
+// CloseContext is Close, but stops waiting once ctx is done. The WAL is
+// closed even if the compactors and retention have not stopped by then,
+// as they do not log anything it must hold.
+func (db *Database) CloseContext(ctx context.Context) error {
+	var err error
+	stopped := make(chan struct{})
+	go func() {
+		db.background.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		err = fmt.Errorf("waiting for background work: %w", ctx.Err())
+	}
+	if db.wal == nil {
+		return err
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- db.wal.Close() }()
+	select {
+	case cerr := <-closed:
+		if err == nil {
+			err = cerr
+		}
+	case <-ctx.Done():
+		if err == nil {
+			err = fmt.Errorf("closing WAL: %w", ctx.Err())
+		}
+	}
+	return err
+}
+
+// log assigns rec the next LSN and appends it to the WAL, if there is one.
+// Callers must hold the locks of everything rec changes from before log
+// until they have applied it, so every series sees its records in LSN
+// order, live and on replay. They call the returned commit, which waits
+// for the record like WAL.Log, only once they have unlocked, so a WAL that
+// syncs does not hold up other readers and writers.
+func (db *Database) log(rec *walRecord) (commit func() error, err error) {
+	rec.LSN = db.lsn.Add(1)
+	if db.wal == nil {
+		return noCommit, nil
+	}
+	return db.wal.Append(*rec)
+}
+
+func noCommit() error { return nil }
+
+// reserveLog reserves a slot in the WAL queue for a later logReserved, so
+// that it does not block on a full queue under locks.
+func (db *Database) reserveLog() error {
+	if db.wal == nil {
+		return nil
+	}
+	return db.wal.Reserve()
+}
+
+// logReserved is log for a caller that has called reserveLog.
+func (db *Database) logReserved(rec *walRecord) (commit func() error, err error) {
+	rec.LSN = db.lsn.Add(1)
+	if db.wal == nil {
+		return noCommit, nil
+	}
+	return db.wal.AppendReserved(*rec)
+}
+
+// WALQueueDepth returns how many writes are waiting for the WAL, or 0 if it
+// is disabled.
+func (db *Database) WALQueueDepth() int {
+	if db.wal == nil {
+		return 0
+	}
+	return db.wal.QueueDepth()
+}
+
+// replay applies a WAL record to series that have not seen it yet. Records
+// were checked when they were first written, so points skip the
+// out-of-order window.
+func (db *Database) replay(rec walRecord) {
+	if rec.LSN > db.lsn.Load() {
+		db.lsn.Store(rec.LSN)
+	}
+
+	switch rec.Type {
+	case walSeries:
+		key := GenerateKey(rec.Metric, rec.Tags)
+		shard := db.GetShard(key)
+		shard.Lock()
+		if _, ok := shard.Series[key]; !ok {
+			ts := db.newTimeSeries(rec.Metric, rec.Tags, rec.LSN)
+			ts.recountChunks()
+			shard.Series[key] = ts
+			db.index.add(key, ts)
+		}
+		shard.Unlock()
+	case walPoint:
+		ts, err := db.lookup(rec.Metric, rec.Tags)
+		if err != nil {
+			return
+		}
+		ts.Lock()
+		if rec.LSN > ts.lsn {
+			if err := ts.add(Point{Timestamp: rec.Timestamp, Value: rec.Value}, math.MaxInt64, nil); err != nil {
+				log.Printf("WAL: replaying point of %s at LSN %d: %v\n", GenerateKey(rec.Metric, rec.Tags), rec.LSN, err)
+			}
+			ts.lsn = rec.LSN
+		}
+		ts.Unlock()
+	case walDelete:
+		db.delete(rec.Metric, rec.Tags, rec.Start, rec.End, func() (uint64, error) {
+			return rec.LSN, nil
+		})
+	case walBackfill:
+		ts, err := db.lookup(rec.Metric, rec.Tags)
+		if err != nil {
+			return
+		}
+		ts.Lock()
+		if rec.LSN > ts.lsn {
+			if err := ts.backfill(rec.Points, true, nil); err != nil {
+				log.Printf("WAL: replaying backfill of %s at LSN %d: %v\n", GenerateKey(rec.Metric, rec.Tags), rec.LSN, err)
+			}
+			ts.lsn = rec.LSN
+		}
+		ts.Unlock()
+	case walMetadata:
+		db.metadataMu.Lock()
+		db.setMetadata(metricMetadata{Metric: rec.Metric, Tenant: TenantOf(rec.Tags), Metadata: rec.Metadata, LSN: rec.LSN})
+		db.metadataMu.Unlock()
+	}
+}
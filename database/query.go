@@ -1,27 +1,78 @@
 package database
 
-import "errors"
+import (
+	"context"
 
+	"github.com/sinnlos-ffff/tsdb-lite/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// GetRange returns the points of a series with start <= timestamp <= end,
+// in time order.
 func (db *Database) GetRange(metric string, tags map[string]string, start, end int64) ([]Point, error) {
+	return db.GetRangeContext(context.Background(), metric, tags, start, end)
+}
+
+// GetRangeContext is GetRange, traced as part of the span in ctx.
+func (db *Database) GetRangeContext(ctx context.Context, metric string, tags map[string]string, start, end int64) ([]Point, error) {
+	ctx, span := tracer.Start(ctx, "Database.GetRange", trace.WithAttributes(
+		attribute.String("metric", metric), attribute.Int64("start", start), attribute.Int64("end", end)))
+	defer span.End()
+
+	timeSeries, err := db.lookupContext(ctx, metric, tags)
+	if err != nil {
+		return nil, spanError(span, err)
+	}
+
+	timeSeries.rlockContext(ctx)
+	defer timeSeries.RUnlock()
+
+	points, scanned := timeSeries.pointsContext(ctx, start, end)
+	metrics.QueryPointsScanned.WithLabelValues("range").Observe(float64(scanned))
+	return points, nil
+}
+
+func (db *Database) lookup(metric string, tags map[string]string) (*TimeSeries, error) {
 	key := GenerateKey(metric, tags)
-	shard := db.GetShard(key)
 
+	db.mu.RLock()
+	shard := db.getShard(key)
 	shard.RLock()
-	defer shard.RUnlock()
-
 	timeSeries, exists := shard.Series[key]
+	shard.RUnlock()
+	db.mu.RUnlock()
+
 	if !exists {
-		return nil, errors.New("time series not found")
+		return nil, seriesError(ErrSeriesNotFound, metric, tags)
 	}
+	return timeSeries, nil
+}
 
+// points returns the points with start <= timestamp <= end, in time order,
+// and how many stored points it read to find them. Must be called with ts
+// read-locked.
+func (ts *TimeSeries) points(start, end int64) ([]Point, int) {
 	var result []Point
-	for _, chunk := range timeSeries.Chunks {
+	scanned := len(ts.OutOfOrder)
+	for _, chunk := range ts.Chunks {
+		scanned += len(chunk.Points)
 		for _, point := range chunk.Points {
-			if point.Timestamp >= start && point.Timestamp <= end {
+			if point.Timestamp >= start && point.Timestamp <= end && !(chunk.Compacted && ts.masked(point.Timestamp)) {
 				result = append(result, point)
 			}
 		}
 	}
 
-	return result, nil
+	var outOfOrder []Point
+	for _, point := range ts.OutOfOrder {
+		if point.Timestamp >= start && point.Timestamp <= end {
+			outOfOrder = append(outOfOrder, point)
+		}
+	}
+	if len(outOfOrder) > 0 {
+		result = mergePoints(result, outOfOrder)
+	}
+
+	return result, scanned
 }
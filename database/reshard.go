@@ -0,0 +1,89 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/sinnlos-ffff/tsdb-lite/metrics"
+)
+
+// Reshard rehashes every series into a new array of n shards. Series are
+// moved by pointer while writes continue; writers only wait for the final
+// switchover, which replays the series added or removed in the meantime.
+func (db *Database) Reshard(n int) error {
+	if n <= 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidShardCount, n)
+	}
+
+	db.reshardMu.Lock()
+	defer db.reshardMu.Unlock()
+
+	db.changesMu.Lock()
+	db.changes = make(map[string]struct{})
+	db.changesMu.Unlock()
+
+	old := db.shards()
+	shards := newShards(n)
+	for _, shard := range old {
+		shard.RLock()
+		for key, ts := range shard.Series {
+			shards[shardIndex(key, n)].Series[key] = ts
+		}
+		shard.RUnlock()
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.changesMu.Lock()
+	for key := range db.changes {
+		dst := shards[shardIndex(key, n)]
+		if ts, ok := old[shardIndex(key, len(old))].Series[key]; ok {
+			dst.Series[key] = ts
+		} else {
+			delete(dst.Series, key)
+		}
+	}
+	db.changes = nil
+	db.changesMu.Unlock()
+
+	db.Shards = shards
+	db.generation++
+	metrics.Shards.Set(float64(n))
+
+	return nil
+}
+
+// trackChange records that the series with the given key was added or
+// removed. Must be called with db.mu read-locked.
+func (db *Database) trackChange(key string) {
+	db.changesMu.Lock()
+	defer db.changesMu.Unlock()
+
+	if db.changes != nil {
+		db.changes[key] = struct{}{}
+	}
+}
+
+// forEachShard calls fn for every shard while holding db.mu for reading,
+// so fn may add or remove series. The lock is released between shards to
+// keep a concurrent Reshard from waiting on the whole walk; if one swaps
+// the shards in between, the walk starts over on the new array, so fn must
+// be safe to repeat.
+func (db *Database) forEachShard(fn func(*Shard)) {
+	db.mu.RLock()
+	generation := db.generation
+	db.mu.RUnlock()
+
+	for i := 0; ; i++ {
+		db.mu.RLock()
+		if db.generation != generation {
+			generation, i = db.generation, 0
+		}
+		if i >= len(db.Shards) {
+			db.mu.RUnlock()
+			return
+		}
+		fn(db.Shards[i])
+		db.mu.RUnlock()
+	}
+}
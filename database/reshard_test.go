@@ -0,0 +1,72 @@
+package database
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReshard(t *testing.T) {
+	db := NewDatabaseWithOptions(&Options{ShardCount: 8})
+	require.Len(t, db.Shards, 8)
+
+	for i := range 100 {
+		tags := map[string]string{"host": fmt.Sprint(i)}
+		require.NoError(t, db.AddTimeSeries("test_metric", tags))
+		require.NoError(t, db.AddPoint("test_metric", tags, 1000, float64(i)))
+	}
+
+	require.NoError(t, db.Reshard(128))
+	require.Len(t, db.Shards, 128)
+
+	total := 0
+	for _, shard := range db.Shards {
+		total += len(shard.Series)
+	}
+	assert.Equal(t, 100, total)
+
+	for i := range 100 {
+		points, err := db.GetRange("test_metric", map[string]string{"host": fmt.Sprint(i)}, 0, 2000)
+		require.NoError(t, err)
+		assert.Equal(t, []Point{{1000, float64(i)}}, points)
+	}
+
+	assert.ErrorIs(t, db.Reshard(0), ErrInvalidShardCount)
+}
+
+func TestReshard_ConcurrentWrites(t *testing.T) {
+	db := NewDatabaseWithOptions(&Options{ShardCount: 4})
+
+	const writers, series = 8, 50
+	var wg sync.WaitGroup
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range series {
+				tags := map[string]string{"writer": fmt.Sprint(w), "series": fmt.Sprint(i)}
+				assert.NoError(t, db.AddTimeSeries("test_metric", tags))
+				for ts := range int64(10) {
+					assert.NoError(t, db.AddPoint("test_metric", tags, ts, 1.0))
+				}
+			}
+		}()
+	}
+
+	for _, n := range []int{16, 2, 64} {
+		require.NoError(t, db.Reshard(n))
+	}
+	wg.Wait()
+
+	for w := range writers {
+		for i := range series {
+			tags := map[string]string{"writer": fmt.Sprint(w), "series": fmt.Sprint(i)}
+			points, err := db.GetRange("test_metric", tags, 0, 100)
+			require.NoError(t, err)
+			assert.Len(t, points, 10)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package database
+
+import (
+	"context"
+	"math"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/sinnlos-ffff/tsdb-lite/metrics"
+)
+
+// retention returns how long points of the given metric are kept, using the
+// longest matching prefix in MetricRetention and falling back to Retention.
+// Zero means points are kept forever.
+func (db *Database) retention(metric string) time.Duration {
+	live := db.live.Load()
+	retention, matched := live.Retention, -1
+	for prefix, r := range live.MetricRetention {
+		if len(prefix) > matched && strings.HasPrefix(metric, prefix) {
+			retention, matched = r, len(prefix)
+		}
+	}
+	return retention
+}
+
+// seriesRetention is retention capped by the retention of the series'
+// tenant, if it has one.
+func (db *Database) seriesRetention(metric string, tags map[string]string) time.Duration {
+	retention := db.retention(metric)
+	if l, err := db.tenantLimits(TenantOf(tags)); err == nil && l.Retention > 0 && (retention <= 0 || l.Retention < retention) {
+		retention = l.Retention
+	}
+	return retention
+}
+
+func (db *Database) hasRetention() bool {
+	live := db.live.Load()
+	if live.Retention > 0 {
+		return true
+	}
+	for _, l := range live.Tenants {
+		if l.Retention > 0 {
+			return true
+		}
+	}
+	for _, r := range live.MetricRetention {
+		if r > 0 {
+			return true
+		}
+	}
+	for _, tier := range db.opts.Rollups {
+		if tier.Retention > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpireChunks drops every chunk whose points are all older than the
+// retention period of its series, relative to now, along with rollup
+// aggregates past their tier's retention. Series left with no data are
+// removed from their shard.
+func (db *Database) ExpireChunks(now time.Time) {
+	db.expireChunks(context.Background(), now)
+}
+
+// expireChunks is ExpireChunks, stopping between series once ctx is done.
+func (db *Database) expireChunks(ctx context.Context, now time.Time) {
+	db.forEachShard(func(shard *Shard) {
+		for key, ts := range shard.expire(ctx, now.Unix(), func(ts *TimeSeries) (int64, bool) {
+			retention := db.seriesRetention(ts.Metric, ts.Tags)
+			if retention <= 0 {
+				return 0, false
+			}
+			return now.Add(-retention).Unix(), true
+		}) {
+			db.index.remove(key, ts)
+			db.trackChange(key)
+		}
+	})
+}
+
+// StartRetention calls ExpireChunks every interval while there is any
+// retention to apply, which Reload may change, until ctx is done.
+func (db *Database) StartRetention(ctx context.Context, interval time.Duration) {
+	db.background.Add(1)
+	go func() {
+		defer db.background.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if db.hasRetention() {
+					db.expireChunks(ctx, now)
+				}
+			}
+		}
+	}()
+}
+
+// expire applies retention to every series in the shard and returns the
+// series it removed by key. It stops between series once ctx is done.
+func (s *Shard) expire(ctx context.Context, now int64, cutoff func(ts *TimeSeries) (int64, bool)) map[string]*TimeSeries {
+	s.Lock()
+	defer s.Unlock()
+
+	removed := make(map[string]*TimeSeries)
+	for key, ts := range s.Series {
+		if ctx.Err() != nil {
+			break
+		}
+		minT, ok := cutoff(ts)
+		if !ok {
+			minT = math.MinInt64
+		}
+		if ts.expire(now, minT) {
+			delete(s.Series, key)
+			removed[key] = ts
+			metrics.RetentionSeriesRemovedTotal.Inc()
+		}
+	}
+	return removed
+}
+
+// expire drops chunks and out-of-order points older than minT and rollup
+// aggregates past their retention, and reports whether that left the series
+// empty.
+func (ts *TimeSeries) expire(now, minT int64) bool {
+	ts.Lock()
+	defer ts.Unlock()
+	defer ts.recountChunks()
+
+	expired := false
+	for _, r := range ts.Rollups {
+		if r.expire(now) {
+			expired = true
+		}
+	}
+
+	var points, bytes int
+	n := 0
+	for _, chunk := range ts.Chunks {
+		if len(chunk.Points) == 0 || chunk.Points[len(chunk.Points)-1].Timestamp >= minT {
+			break
+		}
+		// Make sure a chunk the compactor has not sealed yet still
+		// reaches the rollups before it is dropped.
+		ts.rollupChunk(chunk)
+		points += len(chunk.Points)
+		bytes += cap(chunk.Points) * int(unsafe.Sizeof(Point{}))
+		n++
+	}
+
+	dropped := 0
+	for dropped < len(ts.OutOfOrder) && ts.OutOfOrder[dropped].Timestamp < minT {
+		dropped++
+	}
+	points += dropped
+	bytes += dropped * int(unsafe.Sizeof(Point{}))
+	ts.OutOfOrder = ts.OutOfOrder[dropped:]
+
+	if points == 0 && !expired {
+		return false
+	}
+
+	metrics.RetentionPointsReclaimedTotal.Add(float64(points))
+	metrics.RetentionBytesReclaimedTotal.Add(float64(bytes))
+
+	ts.Chunks = ts.Chunks[n:]
+	if _, ok := ts.maxTimestamp(); !ok && len(ts.OutOfOrder) == 0 && ts.rollupsEmpty() {
+		ts.removed = true
+		return true
+	}
+	if len(ts.Chunks) == 0 {
+		ts.Chunks = []*Chunk{{Points: make([]Point, 0, ts.maxChunkSize())}}
+	}
+	return false
+}
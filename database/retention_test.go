@@ -0,0 +1,76 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetention_Overrides(t *testing.T) {
+	db := NewDatabaseWithOptions(&Options{
+		Retention: time.Hour,
+		MetricRetention: map[string]time.Duration{
+			"debug_":      time.Minute,
+			"debug_keep_": 0,
+		},
+	})
+
+	assert.Equal(t, time.Hour, db.retention("cpu_usage"))
+	assert.Equal(t, time.Minute, db.retention("debug_trace"))
+	assert.Equal(t, time.Duration(0), db.retention("debug_keep_forever"))
+}
+
+func TestExpireChunks(t *testing.T) {
+	db := NewDatabaseWithOptions(&Options{Retention: time.Hour})
+	now := time.Unix(100_000, 0)
+	tags := map[string]string{"tag1": "value1"}
+
+	// One series with a full old chunk and a recent head
+	require.NoError(t, db.AddTimeSeries("mixed", tags))
+	for i := range ChunkSize {
+		require.NoError(t, db.AddPoint("mixed", tags, int64(i), 1.0))
+	}
+	require.NoError(t, db.AddPoint("mixed", tags, now.Unix(), 1.0))
+
+	// One series with only old points, and one that was never written to
+	require.NoError(t, db.AddTimeSeries("stale", tags))
+	require.NoError(t, db.AddPoint("stale", tags, 10, 1.0))
+	require.NoError(t, db.AddTimeSeries("empty", tags))
+
+	db.ExpireChunks(now)
+
+	points, err := db.GetRange("mixed", tags, 0, now.Unix())
+	require.NoError(t, err)
+	assert.Equal(t, []Point{{now.Unix(), 1.0}}, points)
+
+	_, err = db.GetRange("stale", tags, 0, now.Unix())
+	assert.ErrorIs(t, err, ErrSeriesNotFound)
+	assert.ErrorIs(t, db.AddPoint("stale", tags, now.Unix(), 1.0), ErrSeriesNotFound)
+
+	_, err = db.GetRange("empty", tags, 0, now.Unix())
+	assert.NoError(t, err)
+}
+
+func TestExpireChunks_Replay(t *testing.T) {
+	opts := &Options{ChunkSize: 2, WALDir: t.TempDir(), Retention: time.Hour}
+	db, err := Open(opts)
+	require.NoError(t, err)
+
+	now := time.Now().Unix()
+	require.NoError(t, db.AddTimeSeries("test_metric", nil))
+	for _, timestamp := range []int64{now - 7200, now - 7199, now} {
+		require.NoError(t, db.AddPoint("test_metric", nil, timestamp, 1.0))
+	}
+	db.ExpireChunks(time.Unix(now, 0))
+	require.NoError(t, db.Close())
+
+	// Expired points do not come back with the WAL
+	db, err = Open(opts)
+	require.NoError(t, err)
+	defer db.Close()
+	points, err := db.GetRange("test_metric", nil, 0, now)
+	require.NoError(t, err)
+	assert.Equal(t, []Point{{now, 1.0}}, points)
+}
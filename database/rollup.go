@@ -0,0 +1,355 @@
+package database
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/sinnlos-ffff/tsdb-lite/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RollupTier configures one lower-resolution copy of every series. Like
+// Retention, both durations assume timestamps in Unix seconds.
+type RollupTier struct {
+	Resolution time.Duration
+	Retention  time.Duration
+}
+
+// Aggregate summarises the points of one Resolution-aligned bucket.
+type Aggregate struct {
+	Timestamp int64
+	Min       float64
+	Max       float64
+	Sum       float64
+	Count     int64
+}
+
+func (a *Aggregate) add(b Aggregate) {
+	if a.Count == 0 {
+		*a = b
+		return
+	}
+	a.Min = math.Min(a.Min, b.Min)
+	a.Max = math.Max(a.Max, b.Max)
+	a.Sum += b.Sum
+	a.Count += b.Count
+}
+
+func pointAggregate(p Point, timestamp int64) Aggregate {
+	return Aggregate{Timestamp: timestamp, Min: p.Value, Max: p.Value, Sum: p.Value, Count: 1}
+}
+
+type Aggregation int
+
+const (
+	AggregateAvg Aggregation = iota
+	AggregateMin
+	AggregateMax
+	AggregateSum
+	AggregateCount
+	// AggregateRate is the per-second increase of a counter. It is
+	// computed from raw points only, as rollups do not keep the first and
+	// last value of their buckets.
+	AggregateRate
+)
+
+func (a Aggregate) value(fn Aggregation) float64 {
+	switch fn {
+	case AggregateMin:
+		return a.Min
+	case AggregateMax:
+		return a.Max
+	case AggregateSum:
+		return a.Sum
+	case AggregateCount:
+		return float64(a.Count)
+	default:
+		return a.Sum / float64(a.Count)
+	}
+}
+
+// Rollup is a shadow series holding the aggregates of a series' sealed
+// chunks at a coarser resolution.
+type Rollup struct {
+	Resolution int64
+	Retention  int64
+	Aggregates []Aggregate
+
+	// pending is the bucket still being filled; rolledUp is the newest
+	// timestamp already folded into the rollup, whether into Aggregates or
+	// pending.
+	pending  Aggregate
+	rolledUp int64
+}
+
+func newRollups(tiers []RollupTier) []*Rollup {
+	rollups := make([]*Rollup, 0, len(tiers))
+	for _, tier := range tiers {
+		if tier.Resolution < time.Second {
+			continue
+		}
+		rollups = append(rollups, &Rollup{
+			Resolution: int64(tier.Resolution / time.Second),
+			Retention:  int64(tier.Retention / time.Second),
+			rolledUp:   math.MinInt64,
+		})
+	}
+	return rollups
+}
+
+func (r *Rollup) bucket(timestamp int64) int64 {
+	return floorDiv(timestamp, r.Resolution) * r.Resolution
+}
+
+// add folds a sealed point into the rollup. Points must arrive in time
+// order; points at or before the rollup's watermark are ignored, so a late
+// sample merged into an already rolled up chunk is only visible in the raw
+// data.
+func (r *Rollup) add(p Point) {
+	if p.Timestamp <= r.rolledUp {
+		return
+	}
+	r.rolledUp = p.Timestamp
+
+	bucket := r.bucket(p.Timestamp)
+	if r.pending.Count > 0 && r.pending.Timestamp != bucket {
+		r.Aggregates = append(r.Aggregates, r.pending)
+		r.pending = Aggregate{}
+	}
+	r.pending.add(pointAggregate(p, bucket))
+}
+
+// whole returns the first and last timestamp of the buckets that lie
+// entirely between start and end, or false if there are none.
+func (r *Rollup) whole(start, end int64) (int64, int64, bool) {
+	if off := floorMod(start, r.Resolution); off > 0 {
+		if start > math.MaxInt64-(r.Resolution-off) {
+			return 0, 0, false
+		}
+		start += r.Resolution - off
+	}
+	if off := floorMod(end, r.Resolution); off < r.Resolution-1 {
+		if end < math.MinInt64+off+1 {
+			return 0, 0, false
+		}
+		end -= off + 1
+	}
+	return start, end, start <= end
+}
+
+// rawFrom is the first timestamp not yet folded into the rollup, which
+// queries must read from the raw chunks instead.
+func (r *Rollup) rawFrom() int64 {
+	if r.rolledUp == math.MinInt64 {
+		return math.MinInt64
+	}
+	return r.rolledUp + 1
+}
+
+// expire drops aggregates past the rollup's retention and reports whether
+// there were any.
+func (r *Rollup) expire(now int64) bool {
+	if r.Retention <= 0 {
+		return false
+	}
+	minT := now - r.Retention
+	n := 0
+	for n < len(r.Aggregates) && r.Aggregates[n].Timestamp+r.Resolution <= minT {
+		n++
+	}
+	r.Aggregates = r.Aggregates[n:]
+	if r.pending.Count > 0 && r.pending.Timestamp+r.Resolution <= minT {
+		r.pending = Aggregate{}
+		n++
+	}
+	return n > 0
+}
+
+func (r *Rollup) empty() bool {
+	return len(r.Aggregates) == 0 && r.pending.Count == 0
+}
+
+// rollupChunk feeds a sealed chunk to every rollup of the series. Must be
+// called with ts locked.
+func (ts *TimeSeries) rollupChunk(chunk *Chunk) {
+	for _, r := range ts.Rollups {
+		for _, p := range chunk.Points {
+			r.add(p)
+		}
+	}
+}
+
+func (ts *TimeSeries) rollupsEmpty() bool {
+	for _, r := range ts.Rollups {
+		if !r.empty() {
+			return false
+		}
+	}
+	return true
+}
+
+// pickRollup returns the coarsest rollup whose buckets fit evenly into
+// step, or nil if the query has to be answered from raw points.
+func (ts *TimeSeries) pickRollup(step int64) *Rollup {
+	var best *Rollup
+	for _, r := range ts.Rollups {
+		if r.Resolution <= step && step%r.Resolution == 0 && (best == nil || r.Resolution > best.Resolution) {
+			best = r
+		}
+	}
+	return best
+}
+
+// GetRangeStep aggregates the points of a series with start <= timestamp
+// <= end into step-aligned windows, returning one point per non-empty
+// window. It reads the buckets that lie wholly within the range from the
+// coarsest rollup that fits step, and the rest, including buckets the range
+// only partly covers, from the raw points. A step of zero returns raw points
+// like GetRange, except for AggregateRate, which then returns the rate
+// between consecutive points.
+func (db *Database) GetRangeStep(metric string, tags map[string]string, start, end, step int64, fn Aggregation) ([]Point, error) {
+	return db.GetRangeStepContext(context.Background(), metric, tags, start, end, step, fn)
+}
+
+// GetRangeStepContext is GetRangeStep, traced as part of the span in ctx.
+func (db *Database) GetRangeStepContext(ctx context.Context, metric string, tags map[string]string, start, end, step int64, fn Aggregation) ([]Point, error) {
+	if fn != AggregateRate && step <= 0 {
+		return db.GetRangeContext(ctx, metric, tags, start, end)
+	}
+
+	ctx, span := tracer.Start(ctx, "Database.GetRangeStep", trace.WithAttributes(
+		attribute.String("metric", metric), attribute.Int64("start", start), attribute.Int64("end", end),
+		attribute.Int64("step", step), attribute.Int("aggregation", int(fn))))
+	defer span.End()
+
+	timeSeries, err := db.lookupContext(ctx, metric, tags)
+	if err != nil {
+		return nil, spanError(span, err)
+	}
+
+	timeSeries.rlockContext(ctx)
+	defer timeSeries.RUnlock()
+
+	scanned := 0
+	defer func() {
+		span.SetAttributes(attribute.Int("points_scanned", scanned))
+		metrics.QueryPointsScanned.WithLabelValues("range").Observe(float64(scanned))
+	}()
+	raw := func(start, end int64) []Point {
+		points, n := timeSeries.pointsContext(ctx, start, end)
+		scanned += n
+		return points
+	}
+
+	if fn == AggregateRate {
+		return counterRate(raw(start, end), step), nil
+	}
+
+	var result []Point
+	var window Aggregate
+	add := func(a Aggregate) {
+		a.Timestamp = floorDiv(a.Timestamp, step) * step
+		if window.Count > 0 && window.Timestamp != a.Timestamp {
+			result = append(result, Point{Timestamp: window.Timestamp, Value: window.value(fn)})
+			window = Aggregate{}
+		}
+		window.add(a)
+	}
+	addRaw := func(start, end int64) {
+		for _, p := range raw(start, end) {
+			add(pointAggregate(p, p.Timestamp))
+		}
+	}
+
+	r := timeSeries.pickRollup(step)
+	var lo, hi int64
+	if r != nil {
+		var ok bool
+		if lo, hi, ok = r.whole(start, end); !ok {
+			r = nil
+		}
+	}
+	if r == nil {
+		addRaw(start, end)
+	} else {
+		// Raw points before the first whole bucket, the whole buckets
+		// rolled up so far, then raw points from where the rollup stops
+		if lo > start {
+			addRaw(start, lo-1)
+		}
+		aggregates := append(r.Aggregates[:len(r.Aggregates):len(r.Aggregates)], r.pending)
+		scanned += len(aggregates)
+		for _, a := range aggregates {
+			if a.Count > 0 && a.Timestamp >= lo && a.Timestamp <= hi {
+				add(a)
+			}
+		}
+		rawFrom := max(lo, r.rawFrom())
+		if hi < end {
+			rawFrom = min(rawFrom, hi+1)
+		}
+		addRaw(rawFrom, end)
+	}
+
+	if window.Count > 0 {
+		result = append(result, Point{Timestamp: window.Timestamp, Value: window.value(fn)})
+	}
+	return result, nil
+}
+
+// counterRate returns the increase of a counter per timestamp unit in each
+// step-aligned window, or between consecutive points if step is zero. A
+// decrease is taken as a counter reset to zero. Each increase is counted in
+// the window of the later of its two points.
+func counterRate(points []Point, step int64) []Point {
+	var result []Point
+	var window Point
+	have := false
+	for i := 1; i < len(points); i++ {
+		prev, cur := points[i-1], points[i]
+		if cur.Timestamp == prev.Timestamp {
+			continue
+		}
+		increase := cur.Value - prev.Value
+		if increase < 0 {
+			increase = cur.Value
+		}
+
+		if step <= 0 {
+			result = append(result, Point{Timestamp: cur.Timestamp, Value: increase / float64(cur.Timestamp-prev.Timestamp)})
+			continue
+		}
+
+		timestamp := floorDiv(cur.Timestamp, step) * step
+		if have && window.Timestamp != timestamp {
+			result = append(result, Point{Timestamp: window.Timestamp, Value: window.Value / float64(step)})
+			window = Point{}
+		}
+		window.Timestamp = timestamp
+		window.Value += increase
+		have = true
+	}
+	if have {
+		result = append(result, Point{Timestamp: window.Timestamp, Value: window.Value / float64(step)})
+	}
+	return result
+}
+
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// floorMod returns a modulo b with the sign of b.
+func floorMod(a, b int64) int64 {
+	m := a % b
+	if m != 0 && (m < 0) != (b < 0) {
+		m += b
+	}
+	return m
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRollup_Compaction(t *testing.T) {
+	db := NewDatabaseWithOptions(&Options{
+		Rollups: []RollupTier{
+			{Resolution: 5 * time.Minute, Retention: 90 * 24 * time.Hour},
+			{Resolution: time.Hour, Retention: 2 * 365 * 24 * time.Hour},
+		},
+	})
+	metric := "test_metric"
+	tags := map[string]string{"tag1": "value1"}
+	require.NoError(t, db.AddTimeSeries(metric, tags))
+
+	// One point per minute, enough to seal one chunk
+	for i := range ChunkSize + 10 {
+		require.NoError(t, db.AddPoint(metric, tags, int64(i*60), float64(i)))
+	}
+
+	key := GenerateKey(metric, tags)
+	shard := db.GetShard(key)
+	shard.CompactChunks()
+
+	ts := shard.Series[key]
+	require.Len(t, ts.Rollups, 2)
+
+	fiveMinutes := ts.Rollups[0]
+	assert.Equal(t, int64(300), fiveMinutes.Resolution)
+	assert.Len(t, fiveMinutes.Aggregates, ChunkSize/5)
+	assert.Equal(t, Aggregate{Timestamp: 0, Min: 0, Max: 4, Sum: 10, Count: 5}, fiveMinutes.Aggregates[0])
+
+	hour := ts.Rollups[1]
+	assert.Len(t, hour.Aggregates, ChunkSize/60)
+	assert.Equal(t, Aggregate{Timestamp: 3600, Min: 60, Max: 119, Sum: 5370, Count: 60}, hour.Aggregates[1])
+
+	// Compacting again does not roll the same points up twice
+	shard.CompactChunks()
+	assert.Len(t, hour.Aggregates, ChunkSize/60)
+}
+
+func TestGetRangeStep(t *testing.T) {
+	db := NewDatabaseWithOptions(&Options{
+		Rollups: []RollupTier{{Resolution: time.Hour}},
+	})
+	metric := "test_metric"
+	tags := map[string]string{"tag1": "value1"}
+	require.NoError(t, db.AddTimeSeries(metric, tags))
+
+	for i := range ChunkSize + 200 {
+		require.NoError(t, db.AddPoint(metric, tags, int64(i*60), 1.0))
+	}
+
+	key := GenerateKey(metric, tags)
+	db.GetShard(key).CompactChunks()
+
+	// The rollup covers the first chunk, raw points the rest
+	end := int64((ChunkSize + 200) * 60)
+	points, err := db.GetRangeStep(metric, tags, 0, end, 2*3600, AggregateCount)
+	require.NoError(t, err)
+
+	var total float64
+	for i, p := range points {
+		assert.Equal(t, int64(i*2*3600), p.Timestamp)
+		total += p.Value
+	}
+	assert.Equal(t, float64(ChunkSize+200), total)
+	assert.Equal(t, 120.0, points[0].Value)
+
+	// Buckets the range only partly covers are read from raw points
+	points, err = db.GetRangeStep(metric, tags, 1800, 3*3600+1799, 3600, AggregateCount)
+	require.NoError(t, err)
+	assert.Equal(t, []Point{{0, 30}, {3600, 60}, {7200, 60}, {10800, 30}}, points)
+
+	// A step the rollup does not divide is answered from raw points
+	points, err = db.GetRangeStep(metric, tags, 0, 599, 300, AggregateAvg)
+	require.NoError(t, err)
+	assert.Equal(t, []Point{{0, 1.0}, {300, 1.0}}, points)
+
+	// No step returns raw points
+	points, err = db.GetRangeStep(metric, tags, 0, 120, 0, AggregateAvg)
+	require.NoError(t, err)
+	assert.Len(t, points, 3)
+}
+
+func TestGetRangeStep_LateAndOverwrittenPoints(t *testing.T) {
+	db := NewDatabaseWithOptions(&Options{
+		ChunkSize:        4,
+		OutOfOrderWindow: 100,
+		Rollups:          []RollupTier{{Resolution: 10 * time.Second}},
+	})
+	metric := "test_metric"
+	require.NoError(t, db.AddTimeSeries(metric, nil))
+	for i := range 8 {
+		require.NoError(t, db.AddPoint(metric, nil, int64(i*2), 1))
+	}
+	shard := db.GetShard(GenerateKey(metric, nil))
+	shard.CompactChunks()
+
+	maxOf := func() float64 {
+		points, err := db.GetRangeStep(metric, nil, 0, 9, 10, AggregateMax)
+		require.NoError(t, err)
+		require.Len(t, points, 1)
+		return points[0].Value
+	}
+	require.Equal(t, 1.0, maxOf())
+
+	// A late point merged by compaction reaches the rolled up bucket
+	require.NoError(t, db.AddPoint(metric, nil, 5, 50))
+	shard.CompactChunks()
+	assert.Equal(t, 50.0, maxOf())
+
+	// Overwriting a sealed point rebuilds its bucket
+	require.NoError(t, db.AddPoint(metric, nil, 2, 100))
+	assert.Equal(t, 100.0, maxOf())
+	require.NoError(t, db.AddPoint(metric, nil, 2, 1))
+	assert.Equal(t, 50.0, maxOf())
+}
+
+func TestExpireChunks_Rollups(t *testing.T) {
+	db := NewDatabaseWithOptions(&Options{
+		Retention: time.Hour,
+		Rollups:   []RollupTier{{Resolution: time.Hour, Retention: 24 * time.Hour}},
+	})
+	metric := "test_metric"
+	tags := map[string]string{"tag1": "value1"}
+	require.NoError(t, db.AddTimeSeries(metric, tags))
+
+	for i := range ChunkSize {
+		require.NoError(t, db.AddPoint(metric, tags, int64(i*60), 1.0))
+	}
+
+	// Raw points are gone but their rollup is still within retention
+	db.ExpireChunks(time.Unix(int64(ChunkSize*60)+2*3600, 0))
+	points, err := db.GetRangeStep(metric, tags, 0, int64(ChunkSize/60+1)*3600-1, 3600, AggregateCount)
+	require.NoError(t, err)
+	assert.Len(t, points, ChunkSize/60+1)
+
+	// Once the rollup expires too, the series is removed
+	db.ExpireChunks(time.Unix(int64(ChunkSize*60)+48*3600, 0))
+	_, err = db.GetRange(metric, tags, 0, 0)
+	assert.ErrorIs(t, err, ErrSeriesNotFound)
+}
@@ -0,0 +1,170 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// SelfMetricPrefix starts the names of the series StartSelfMonitor writes,
+// which clients may not write to.
+const SelfMetricPrefix = "tsdb_self_"
+
+// isSelfMetric reports whether metric is one StartSelfMonitor writes. Such
+// series are exempt from the cardinality limits and tenant quotas, and their
+// points are not counted as ingested.
+func isSelfMetric(metric string) bool {
+	return strings.HasPrefix(metric, SelfMetricPrefix)
+}
+
+// SelfMetricName returns the name a metric of the server itself is stored
+// under, such as tsdb_self_ingest_total for tsdb_ingest_total and
+// tsdb_self_go_goroutines for go_goroutines.
+func SelfMetricName(name string) string {
+	return SelfMetricPrefix + strings.TrimPrefix(name, "tsdb_")
+}
+
+// StartSelfMonitor writes every metric gatherer has into the database, in
+// the default tenant, once per interval until ctx is done. Histograms and
+// summaries are stored like Prometheus stores them, as _bucket or quantile
+// series plus _sum and _count. The series are exempt from the cardinality
+// limits and tenant quotas.
+func (db *Database) StartSelfMonitor(ctx context.Context, gatherer prometheus.Gatherer, interval time.Duration) {
+	db.background.Add(1)
+	go func() {
+		defer db.background.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if _, err := db.scrapeSelf(gatherer, now.Unix()); err != nil {
+					log.Printf("Self-monitoring: %v\n", err)
+				}
+			}
+		}
+	}()
+}
+
+// scrapeSelf writes the current value of every metric gatherer has at
+// timestamp and returns how many samples it wrote.
+func (db *Database) scrapeSelf(gatherer prometheus.Gatherer, timestamp int64) (int, error) {
+	families, err := gatherer.Gather()
+	written, failed := 0, 0
+	var firstErr error
+	write := func(metric string, tags map[string]string, value float64) {
+		if math.IsNaN(value) {
+			return
+		}
+		if err := db.addSelfSample(metric, tags, timestamp, value); err != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+			return
+		}
+		written++
+	}
+
+	for _, family := range families {
+		name := SelfMetricName(family.GetName())
+		db.setSelfMetadata(name, family)
+
+		for _, m := range family.Metric {
+			tags := make(map[string]string, len(m.Label)+1)
+			for _, label := range m.Label {
+				if label.GetValue() != "" {
+					tags[label.GetName()] = label.GetValue()
+				}
+			}
+
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				write(name, tags, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				write(name, tags, m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				write(name, tags, m.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				for _, b := range h.Bucket {
+					write(name+"_bucket", withTag(tags, "le", formatFloat(b.GetUpperBound())), float64(b.GetCumulativeCount()))
+				}
+				write(name+"_bucket", withTag(tags, "le", "+Inf"), float64(h.GetSampleCount()))
+				write(name+"_sum", tags, h.GetSampleSum())
+				write(name+"_count", tags, float64(h.GetSampleCount()))
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.Quantile {
+					write(name, withTag(tags, "quantile", formatFloat(q.GetQuantile())), q.GetValue())
+				}
+				write(name+"_sum", tags, s.GetSampleSum())
+				write(name+"_count", tags, float64(s.GetSampleCount()))
+			}
+		}
+	}
+
+	if failed > 0 {
+		err = errors.Join(err, fmt.Errorf("%d samples not written: %w", failed, firstErr))
+	}
+	return written, err
+}
+
+// addSelfSample writes a point, creating its series first if needed.
+func (db *Database) addSelfSample(metric string, tags map[string]string, timestamp int64, value float64) error {
+	err := db.AddPoint(metric, tags, timestamp, value)
+	if !errors.Is(err, ErrSeriesNotFound) {
+		return err
+	}
+	if err := db.AddTimeSeries(metric, tags); err != nil && !errors.Is(err, ErrSeriesExists) {
+		return err
+	}
+	return db.AddPoint(metric, tags, timestamp, value)
+}
+
+// setSelfMetadata records the type and help of a metric family, unless
+// they are already set.
+func (db *Database) setSelfMetadata(name string, family *dto.MetricFamily) {
+	md := Metadata{Help: family.GetHelp()}
+	switch family.GetType() {
+	case dto.MetricType_COUNTER:
+		md.Type = MetricTypeCounter
+	case dto.MetricType_GAUGE:
+		md.Type = MetricTypeGauge
+	case dto.MetricType_HISTOGRAM:
+		md.Type = MetricTypeHistogram
+	case dto.MetricType_SUMMARY:
+		md.Type = MetricTypeSummary
+	}
+	if current, ok := db.GetMetadata("", name); ok && current == md {
+		return
+	}
+	if err := db.SetMetadata("", name, md); err != nil {
+		log.Printf("Self-monitoring: %v\n", err)
+	}
+}
+
+// withTag returns a copy of tags with one more tag.
+func withTag(tags map[string]string, name, value string) map[string]string {
+	result := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		result[k] = v
+	}
+	result[name] = value
+	return result
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
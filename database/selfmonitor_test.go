@@ -0,0 +1,79 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrapeSelf(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "tsdb_requests_total", Help: "Requests."}, []string{"method"})
+	goroutines := prometheus.NewGauge(prometheus.GaugeOpts{Name: "go_goroutines", Help: "Goroutines."})
+	latency := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "tsdb_latency_seconds", Help: "Latency.", Buckets: []float64{0.1, 1}})
+	registry.MustRegister(requests, goroutines, latency)
+
+	requests.WithLabelValues("AddPoint").Add(3)
+	goroutines.Set(12)
+	latency.Observe(0.5)
+	latency.Observe(2)
+
+	db := NewDatabase()
+	written, err := db.scrapeSelf(registry, 100)
+	require.NoError(t, err)
+	// 1 counter, 1 gauge, 3 buckets, _sum and _count
+	assert.Equal(t, 7, written)
+
+	points, err := db.GetRange("tsdb_self_requests_total", map[string]string{"method": "AddPoint"}, 0, 200)
+	require.NoError(t, err)
+	assert.Equal(t, []Point{{Timestamp: 100, Value: 3}}, points)
+
+	points, err = db.GetRange("tsdb_self_go_goroutines", map[string]string{}, 0, 200)
+	require.NoError(t, err)
+	assert.Equal(t, []Point{{Timestamp: 100, Value: 12}}, points)
+
+	for le, count := range map[string]float64{"0.1": 0, "1": 1, "+Inf": 2} {
+		points, err = db.GetRange("tsdb_self_latency_seconds_bucket", map[string]string{"le": le}, 0, 200)
+		require.NoError(t, err)
+		assert.Equal(t, []Point{{Timestamp: 100, Value: count}}, points, "le=%s", le)
+	}
+	points, err = db.GetRange("tsdb_self_latency_seconds_sum", map[string]string{}, 0, 200)
+	require.NoError(t, err)
+	assert.Equal(t, []Point{{Timestamp: 100, Value: 2.5}}, points)
+
+	md, ok := db.GetMetadata("", "tsdb_self_latency_seconds")
+	assert.True(t, ok)
+	assert.Equal(t, Metadata{Type: MetricTypeHistogram, Help: "Latency."}, md)
+
+	// A second scrape appends to the series created by the first
+	requests.WithLabelValues("AddPoint").Inc()
+	_, err = db.scrapeSelf(registry, 110)
+	require.NoError(t, err)
+	points, err = db.GetRange("tsdb_self_requests_total", map[string]string{"method": "AddPoint"}, 0, 200)
+	require.NoError(t, err)
+	assert.Equal(t, []Point{{Timestamp: 100, Value: 3}, {Timestamp: 110, Value: 4}}, points)
+}
+
+func TestScrapeSelf_Limits(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "tsdb_requests_total", Help: "Requests."}, []string{"method"})
+	registry.MustRegister(requests)
+	for _, method := range []string{"AddPoint", "GetRange", "Backfill"} {
+		requests.WithLabelValues(method).Inc()
+	}
+
+	db := NewDatabaseWithOptions(&Options{
+		Limits:  Limits{MaxSeries: 1, MaxSeriesPerMetric: 1},
+		Tenants: map[string]TenantLimits{"": {MaxSeries: 1, IngestRate: 1, IngestBurst: 1}},
+	})
+	written, err := db.scrapeSelf(registry, 100)
+	require.NoError(t, err)
+	assert.Equal(t, 3, written)
+
+	// The database's own series leave every limit to clients
+	require.NoError(t, db.AddTimeSeries("cpu_usage", nil))
+	require.NoError(t, db.AddPoint("cpu_usage", nil, 1, 1.0))
+	assert.ErrorIs(t, db.AddTimeSeries("memory_usage", nil), ErrLimitExceeded)
+}
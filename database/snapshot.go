@@ -0,0 +1,456 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	snapshotPrefix   = "snapshot-"
+	snapshotManifest = "manifest.json"
+	snapshotVersion  = 1
+)
+
+// SnapshotManifest describes a snapshot directory. Every series write with
+// an LSN up to LSN is contained in the snapshot; later ones may be too, in
+// which case the series records its own LSN.
+type SnapshotManifest struct {
+	Version  int                       `json:"version"`
+	LSN      uint64                    `json:"lsn"`
+	Created  time.Time                 `json:"created"`
+	Series   int                       `json:"series"`
+	Files    []SnapshotFile            `json:"files"`
+	Metadata map[string]metricMetadata `json:"metadata,omitempty"`
+}
+
+type SnapshotFile struct {
+	Name   string `json:"name"`
+	Series int    `json:"series"`
+	SHA256 string `json:"sha256"`
+}
+
+// snapshotSeries is the on-disk form of a TimeSeries.
+type snapshotSeries struct {
+	Metric     string
+	Tags       map[string]string
+	LSN        uint64
+	Chunks     []snapshotChunk
+	OutOfOrder []Point
+	Tombstones []Tombstone
+	Rollups    []snapshotRollup
+}
+
+type snapshotChunk struct {
+	Points    []Point
+	Compacted bool
+}
+
+type snapshotRollup struct {
+	Resolution int64
+	Aggregates []Aggregate
+	Pending    Aggregate
+	RolledUp   int64
+}
+
+// Snapshot writes every series to a new directory under opts.SnapshotDir and
+// returns its path. Each series is copied under its own lock, so ingestion
+// only waits for as long as that copy takes. Once the snapshot is complete,
+// WAL segments it makes redundant are removed.
+func (db *Database) Snapshot() (string, *SnapshotManifest, error) {
+	return db.SnapshotContext(context.Background())
+}
+
+// SnapshotContext is Snapshot, abandoned once ctx is done. An abandoned
+// snapshot leaves nothing behind and the WAL untouched.
+func (db *Database) SnapshotContext(ctx context.Context) (string, *SnapshotManifest, error) {
+	if db.opts.SnapshotDir == "" {
+		return "", nil, fmt.Errorf("snapshot directory: %w", ErrNotConfigured)
+	}
+
+	db.snapshotMu.Lock()
+	defer db.snapshotMu.Unlock()
+
+	// Every record logged before the rotation has an LSN of at most the
+	// one read after it. Writers hold their locks from logging a record
+	// until they have applied it, so the copies below, which are all made
+	// after the rotation, contain it.
+	var segment uint64
+	if db.wal != nil {
+		var err error
+		if segment, err = db.wal.Rotate(); err != nil {
+			return "", nil, err
+		}
+	}
+	lsn := db.lsn.Load()
+	metadata := db.copyMetadata()
+
+	manifest := &SnapshotManifest{
+		Version:  snapshotVersion,
+		LSN:      lsn,
+		Created:  time.Now().UTC(),
+		Metadata: metadata,
+	}
+	path := filepath.Join(db.opts.SnapshotDir, fmt.Sprintf("%s%020d", snapshotPrefix, lsn))
+	tmp := path + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return "", nil, err
+	}
+	if err := os.MkdirAll(tmp, 0o755); err != nil {
+		return "", nil, err
+	}
+
+	var sealed []sealedChunks
+	for i, shard := range db.shards() {
+		file, chunks, err := writeSnapshotFile(ctx, filepath.Join(tmp, fmt.Sprintf("shard-%04d.gob", i)), shard.series())
+		if err != nil {
+			os.RemoveAll(tmp)
+			return "", nil, err
+		}
+		manifest.Files = append(manifest.Files, *file)
+		manifest.Series += file.Series
+		sealed = append(sealed, chunks...)
+	}
+
+	if err := writeManifest(filepath.Join(tmp, snapshotManifest), manifest); err != nil {
+		os.RemoveAll(tmp)
+		return "", nil, err
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return "", nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", nil, err
+	}
+	for _, s := range sealed {
+		s.ts.persist(s.chunks)
+	}
+
+	if db.wal != nil {
+		if err := db.wal.Truncate(segment); err != nil {
+			return "", nil, err
+		}
+	}
+
+	return path, manifest, nil
+}
+
+// series returns the series currently in the shard.
+func (s *Shard) series() []*TimeSeries {
+	s.RLock()
+	defer s.RUnlock()
+
+	series := make([]*TimeSeries, 0, len(s.Series))
+	for _, ts := range s.Series {
+		series = append(series, ts)
+	}
+	return series
+}
+
+// snapshot deep-copies the series and returns its compacted chunks. It
+// returns false if the series has been removed in the meantime.
+func (ts *TimeSeries) snapshot() (snapshotSeries, []*Chunk, bool) {
+	ts.RLock()
+	defer ts.RUnlock()
+
+	if ts.removed {
+		return snapshotSeries{}, nil, false
+	}
+
+	s := snapshotSeries{
+		Metric:     ts.Metric,
+		Tags:       ts.Tags,
+		LSN:        ts.lsn,
+		Chunks:     make([]snapshotChunk, len(ts.Chunks)),
+		OutOfOrder: append([]Point(nil), ts.OutOfOrder...),
+		Tombstones: append([]Tombstone(nil), ts.Tombstones...),
+	}
+	var sealed []*Chunk
+	for i, chunk := range ts.Chunks {
+		s.Chunks[i] = snapshotChunk{
+			Points:    append([]Point(nil), chunk.Points...),
+			Compacted: chunk.Compacted,
+		}
+		if chunk.Compacted {
+			sealed = append(sealed, chunk)
+		}
+	}
+	s.Rollups = ts.snapshotRollups()
+	return s, sealed, true
+}
+
+// snapshotRollups copies the series' rollups. Must be called with ts
+// read-locked.
+func (ts *TimeSeries) snapshotRollups() []snapshotRollup {
+	var rollups []snapshotRollup
+	for _, r := range ts.Rollups {
+		rollups = append(rollups, snapshotRollup{
+			Resolution: r.Resolution,
+			Aggregates: append([]Aggregate(nil), r.Aggregates...),
+			Pending:    r.pending,
+			RolledUp:   r.rolledUp,
+		})
+	}
+	return rollups
+}
+
+// sealedChunks are the compacted chunks of a series a snapshot holds.
+type sealedChunks struct {
+	ts     *TimeSeries
+	chunks []*Chunk
+}
+
+// persist marks chunks of the series as held by a snapshot.
+func (ts *TimeSeries) persist(chunks []*Chunk) {
+	ts.Lock()
+	defer ts.Unlock()
+
+	for _, chunk := range chunks {
+		chunk.persisted.Store(true)
+	}
+	ts.recountChunks()
+}
+
+func writeSnapshotFile(ctx context.Context, path string, series []*TimeSeries) (*SnapshotFile, []sealedChunks, error) {
+	f, err := createChecksumFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Abort()
+
+	file := &SnapshotFile{Name: filepath.Base(path)}
+	var sealed []sealedChunks
+	for _, ts := range series {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		s, chunks, ok := ts.snapshot()
+		if !ok {
+			continue
+		}
+		if err := f.Encode(&s); err != nil {
+			return nil, nil, err
+		}
+		file.Series++
+		if len(chunks) > 0 {
+			sealed = append(sealed, sealedChunks{ts: ts, chunks: chunks})
+		}
+	}
+
+	if file.SHA256, err = f.Close(); err != nil {
+		return nil, nil, err
+	}
+	return file, sealed, nil
+}
+
+// checksumFile is a gob stream written to disk alongside its SHA-256.
+type checksumFile struct {
+	f   *os.File
+	h   hash.Hash
+	w   *bufio.Writer
+	enc *gob.Encoder
+}
+
+func createChecksumFile(path string) (*checksumFile, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	w := bufio.NewWriter(io.MultiWriter(f, h))
+	return &checksumFile{f: f, h: h, w: w, enc: gob.NewEncoder(w)}, nil
+}
+
+func (c *checksumFile) Encode(v any) error {
+	return c.enc.Encode(v)
+}
+
+// Close syncs the file and returns its hex-encoded SHA-256.
+func (c *checksumFile) Close() (string, error) {
+	if err := c.w.Flush(); err != nil {
+		return "", err
+	}
+	if err := c.f.Sync(); err != nil {
+		return "", err
+	}
+	if err := c.f.Close(); err != nil {
+		return "", err
+	}
+	c.f = nil
+	return hex.EncodeToString(c.h.Sum(nil)), nil
+}
+
+// Abort closes the file if Close has not.
+func (c *checksumFile) Abort() {
+	if c.f != nil {
+		c.f.Close()
+	}
+}
+
+// openChecksumFile reads the gob stream at path after verifying it against
+// its hex-encoded SHA-256.
+func openChecksumFile(path, checksum string) (*gob.Decoder, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(b)
+	if hex.EncodeToString(sum[:]) != checksum {
+		return nil, fmt.Errorf("%s: checksum mismatch", filepath.Base(path))
+	}
+	return gob.NewDecoder(bytes.NewReader(b)), nil
+}
+
+func writeManifest(path string, manifest *SnapshotManifest) error {
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o644)
+}
+
+func readManifest(dir string) (*SnapshotManifest, error) {
+	b, err := os.ReadFile(filepath.Join(dir, snapshotManifest))
+	if err != nil {
+		return nil, err
+	}
+	var manifest SnapshotManifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", manifest.Version)
+	}
+	return &manifest, nil
+}
+
+// LatestSnapshot returns the path of the newest complete snapshot in dir,
+// or "" if there is none.
+func LatestSnapshot(dir string) (string, error) {
+	return latestDir(dir, snapshotPrefix)
+}
+
+// latestDir returns the path of the last complete directory in dir whose
+// name starts with prefix, or "" if there is none.
+func latestDir(dir, prefix string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() && strings.HasPrefix(e.Name(), prefix) && !strings.HasSuffix(e.Name(), ".tmp") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return "", nil
+	}
+	return filepath.Join(dir, names[len(names)-1]), nil
+}
+
+// LoadSnapshot verifies the checksums of the snapshot in dir and adds its
+// series to the database, which should be empty.
+func (db *Database) LoadSnapshot(dir string) error {
+	manifest, err := readManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range manifest.Files {
+		if err := db.loadSnapshotFile(filepath.Join(dir, file.Name), file.SHA256); err != nil {
+			return err
+		}
+	}
+	db.restoreMetadata(manifest.Metadata)
+
+	if manifest.LSN > db.lsn.Load() {
+		db.lsn.Store(manifest.LSN)
+	}
+	return nil
+}
+
+func (db *Database) loadSnapshotFile(path, checksum string) error {
+	dec, err := openChecksumFile(path, checksum)
+	if err != nil {
+		return err
+	}
+
+	for {
+		var s snapshotSeries
+		if err := dec.Decode(&s); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		db.restoreSeries(&s, true)
+	}
+}
+
+// restoreSeries adds a series read from disk, marking its compacted chunks
+// as persisted if a snapshot holds them.
+func (db *Database) restoreSeries(s *snapshotSeries, persisted bool) {
+	ts := db.newTimeSeries(s.Metric, s.Tags, s.LSN)
+	ts.Chunks = ts.Chunks[:0]
+	for _, c := range s.Chunks {
+		points := make([]Point, len(c.Points), max(len(c.Points), ts.maxChunkSize()))
+		copy(points, c.Points)
+		chunk := &Chunk{Points: points, Count: len(points), Compacted: c.Compacted}
+		chunk.persisted.Store(c.Compacted && persisted)
+		ts.Chunks = append(ts.Chunks, chunk)
+	}
+	if len(ts.Chunks) == 0 {
+		ts.Chunks = []*Chunk{{Points: make([]Point, 0, ts.maxChunkSize())}}
+	}
+	ts.OutOfOrder = s.OutOfOrder
+	ts.Tombstones = s.Tombstones
+
+	// Rollup tiers are matched by resolution, so tiers added since the
+	// snapshot start empty and removed ones are dropped.
+	for _, r := range ts.Rollups {
+		for _, sr := range s.Rollups {
+			if sr.Resolution == r.Resolution {
+				r.Aggregates = sr.Aggregates
+				r.pending = sr.Pending
+				r.rolledUp = sr.RolledUp
+			}
+		}
+	}
+
+	if s.LSN > db.lsn.Load() {
+		db.lsn.Store(s.LSN)
+	}
+
+	ts.recountChunks()
+
+	key := GenerateKey(s.Metric, s.Tags)
+	shard := db.GetShard(key)
+	shard.Lock()
+	if old := shard.Series[key]; old != nil {
+		old.Lock()
+		old.removed = true
+		old.recountChunks()
+		old.Unlock()
+	}
+	shard.Series[key] = ts
+	db.index.add(key, ts)
+	shard.Unlock()
+}
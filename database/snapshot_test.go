@@ -0,0 +1,111 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot_Restore(t *testing.T) {
+	opts := &Options{
+		WALDir:      t.TempDir(),
+		SnapshotDir: t.TempDir(),
+		Rollups:     []RollupTier{{Resolution: time.Hour}},
+	}
+	db, err := Open(opts)
+	require.NoError(t, err)
+
+	metric := "test_metric"
+	tags := map[string]string{"tag1": "value1"}
+	require.NoError(t, db.AddTimeSeries(metric, tags))
+	for i := range ChunkSize + 5 {
+		require.NoError(t, db.AddPoint(metric, tags, int64(i*60), float64(i)))
+	}
+	key := GenerateKey(metric, tags)
+	db.GetShard(key).CompactChunks()
+
+	path, manifest, err := db.Snapshot()
+	require.NoError(t, err)
+	assert.Equal(t, 1, manifest.Series)
+	assert.Equal(t, db.lsn.Load(), manifest.LSN)
+
+	// Writes after the snapshot only live in the WAL
+	require.NoError(t, db.AddTimeSeries("later_metric", nil))
+	require.NoError(t, db.AddPoint("later_metric", nil, 1, 1.0))
+	require.NoError(t, db.AddPoint(metric, tags, int64((ChunkSize+5)*60), 0.0))
+
+	want, err := db.GetRangeStep(metric, tags, 0, 1<<40, 3600, AggregateSum)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	// The snapshot made the segments before it redundant
+	segments, err := walSegments(opts.WALDir)
+	require.NoError(t, err)
+	assert.Len(t, segments, 1)
+
+	latest, err := LatestSnapshot(opts.SnapshotDir)
+	require.NoError(t, err)
+	assert.Equal(t, path, latest)
+
+	db, err = Open(opts)
+	require.NoError(t, err)
+	defer db.Close()
+
+	got, err := db.GetRangeStep(metric, tags, 0, 1<<40, 3600, AggregateSum)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	ts := db.GetShard(key).Series[key]
+	assert.True(t, ts.Chunks[0].Compacted)
+	assert.Len(t, ts.Rollups[0].Aggregates, ChunkSize/60)
+
+	points, err := db.GetRange("later_metric", nil, 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []Point{{1, 1.0}}, points)
+}
+
+func TestSnapshotContext_Cancelled(t *testing.T) {
+	opts := &Options{WALDir: t.TempDir(), SnapshotDir: t.TempDir()}
+	db, err := Open(opts)
+	require.NoError(t, err)
+	require.NoError(t, db.AddTimeSeries("test_metric", nil))
+	require.NoError(t, db.AddPoint("test_metric", nil, 1, 1.0))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = db.SnapshotContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	entries, err := os.ReadDir(opts.SnapshotDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+	require.NoError(t, db.Close())
+
+	// The WAL still holds the write
+	db, err = Open(opts)
+	require.NoError(t, err)
+	points, err := db.GetRange("test_metric", nil, 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []Point{{1, 1.0}}, points)
+}
+
+func TestLoadSnapshot_Checksum(t *testing.T) {
+	opts := &Options{SnapshotDir: t.TempDir()}
+	db := NewDatabaseWithOptions(opts)
+	require.NoError(t, db.AddTimeSeries("test_metric", nil))
+
+	path, manifest, err := db.Snapshot()
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(filepath.Join(path, manifest.Files[0].Name), []byte("garbage"), 0o644))
+	for _, f := range manifest.Files[1:] {
+		require.NoError(t, os.Remove(filepath.Join(path, f.Name)))
+	}
+
+	err = NewDatabase().LoadSnapshot(path)
+	assert.ErrorContains(t, err, "checksum mismatch")
+}
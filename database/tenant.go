@@ -0,0 +1,142 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/sinnlos-ffff/tsdb-lite/metrics"
+	"golang.org/x/time/rate"
+)
+
+// TenantLabel is the reserved tag naming the tenant a series belongs to.
+// Series without it belong to the default tenant, whose ID is empty.
+const TenantLabel = "__tenant__"
+
+// TenantLimits are the quotas of one tenant. Zero means no limit.
+type TenantLimits struct {
+	MaxSeries int
+	// IngestRate is how many points per second the tenant may write, with
+	// bursts of up to IngestBurst points, or IngestRate rounded up if zero.
+	// A single write of more points than that is always rejected.
+	IngestRate  float64
+	IngestBurst int
+	// Retention caps how long the tenant's points are kept, whatever
+	// Retention and MetricRetention say.
+	Retention time.Duration
+}
+
+// TenantOf returns the tenant a series with the given tags belongs to.
+func TenantOf(tags map[string]string) string {
+	return tags[TenantLabel]
+}
+
+func (l TenantLimits) burst() int {
+	if l.IngestBurst > 0 {
+		return l.IngestBurst
+	}
+	return max(int(math.Ceil(l.IngestRate)), 1)
+}
+
+// tenantName returns the tenant's ID, or "default" for the default tenant.
+func tenantName(tenant string) string {
+	if tenant == "" {
+		return "default"
+	}
+	return tenant
+}
+
+// tenantMetricLabel returns the value of the tenant label on metrics. Only
+// the default tenant and configured ones get their own, so clients cannot
+// add label values at will; all others share "other".
+func (db *Database) tenantMetricLabel(tenant string) string {
+	if _, ok := db.live.Load().Tenants[tenant]; ok || tenant == "" {
+		return tenantName(tenant)
+	}
+	return "other"
+}
+
+// countIngest counts n points written to a series, unless it is one of the
+// database's own.
+func (db *Database) countIngest(metric string, tags map[string]string, n int) {
+	if !isSelfMetric(metric) {
+		metrics.IngestTotal.WithLabelValues(db.tenantMetricLabel(TenantOf(tags))).Add(float64(n))
+	}
+}
+
+// tenantLimiters hands out the ingestion rate limiter of each tenant that
+// has an IngestRate.
+type tenantLimiters struct {
+	sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+// tenantLimits returns the quotas of a tenant. Once any tenants are
+// configured, they and the default tenant are the only ones that may
+// write, so a client cannot shed its quotas by naming another tenant; the
+// others fail with ErrUnknownTenant.
+func (db *Database) tenantLimits(tenant string) (TenantLimits, error) {
+	tenants := db.live.Load().Tenants
+	l, ok := tenants[tenant]
+	if !ok && tenant != "" && len(tenants) > 0 {
+		return TenantLimits{}, fmt.Errorf("%w: %q", ErrUnknownTenant, tenant)
+	}
+	return l, nil
+}
+
+// allowIngest takes n points out of the tenant's ingestion budget, or fails
+// with ErrLimitExceeded if that would exceed its rate.
+func (db *Database) allowIngest(tenant string, n int) error {
+	l, err := db.tenantLimits(tenant)
+	if err != nil || l.IngestRate <= 0 {
+		return err
+	}
+	if n > l.burst() {
+		return fmt.Errorf("%w: %d points at once, more than the burst of %d for tenant %q", ErrLimitExceeded, n, l.burst(), tenantName(tenant))
+	}
+
+	db.limiters.Lock()
+	if db.limiters.limiters == nil {
+		db.limiters.limiters = make(map[string]*rate.Limiter)
+	}
+	limiter := db.limiters.limiters[tenant]
+	if limiter == nil {
+		limiter = rate.NewLimiter(rate.Limit(l.IngestRate), l.burst())
+		db.limiters.limiters[tenant] = limiter
+	}
+	db.limiters.Unlock()
+
+	if !limiter.AllowN(time.Now(), n) {
+		metrics.TenantRateLimitedTotal.WithLabelValues(db.tenantMetricLabel(tenant)).Add(float64(n))
+		return fmt.Errorf("%w: ingestion rate of %g points/s for tenant %q", ErrLimitExceeded, l.IngestRate, tenantName(tenant))
+	}
+	return nil
+}
+
+// tenantSeries returns how many series the tenant has, leaving out the
+// database's own series in the default tenant. Must be called with idx
+// locked.
+func (idx *index) tenantSeries(tenant string) int {
+	if tenant != "" {
+		return len(idx.tags[TenantLabel][tenant])
+	}
+	return len(idx.defaultTenant) - idx.selfSeries
+}
+
+// seriesPerTenant returns how many series each tenant has, keyed by
+// tenantMetricLabel.
+func (db *Database) seriesPerTenant() map[string]int {
+	db.index.RLock()
+	defer db.index.RUnlock()
+
+	counts := map[string]int{tenantName(""): len(db.index.defaultTenant)}
+	other := len(db.index.series) - len(db.index.defaultTenant)
+	for tenant := range db.live.Load().Tenants {
+		n := len(db.index.tags[TenantLabel][tenant])
+		counts[tenant] = n
+		other -= n
+	}
+	counts["other"] = other
+	return counts
+}
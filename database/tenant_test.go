@@ -0,0 +1,141 @@
+package database
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sinnlos-ffff/tsdb-lite/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenant_SeriesLimit(t *testing.T) {
+	db := NewDatabaseWithOptions(&Options{Tenants: map[string]TenantLimits{
+		"team_a": {MaxSeries: 2},
+		"team_b": {},
+	}})
+
+	for i := range 2 {
+		require.NoError(t, db.AddTimeSeries("cpu_usage", map[string]string{TenantLabel: "team_a", "host": fmt.Sprint(i)}))
+	}
+	err := db.AddTimeSeries("cpu_usage", map[string]string{TenantLabel: "team_a", "host": "2"})
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+
+	// Other tenants are unaffected
+	require.NoError(t, db.AddTimeSeries("cpu_usage", map[string]string{TenantLabel: "team_b", "host": "2"}))
+	require.NoError(t, db.AddTimeSeries("cpu_usage", map[string]string{"host": "2"}))
+	assert.Equal(t, map[string]int{"default": 1, "team_a": 2, "team_b": 1, "other": 0}, db.seriesPerTenant())
+}
+
+func TestTenant_Unknown(t *testing.T) {
+	db := NewDatabaseWithOptions(&Options{Tenants: map[string]TenantLimits{
+		"team_a": {MaxSeries: 1},
+	}})
+
+	// Naming an unconfigured tenant does not get around team_a's quota
+	err := db.AddTimeSeries("cpu_usage", map[string]string{TenantLabel: "team_x"})
+	assert.ErrorIs(t, err, ErrUnknownTenant)
+	assert.ErrorIs(t, db.SetMetadata("team_x", "cpu_usage", Metadata{}), ErrUnknownTenant)
+	require.NoError(t, db.AddTimeSeries("cpu_usage", nil))
+
+	// Without any configured tenants, every tenant is accepted, and they
+	// share one metric label
+	db = NewDatabase()
+	require.NoError(t, db.AddTimeSeries("cpu_usage", map[string]string{TenantLabel: "team_x"}))
+	assert.Equal(t, "other", db.tenantMetricLabel("team_x"))
+	assert.Equal(t, map[string]int{"default": 0, "other": 1}, db.seriesPerTenant())
+}
+
+func TestTenant_PerMetricLimit(t *testing.T) {
+	db := NewDatabaseWithOptions(&Options{
+		Limits:  Limits{MaxSeriesPerMetric: 1, MaxTagsPerSeries: 1},
+		Tenants: map[string]TenantLimits{"team_a": {}, "team_b": {}},
+	})
+
+	// Each tenant gets the full limit, and the tenant label does not count
+	// as a tag
+	for _, tenant := range []string{"team_a", "team_b"} {
+		require.NoError(t, db.AddTimeSeries("cpu_usage", map[string]string{TenantLabel: tenant, "host": "a"}))
+		err := db.AddTimeSeries("cpu_usage", map[string]string{TenantLabel: tenant, "host": "b"})
+		assert.ErrorIs(t, err, ErrLimitExceeded)
+	}
+	require.NoError(t, db.AddTimeSeries("cpu_usage", map[string]string{"host": "a"}))
+}
+
+func TestTenant_IngestRate(t *testing.T) {
+	db := NewDatabaseWithOptions(&Options{Tenants: map[string]TenantLimits{
+		"team_a": {IngestRate: 1, IngestBurst: 3},
+	}})
+	tags := map[string]string{TenantLabel: "team_a"}
+	require.NoError(t, db.AddTimeSeries("cpu_usage", tags))
+
+	for i := range 3 {
+		require.NoError(t, db.AddPoint("cpu_usage", tags, int64(i), 1.0))
+	}
+	rejected := testutil.ToFloat64(metrics.TenantRateLimitedTotal.WithLabelValues("team_a"))
+	assert.ErrorIs(t, db.AddPoint("cpu_usage", tags, 3, 1.0), ErrLimitExceeded)
+	assert.Equal(t, rejected+1, testutil.ToFloat64(metrics.TenantRateLimitedTotal.WithLabelValues("team_a")))
+
+	// Without a burst, a batch of up to a second's worth of points fits
+	db.Reload(ReloadableOptions{Tenants: map[string]TenantLimits{"team_a": {IngestRate: 100}}})
+	batch := make([]Point, 100)
+	for i := range batch {
+		batch[i] = Point{Timestamp: int64(-1000 + i), Value: 1.0}
+	}
+	require.NoError(t, db.Backfill("cpu_usage", tags, batch))
+	assert.ErrorIs(t, db.Backfill("cpu_usage", tags, append(batch, Point{Timestamp: -1, Value: 1})), ErrLimitExceeded)
+
+	// The default tenant has no rate limit
+	require.NoError(t, db.AddTimeSeries("cpu_usage", nil))
+	for i := range 10 {
+		require.NoError(t, db.AddPoint("cpu_usage", nil, int64(i), 1.0))
+	}
+}
+
+func TestTenant_Retention(t *testing.T) {
+	db := NewDatabaseWithOptions(&Options{
+		Retention: time.Hour,
+		Tenants: map[string]TenantLimits{
+			"team_a": {Retention: time.Minute},
+		},
+	})
+	now := time.Unix(100_000, 0)
+	teamA := map[string]string{TenantLabel: "team_a"}
+
+	for _, tags := range []map[string]string{teamA, nil} {
+		require.NoError(t, db.AddTimeSeries("cpu_usage", tags))
+		// A full chunk of points ten minutes old, then a recent head
+		for i := range ChunkSize {
+			require.NoError(t, db.AddPoint("cpu_usage", tags, now.Add(-10*time.Minute).Unix()+int64(i)-ChunkSize, 1.0))
+		}
+		require.NoError(t, db.AddPoint("cpu_usage", tags, now.Unix(), 2.0))
+	}
+	assert.Equal(t, time.Minute, db.seriesRetention("cpu_usage", teamA))
+	assert.Equal(t, time.Hour, db.seriesRetention("cpu_usage", nil))
+
+	db.ExpireChunks(now)
+
+	points, err := db.GetRange("cpu_usage", teamA, 0, now.Unix())
+	require.NoError(t, err)
+	assert.Len(t, points, 1)
+	points, err = db.GetRange("cpu_usage", nil, 0, now.Unix())
+	require.NoError(t, err)
+	assert.Len(t, points, ChunkSize+1)
+}
+
+func TestTenant_Isolation(t *testing.T) {
+	db := NewDatabase()
+	require.NoError(t, db.AddTimeSeries("cpu_usage", map[string]string{TenantLabel: "team_a", "host": "a"}))
+	require.NoError(t, db.AddTimeSeries("cpu_usage", map[string]string{"host": "b"}))
+
+	// An empty tenant label selects the default tenant only
+	series := db.Select("cpu_usage", map[string]string{TenantLabel: ""})
+	require.Len(t, series, 1)
+	assert.Equal(t, "b", series[0].Tags["host"])
+
+	series = db.Select("cpu_usage", map[string]string{TenantLabel: "team_a"})
+	require.Len(t, series, 1)
+	assert.Equal(t, "a", series[0].Tags["host"])
+}
@@ -0,0 +1,60 @@
+package database
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/sinnlos-ffff/tsdb-lite/database")
+
+// spanError records err on span and returns it.
+func spanError(span trace.Span, err error) error {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// lookupContext is lookup, traced so that waiting for the shard lock shows.
+func (db *Database) lookupContext(ctx context.Context, metric string, tags map[string]string) (*TimeSeries, error) {
+	_, span := tracer.Start(ctx, "lookup series")
+	defer span.End()
+	ts, err := db.lookup(metric, tags)
+	return ts, spanError(span, err)
+}
+
+// commitContext waits for a WAL record, tracing how long that took.
+func commitContext(ctx context.Context, commit func() error) error {
+	_, span := tracer.Start(ctx, "WAL.Log")
+	defer span.End()
+	return spanError(span, commit())
+}
+
+// rlockContext read-locks ts, tracing how long that took.
+func (ts *TimeSeries) rlockContext(ctx context.Context) {
+	_, span := tracer.Start(ctx, "lock series")
+	ts.RLock()
+	span.End()
+}
+
+// lockContext locks ts, tracing how long that took.
+func (ts *TimeSeries) lockContext(ctx context.Context) {
+	_, span := tracer.Start(ctx, "lock series")
+	ts.Lock()
+	span.End()
+}
+
+// pointsContext is points, traced with how many points it scanned. Must be
+// called with ts read-locked.
+func (ts *TimeSeries) pointsContext(ctx context.Context, start, end int64) ([]Point, int) {
+	_, span := tracer.Start(ctx, "scan chunks", trace.WithAttributes(attribute.Int("chunks", len(ts.Chunks))))
+	defer span.End()
+	points, scanned := ts.points(start, end)
+	span.SetAttributes(attribute.Int("points_scanned", scanned), attribute.Int("points_returned", len(points)))
+	return points, scanned
+}
@@ -2,43 +2,621 @@ package database
 
 import (
 	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"math"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
+
+	"github.com/sinnlos-ffff/tsdb-lite/metrics"
 )
 
+type walRecordType byte
+
+const (
+	walSeries walRecordType = iota + 1
+	walPoint
+	walDelete
+	walBackfill
+	walMetadata
+)
+
+// walRecord is one logged write. Every record carries the LSN (log sequence
+// number) it was assigned, which replay compares with the LSN a series was
+// restored at to skip writes that are already in a snapshot.
+type walRecord struct {
+	LSN    uint64
+	Type   walRecordType
+	Metric string
+	Tags   map[string]string
+	// Point records use Timestamp and Value; delete records use Start and
+	// End; backfill records use Points; metadata records use Metadata.
+	Timestamp int64
+	Value     float64
+	Start     int64
+	End       int64
+	Points    []Point
+	Metadata  Metadata
+}
+
 type walReq struct {
-	p    Point
-	done chan error
+	rec walRecord
+	// rotate, when set, closes the current segment and starts the next one
+	// instead of logging rec.
+	rotate bool
+	done   chan error
 }
 
 type WAL struct {
-	ch        chan walReq
+	dir string
+	ch  chan walReq
+	// slots holds one token per request queued or about to be, so that a
+	// caller holding a reserved slot can queue without blocking.
+	slots     chan struct{}
 	w         *bufio.Writer
 	f         *os.File
+	segment   uint64
 	flushTick *time.Ticker // e.g., 20ms
+	closing   chan chan error
+	// stopped is closed once the loop has exited.
+	stopped chan struct{}
+	err     error
+	// failed holds err for callers outside the loop, once it is set.
+	failed atomic.Pointer[error]
+	// sync holds back acknowledgements until the records are synced.
+	sync bool
+}
+
+var walCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+const (
+	walSegmentPrefix = "wal-"
+	maxRecordSize    = 16 << 20
+	walQueueSize     = 1024
+)
+
+func walSegmentName(index uint64) string {
+	return fmt.Sprintf("%s%020d", walSegmentPrefix, index)
+}
+
+// OpenWAL starts a new segment in dir, after any existing ones. Records are
+// synced to disk every flushInterval; if sync is set, Log waits for that.
+func OpenWAL(dir string, flushInterval time.Duration, sync bool) (*WAL, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	segments, err := walSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	var next uint64
+	if len(segments) > 0 {
+		next = segments[len(segments)-1].index + 1
+	}
+
+	w := &WAL{
+		dir:       dir,
+		ch:        make(chan walReq, walQueueSize),
+		slots:     make(chan struct{}, walQueueSize),
+		flushTick: time.NewTicker(flushInterval),
+		closing:   make(chan chan error),
+		stopped:   make(chan struct{}),
+		sync:      sync,
+	}
+	if err := w.openSegment(next); err != nil {
+		return nil, err
+	}
+
+	go w.loop()
+	return w, nil
+}
+
+func (w *WAL) openSegment(index uint64) error {
+	f, err := os.OpenFile(filepath.Join(w.dir, walSegmentName(index)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	w.f = f
+	w.w = bufio.NewWriterSize(f, 1<<20)
+	w.segment = index
+	return nil
+}
+
+// Log queues a record and returns once it has been buffered for the next
+// group commit, or once that commit is done if the WAL syncs.
+func (w *WAL) Log(rec walRecord) error {
+	commit, err := w.Append(rec)
+	if err != nil {
+		return err
+	}
+	return commit()
+}
+
+// Append queues a record without waiting for it. Records land in the WAL in
+// the order Append is called. The returned commit waits for what Log waits
+// for.
+func (w *WAL) Append(rec walRecord) (commit func() error, err error) {
+	if err := w.failed.Load(); err != nil {
+		return nil, *err
+	}
+	if err := w.Reserve(); err != nil {
+		return nil, err
+	}
+	return w.AppendReserved(rec)
+}
+
+// Reserve waits for room in the queue and holds it for the next
+// AppendReserved, so that can be called under locks without blocking on a
+// full queue.
+func (w *WAL) Reserve() error {
+	if err := w.failed.Load(); err != nil {
+		return *err
+	}
+	select {
+	case <-w.stopped:
+		return ErrClosed
+	default:
+	}
+	select {
+	case w.slots <- struct{}{}:
+		return nil
+	case <-w.stopped:
+		return ErrClosed
+	}
+}
+
+// AppendReserved is Append for a caller that has reserved a slot with
+// Reserve, which it uses up whether or not it succeeds.
+func (w *WAL) AppendReserved(rec walRecord) (commit func() error, err error) {
+	if err := w.failed.Load(); err != nil {
+		<-w.slots
+		return nil, *err
+	}
+	done := make(chan error, 1)
+	if err := w.send(walReq{rec: rec, done: done}); err != nil {
+		return nil, err
+	}
+	return func() error { return w.wait(done) }, nil
+}
+
+// Rotate syncs the current segment and starts the next one, returning its
+// index. Every record appended before Rotate is called lands in an earlier
+// segment.
+func (w *WAL) Rotate() (uint64, error) {
+	if err := w.Reserve(); err != nil {
+		return 0, err
+	}
+	done := make(chan error, 1)
+	if err := w.send(walReq{rotate: true, done: done}); err != nil {
+		return 0, err
+	}
+	if err := w.wait(done); err != nil {
+		return 0, err
+	}
+	return w.segment, nil
+}
+
+// Close flushes and syncs everything logged so far and stops the WAL.
+// Requests it did not get to, and any made afterwards, fail with ErrClosed.
+func (w *WAL) Close() error {
+	done := make(chan error)
+	select {
+	case w.closing <- done:
+		return <-done
+	case <-w.stopped:
+		return ErrClosed
+	}
+}
+
+func (w *WAL) send(req walReq) error {
+	select {
+	case <-w.stopped:
+		return ErrClosed
+	default:
+	}
+	select {
+	case w.ch <- req:
+		return nil
+	case <-w.stopped:
+		return ErrClosed
+	}
+}
+
+// wait returns the answer to a request, or ErrClosed if the loop exited
+// without answering it.
+func (w *WAL) wait(done chan error) error {
+	select {
+	case err := <-done:
+		return err
+	case <-w.stopped:
+		select {
+		case err := <-done:
+			return err
+		default:
+			return ErrClosed
+		}
+	}
+}
+
+// fail records the first error the loop runs into, which every later
+// request then fails with. Must only be called by the loop.
+func (w *WAL) fail(err error) {
+	if err != nil && w.err == nil {
+		w.err = err
+		w.failed.Store(&err)
+	}
+}
+
+// QueueDepth returns how many requests are waiting for the WAL.
+func (w *WAL) QueueDepth() int {
+	return len(w.ch)
+}
+
+// Truncate removes the segments before the one with the given index.
+func (w *WAL) Truncate(before uint64) error {
+	segments, err := walSegments(w.dir)
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		if segment.index >= before {
+			break
+		}
+		if err := os.Remove(segment.path); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (w *WAL) loop() {
 	buf := make([]byte, 0, 1<<20)
+	// unsynced holds the requests waiting for the next sync.
+	var unsynced []chan error
+	flush := func() error {
+		err := func() error {
+			if len(buf) > 0 {
+				if _, err := w.w.Write(buf); err != nil {
+					return err
+				}
+				buf = buf[:0]
+			}
+			if err := w.w.Flush(); err != nil {
+				return err
+			}
+			start := time.Now()
+			defer func() { metrics.WALFsyncDuration.Observe(time.Since(start).Seconds()) }()
+			return w.f.Sync() // group commit
+		}()
+		for _, done := range unsynced {
+			done <- err
+		}
+		unsynced = unsynced[:0]
+		return err
+	}
+
 	for {
 		select {
 		case req := <-w.ch:
-			// TODO: Implement encoding logic
-			buf = appendRecord(buf, req.p)
+			<-w.slots
+			if w.err != nil {
+				req.done <- w.err
+				continue
+			}
+
+			if req.rotate {
+				w.fail(flush())
+				if w.err == nil {
+					w.fail(w.f.Close())
+				}
+				if w.err == nil {
+					w.fail(w.openSegment(w.segment + 1))
+				}
+				req.done <- w.err
+				continue
+			}
+
+			n := len(buf)
+			buf = appendRecord(buf, req.rec)
+			metrics.WALBytesTotal.Add(float64(len(buf) - n))
 			if len(buf) > 64<<10 { // 64KiB batch
-				w.w.Write(buf)
+				if _, err := w.w.Write(buf); err != nil {
+					w.fail(err)
+				}
 				buf = buf[:0]
 			}
 
-			// TODO: Consider moving ACK after w.f.Sync()
-			req.done <- nil
+			if w.sync && w.err == nil {
+				unsynced = append(unsynced, req.done)
+				continue
+			}
+			req.done <- w.err
 		case <-w.flushTick.C:
-			if len(buf) > 0 {
-				w.w.Write(buf)
-				buf = buf[:0]
+			if w.err == nil {
+				w.fail(flush())
+			}
+		case done := <-w.closing:
+			w.flushTick.Stop()
+			err := w.err
+			if err == nil {
+				err = flush()
 			}
-			w.w.Flush()
-			w.f.Sync() // group commit
+			if cerr := w.f.Close(); err == nil {
+				err = cerr
+			}
+			close(w.stopped)
+			done <- err
+			return
+		}
+	}
+}
+
+// appendRecord frames rec as a little-endian uint32 payload length, a
+// CRC-32C of the payload and the payload itself.
+func appendRecord(buf []byte, rec walRecord) []byte {
+	start := len(buf)
+	buf = append(buf, make([]byte, 8)...)
+
+	buf = binary.AppendUvarint(buf, rec.LSN)
+	buf = append(buf, byte(rec.Type))
+	buf = appendString(buf, rec.Metric)
+	buf = binary.AppendUvarint(buf, uint64(len(rec.Tags)))
+	for k, v := range rec.Tags {
+		buf = appendString(buf, k)
+		buf = appendString(buf, v)
+	}
+	switch rec.Type {
+	case walPoint:
+		buf = binary.AppendVarint(buf, rec.Timestamp)
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(rec.Value))
+	case walDelete:
+		buf = binary.AppendVarint(buf, rec.Start)
+		buf = binary.AppendVarint(buf, rec.End)
+	case walBackfill:
+		buf = binary.AppendUvarint(buf, uint64(len(rec.Points)))
+		for _, p := range rec.Points {
+			buf = binary.AppendVarint(buf, p.Timestamp)
+			buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(p.Value))
+		}
+	case walMetadata:
+		buf = binary.AppendUvarint(buf, uint64(rec.Metadata.Type))
+		buf = appendString(buf, rec.Metadata.Unit)
+		buf = appendString(buf, rec.Metadata.Help)
+	}
+
+	payload := buf[start+8:]
+	binary.LittleEndian.PutUint32(buf[start:], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[start+4:], crc32.Checksum(payload, walCRCTable))
+	return buf
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+var errCorruptRecord = errors.New("corrupt WAL record")
+
+func decodeRecord(payload []byte) (walRecord, error) {
+	var rec walRecord
+	r := &byteReader{b: payload}
+
+	rec.LSN = r.uvarint()
+	rec.Type = walRecordType(r.byte())
+	rec.Metric = r.string()
+	if n := r.uvarint(); n > 0 && r.err == nil {
+		rec.Tags = make(map[string]string, min(n, uint64(len(payload))))
+		for i := uint64(0); i < n && r.err == nil; i++ {
+			k := r.string()
+			rec.Tags[k] = r.string()
+		}
+	}
+	switch rec.Type {
+	case walSeries:
+	case walPoint:
+		rec.Timestamp = r.varint()
+		rec.Value = math.Float64frombits(r.uint64())
+	case walDelete:
+		rec.Start = r.varint()
+		rec.End = r.varint()
+	case walBackfill:
+		n := r.uvarint()
+		rec.Points = make([]Point, 0, min(n, uint64(len(payload))))
+		for i := uint64(0); i < n && r.err == nil; i++ {
+			rec.Points = append(rec.Points, Point{Timestamp: r.varint(), Value: math.Float64frombits(r.uint64())})
+		}
+	case walMetadata:
+		rec.Metadata.Type = MetricType(r.uvarint())
+		rec.Metadata.Unit = r.string()
+		rec.Metadata.Help = r.string()
+	default:
+		return rec, errCorruptRecord
+	}
+
+	if r.err != nil || len(r.b) != 0 {
+		return rec, errCorruptRecord
+	}
+	return rec, nil
+}
+
+type byteReader struct {
+	b   []byte
+	err error
+}
+
+func (r *byteReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.err = errCorruptRecord
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *byteReader) varint() int64 {
+	v, n := binary.Varint(r.b)
+	if n <= 0 {
+		r.err = errCorruptRecord
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *byteReader) byte() byte {
+	if len(r.b) < 1 {
+		r.err = errCorruptRecord
+		return 0
+	}
+	v := r.b[0]
+	r.b = r.b[1:]
+	return v
+}
+
+func (r *byteReader) uint64() uint64 {
+	if len(r.b) < 8 {
+		r.err = errCorruptRecord
+		return 0
+	}
+	v := binary.LittleEndian.Uint64(r.b)
+	r.b = r.b[8:]
+	return v
+}
+
+func (r *byteReader) string() string {
+	n := r.uvarint()
+	if r.err != nil || uint64(len(r.b)) < n {
+		r.err = errCorruptRecord
+		return ""
+	}
+	v := string(r.b[:n])
+	r.b = r.b[n:]
+	return v
+}
+
+type walSegment struct {
+	path  string
+	index uint64
+}
+
+func walSegments(dir string) ([]walSegment, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []walSegment
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, walSegmentPrefix) {
+			continue
+		}
+		index, err := strconv.ParseUint(strings.TrimPrefix(name, walSegmentPrefix), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, walSegment{path: filepath.Join(dir, name), index: index})
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].index < segments[j].index
+	})
+	return segments, nil
+}
+
+// replayWAL calls fn for every record in dir, oldest segment first. A torn
+// record at the end of a segment, which can only come from a write that was
+// never acknowledged as synced, ends it and is logged. A corrupt record with
+// more data after it is returned as an error, as skipping it would lose
+// acknowledged writes. Once ctx is done, replay stops with ctx.Err().
+func replayWAL(ctx context.Context, dir string, fn func(walRecord)) error {
+	segments, err := walSegments(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, segment := range segments {
+		if err := replaySegment(ctx, segment.path, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func replaySegment(ctx context.Context, path string, fn func(walRecord)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReaderSize(f, 1<<20)
+	header := make([]byte, 8)
+	var payload []byte
+	var offset int64
+	torn := func(reason string) error {
+		log.Printf("WAL: ignoring torn record at offset %d of %s: %s\n", offset, path, reason)
+		return nil
+	}
+	corrupt := func(reason string) error {
+		return fmt.Errorf("%w at offset %d of %s: %s", errCorruptRecord, offset, path, reason)
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(r, header); err == io.EOF {
+			return nil
+		} else if err == io.ErrUnexpectedEOF {
+			return torn("short header")
+		} else if err != nil {
+			return err
+		}
+		n := binary.LittleEndian.Uint32(header)
+		end := offset + 8 + int64(n)
+		if end > info.Size() {
+			return torn("short payload")
+		}
+		if n > maxRecordSize {
+			return corrupt(fmt.Sprintf("record of %d bytes", n))
+		}
+		if cap(payload) < int(n) {
+			payload = make([]byte, n)
+		}
+		payload = payload[:n]
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return err
+		}
+		if crc32.Checksum(payload, walCRCTable) != binary.LittleEndian.Uint32(header[4:]) {
+			if end == info.Size() {
+				return torn("checksum mismatch")
+			}
+			return corrupt("checksum mismatch")
+		}
+		rec, err := decodeRecord(payload)
+		if err != nil {
+			return corrupt("undecodable payload")
 		}
+		fn(rec)
+		offset = end
 	}
 }
@@ -0,0 +1,171 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendRecord(t *testing.T) {
+	records := []walRecord{
+		{LSN: 1, Type: walSeries, Metric: "cpu", Tags: map[string]string{"host": "a"}},
+		{LSN: 2, Type: walPoint, Metric: "cpu", Tags: map[string]string{"host": "a"}, Timestamp: -5, Value: 1.5},
+		{LSN: 3, Type: walDelete, Metric: "cpu", Start: 10, End: 20},
+		{LSN: 4, Type: walBackfill, Metric: "cpu", Points: []Point{{-1, 0.5}, {7, 2}}},
+		{LSN: 5, Type: walMetadata, Metric: "cpu", Metadata: Metadata{Type: MetricTypeGauge, Unit: "percent", Help: "CPU usage"}},
+	}
+
+	for _, rec := range records {
+		buf := appendRecord(nil, rec)
+		decoded, err := decodeRecord(buf[8:])
+		require.NoError(t, err)
+		assert.Equal(t, rec, decoded)
+	}
+}
+
+func TestReplayWAL_TornTail(t *testing.T) {
+	dir := t.TempDir()
+	w, err := OpenWAL(dir, time.Millisecond, false)
+	require.NoError(t, err)
+
+	for i := range 10 {
+		require.NoError(t, w.Log(walRecord{LSN: uint64(i + 1), Type: walPoint, Metric: "cpu", Timestamp: int64(i)}))
+	}
+	require.NoError(t, w.Close())
+
+	// Chop the last record in half, as a crash mid-write would
+	path := filepath.Join(dir, walSegmentName(0))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path, info.Size()-3))
+
+	var replayed []uint64
+	require.NoError(t, replayWAL(context.Background(), dir, func(rec walRecord) {
+		replayed = append(replayed, rec.LSN)
+	}))
+	assert.Equal(t, []uint64{1, 2, 3, 4, 5, 6, 7, 8, 9}, replayed)
+}
+
+func TestWAL_Sync(t *testing.T) {
+	dir := t.TempDir()
+	w, err := OpenWAL(dir, 10*time.Millisecond, true)
+	require.NoError(t, err)
+	defer w.Close()
+
+	// The record is on disk by the time Log returns
+	require.NoError(t, w.Log(walRecord{LSN: 1, Type: walPoint, Metric: "cpu"}))
+	info, err := os.Stat(filepath.Join(dir, walSegmentName(0)))
+	require.NoError(t, err)
+	assert.Positive(t, info.Size())
+}
+
+func TestWAL_Reserve(t *testing.T) {
+	w, err := OpenWAL(t.TempDir(), time.Millisecond, false)
+	require.NoError(t, err)
+	defer w.Close()
+
+	for range walQueueSize {
+		require.NoError(t, w.Reserve())
+	}
+
+	// Every slot is held, so the next reservation waits for one to be used
+	reserved := make(chan error, 1)
+	go func() { reserved <- w.Reserve() }()
+	select {
+	case <-reserved:
+		t.Fatal("Reserve returned with the queue full")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	commit, err := w.AppendReserved(walRecord{LSN: 1, Type: walPoint, Metric: "cpu"})
+	require.NoError(t, err)
+	require.NoError(t, commit())
+	require.NoError(t, <-reserved)
+}
+
+func TestOpen_ReplaysWAL(t *testing.T) {
+	opts := &Options{WALDir: t.TempDir(), OutOfOrderWindow: 100}
+	db, err := Open(opts)
+	require.NoError(t, err)
+
+	metric := "test_metric"
+	tags := map[string]string{"tag1": "value1"}
+	require.NoError(t, db.AddTimeSeries(metric, tags))
+	require.NoError(t, db.AddPoint(metric, tags, 1000, 1.0))
+	require.NoError(t, db.AddPoint(metric, tags, 1100, 3.0))
+	require.NoError(t, db.AddPoint(metric, tags, 1050, 2.0))
+	_, err = db.Delete(metric, tags, 1100, 1100)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	db, err = Open(opts)
+	require.NoError(t, err)
+	defer db.Close()
+
+	points, err := db.GetRange(metric, tags, 0, 2000)
+	require.NoError(t, err)
+	assert.Equal(t, []Point{{1000, 1.0}, {1050, 2.0}}, points)
+}
+
+func TestOpenContext_Cancelled(t *testing.T) {
+	opts := &Options{WALDir: t.TempDir()}
+	db, err := Open(opts)
+	require.NoError(t, err)
+	require.NoError(t, db.AddTimeSeries("test_metric", nil))
+	require.NoError(t, db.Close())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = OpenContext(ctx, opts)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestCloseContext_Deadline(t *testing.T) {
+	db, err := Open(&Options{WALDir: t.TempDir()})
+	require.NoError(t, err)
+
+	// Background work that never stops does not hold up Close past ctx
+	db.background.Add(1)
+	defer db.background.Done()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, db.CloseContext(ctx), context.DeadlineExceeded)
+}
+
+func TestReplayWAL_Corrupt(t *testing.T) {
+	dir := t.TempDir()
+	w, err := OpenWAL(dir, time.Millisecond, false)
+	require.NoError(t, err)
+	for i := range 10 {
+		require.NoError(t, w.Log(walRecord{LSN: uint64(i + 1), Type: walPoint, Metric: "cpu", Timestamp: int64(i)}))
+	}
+	require.NoError(t, w.Close())
+
+	// Flip a byte in the middle of the first record, which has
+	// acknowledged records after it
+	path := filepath.Join(dir, walSegmentName(0))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	data[10] ^= 0xff
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+
+	err = replayWAL(context.Background(), dir, func(walRecord) {})
+	assert.ErrorIs(t, err, errCorruptRecord)
+	assert.ErrorContains(t, err, "offset 0")
+}
+
+func TestWAL_Closed(t *testing.T) {
+	w, err := OpenWAL(t.TempDir(), time.Millisecond, true)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	assert.ErrorIs(t, w.Log(walRecord{LSN: 1, Type: walPoint, Metric: "cpu"}), ErrClosed)
+	_, err = w.Rotate()
+	assert.ErrorIs(t, err, ErrClosed)
+	assert.ErrorIs(t, w.Close(), ErrClosed)
+}
@@ -0,0 +1,204 @@
+// Package dump reads and writes samples in the CSV and newline-delimited
+// JSON formats used by Export and Import.
+//
+// CSV files start with a metric,tags,timestamp,value header and carry the
+// tags as a JSON object. NDJSON files hold one object per line with the same
+// four fields. In both, non-finite values are written as NaN, +Inf and -Inf.
+package dump
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+type Sample struct {
+	Metric    string            `json:"metric"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	Timestamp int64             `json:"timestamp"`
+	Value     float64           `json:"value"`
+}
+
+type Format int
+
+const (
+	CSV Format = iota
+	NDJSON
+)
+
+// ParseFormat parses "csv" or "ndjson".
+func ParseFormat(s string) (Format, error) {
+	switch s {
+	case "csv":
+		return CSV, nil
+	case "ndjson", "jsonl":
+		return NDJSON, nil
+	}
+	return 0, fmt.Errorf("unknown format %q", s)
+}
+
+var csvHeader = []string{"metric", "tags", "timestamp", "value"}
+
+type Writer struct {
+	format Format
+	csv    *csv.Writer
+	w      *bufio.Writer
+	header bool
+}
+
+func NewWriter(w io.Writer, format Format) *Writer {
+	bw := bufio.NewWriter(w)
+	return &Writer{format: format, csv: csv.NewWriter(bw), w: bw}
+}
+
+func (w *Writer) Write(s *Sample) error {
+	if w.format == NDJSON {
+		b, err := json.Marshal(ndjsonSample{s.Metric, s.Tags, s.Timestamp, jsonValue(s.Value)})
+		if err != nil {
+			return err
+		}
+		w.w.Write(b)
+		return w.w.WriteByte('\n')
+	}
+
+	if !w.header {
+		if err := w.csv.Write(csvHeader); err != nil {
+			return err
+		}
+		w.header = true
+	}
+	tags, err := json.Marshal(s.Tags)
+	if err != nil {
+		return err
+	}
+	if s.Tags == nil {
+		tags = []byte("{}")
+	}
+	return w.csv.Write([]string{
+		s.Metric,
+		string(tags),
+		strconv.FormatInt(s.Timestamp, 10),
+		strconv.FormatFloat(s.Value, 'g', -1, 64),
+	})
+}
+
+// Flush writes any buffered samples to the underlying writer.
+func (w *Writer) Flush() error {
+	w.csv.Flush()
+	if err := w.csv.Error(); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
+
+type Reader struct {
+	format Format
+	csv    *csv.Reader
+	json   *json.Decoder
+	header bool
+	line   int
+}
+
+func NewReader(r io.Reader, format Format) *Reader {
+	if format == NDJSON {
+		return &Reader{format: format, json: json.NewDecoder(r)}
+	}
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = len(csvHeader)
+	return &Reader{format: format, csv: cr}
+}
+
+// Read returns the next sample, or io.EOF after the last one.
+func (r *Reader) Read() (*Sample, error) {
+	r.line++
+	if r.format == NDJSON {
+		var s ndjsonSample
+		if err := r.json.Decode(&s); err != nil {
+			if err == io.EOF {
+				return nil, err
+			}
+			return nil, fmt.Errorf("record %d: %w", r.line, err)
+		}
+		if s.Metric == "" {
+			return nil, fmt.Errorf("record %d: missing metric", r.line)
+		}
+		return &Sample{Metric: s.Metric, Tags: s.Tags, Timestamp: s.Timestamp, Value: float64(s.Value)}, nil
+	}
+
+	if !r.header {
+		record, err := r.csv.Read()
+		if err != nil {
+			return nil, err
+		}
+		if record[0] != csvHeader[0] {
+			return nil, fmt.Errorf("line 1: expected header %v, got %v", csvHeader, record)
+		}
+		r.header = true
+		r.line++
+	}
+
+	record, err := r.csv.Read()
+	if err != nil {
+		return nil, err
+	}
+	s := &Sample{Metric: record[0]}
+	if s.Metric == "" {
+		return nil, fmt.Errorf("line %d: missing metric", r.line)
+	}
+	if err := json.Unmarshal([]byte(record[1]), &s.Tags); err != nil {
+		return nil, fmt.Errorf("line %d: tags: %w", r.line, err)
+	}
+	if len(s.Tags) == 0 {
+		s.Tags = nil
+	}
+	if s.Timestamp, err = strconv.ParseInt(record[2], 10, 64); err != nil {
+		return nil, fmt.Errorf("line %d: timestamp: %w", r.line, err)
+	}
+	if s.Value, err = strconv.ParseFloat(record[3], 64); err != nil {
+		return nil, fmt.Errorf("line %d: value: %w", r.line, err)
+	}
+	return s, nil
+}
+
+type ndjsonSample struct {
+	Metric    string            `json:"metric"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	Timestamp int64             `json:"timestamp"`
+	Value     jsonValue         `json:"value"`
+}
+
+// jsonValue is a float64 that JSON-encodes non-finite values as strings,
+// since JSON numbers cannot represent them.
+type jsonValue float64
+
+func (v jsonValue) MarshalJSON() ([]byte, error) {
+	f := float64(v)
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return json.Marshal(strconv.FormatFloat(f, 'g', -1, 64))
+	}
+	return json.Marshal(f)
+}
+
+func (v *jsonValue) UnmarshalJSON(b []byte) error {
+	var f float64
+	if err := json.Unmarshal(b, &f); err == nil {
+		*v = jsonValue(f)
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.New("value must be a number or NaN, +Inf or -Inf")
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	*v = jsonValue(f)
+	return nil
+}
@@ -0,0 +1,61 @@
+package dump
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundTrip(t *testing.T) {
+	samples := []*Sample{
+		{Metric: "cpu_usage", Tags: map[string]string{"host": "a,b", "region": `"eu"`}, Timestamp: 1, Value: 0.5},
+		{Metric: "cpu_usage", Timestamp: 2, Value: math.Inf(-1)},
+		{Metric: "mem_usage", Tags: map[string]string{"host": "c"}, Timestamp: -3, Value: 1e300},
+	}
+
+	for _, format := range []Format{CSV, NDJSON} {
+		var buf bytes.Buffer
+		w := NewWriter(&buf, format)
+		for _, s := range samples {
+			require.NoError(t, w.Write(s))
+		}
+		require.NoError(t, w.Flush())
+
+		r := NewReader(&buf, format)
+		for _, want := range samples {
+			got, err := r.Read()
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+		}
+		_, err := r.Read()
+		assert.Equal(t, io.EOF, err)
+	}
+}
+
+func TestRead_NaN(t *testing.T) {
+	r := NewReader(bytes.NewBufferString(`{"metric":"m","timestamp":1,"value":"NaN"}`+"\n"), NDJSON)
+	s, err := r.Read()
+	require.NoError(t, err)
+	assert.True(t, math.IsNaN(s.Value))
+
+	r = NewReader(bytes.NewBufferString("metric,tags,timestamp,value\nm,{},1,NaN\n"), CSV)
+	s, err = r.Read()
+	require.NoError(t, err)
+	assert.True(t, math.IsNaN(s.Value))
+}
+
+func TestRead_Invalid(t *testing.T) {
+	r := NewReader(bytes.NewBufferString("metric,tags,timestamp,value\nm,{},1,1\nm,{},x,1\n"), CSV)
+	_, err := r.Read()
+	require.NoError(t, err)
+	_, err = r.Read()
+	assert.ErrorContains(t, err, "line 3: timestamp")
+
+	r = NewReader(bytes.NewBufferString(`{"timestamp":1,"value":1}`), NDJSON)
+	_, err = r.Read()
+	assert.ErrorContains(t, err, "record 1: missing metric")
+}
@@ -1,23 +1,134 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
-	"time"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/sinnlos-ffff/tsdb-lite/config"
 	"github.com/sinnlos-ffff/tsdb-lite/metrics"
 	"github.com/sinnlos-ffff/tsdb-lite/server"
+	"github.com/sinnlos-ffff/tsdb-lite/tracing"
 )
 
 func main() {
-	s := server.NewServer(&server.Config{
-		CompactionInterval: time.Minute,
-	})
+	cfg, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v\n", err)
+	}
+
+	stopTracing, err := tracing.Setup(context.Background(), cfg.TracingOptions())
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v\n", err)
+	}
+
+	s, err := server.New(cfg.Server())
+	if err != nil {
+		log.Fatalf("Failed to create server: %v\n", err)
+	}
+
+	// Scrapes during Open see every metric but the database's state.
+	metrics.InitMetrics(s.Collector())
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+
+	// Health checks are answered while the database is restored.
+	served := make(chan error, 2)
+	log.Printf("Starting gRPC server on %s\n", cfg.Listen)
+	go func() {
+		if err := s.ListenAndServe(cfg.Listen); err != nil {
+			served <- fmt.Errorf("serving gRPC on %s: %w", cfg.Listen, err)
+		}
+	}()
+	if cfg.HTTPListen != "" {
+		log.Printf("Starting HTTP server on %s\n", cfg.HTTPListen)
+		go func() {
+			if err := s.ListenAndServeHTTP(cfg.HTTPListen); err != nil {
+				served <- fmt.Errorf("serving HTTP on %s: %w", cfg.HTTPListen, err)
+			}
+		}()
+	}
+
+	// Signals are handled while the WAL is replayed, which can take long.
+	openCtx, cancelOpen := context.WithCancel(context.Background())
+	defer cancelOpen()
+	opened := make(chan error, 1)
+	go func() { opened <- s.OpenContext(openCtx) }()
+
+	var sig os.Signal
+	opening := true
+	select {
+	case err := <-opened:
+		if err != nil {
+			log.Fatalf("Failed to open database: %v\n", err)
+		}
+		log.Println("Ready")
+		opening = false
+
+		go reloadOnHangup(s, cfg)
+
+		select {
+		case err := <-served:
+			log.Fatalf("Failed to start server: %v\n", err)
+		case sig = <-stop:
+		}
+	case err := <-served:
+		log.Fatalf("Failed to start server: %v\n", err)
+	case sig = <-stop:
+	}
+	log.Printf("Received %s, shutting down\n", sig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Shutdown.Timeout)
+	defer cancel()
+	if opening {
+		cancelOpen()
+		select {
+		case <-opened:
+		case <-ctx.Done():
+			log.Fatalf("Unclean shutdown: database still opening after %s\n", cfg.Shutdown.Timeout)
+		}
+	}
+	err = s.Shutdown(ctx)
+	if terr := stopTracing(ctx); terr != nil {
+		log.Printf("Failed to flush traces: %v\n", terr)
+	}
+	if err != nil {
+		log.Fatalf("Unclean shutdown: %v\n", err)
+	}
+	log.Println("Shut down")
+}
 
-	metrics.InitMetrics()
+// reloadOnHangup reloads the configuration on every SIGHUP and applies what
+// can be changed without a restart. An invalid configuration is ignored.
+func reloadOnHangup(s *server.Server, running *config.Config) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
 
-	port := ":8080"
-	log.Printf("Starting gRPC server on %s\n", port)
-	if err := s.ListenAndServe(port); err != nil {
-		log.Fatalf("Failed to start server on %s: %v\n", port, err)
+	for range hangup {
+		cfg, err := config.Load(os.Args[1:], os.Getenv)
+		if err != nil {
+			log.Printf("Not reloading invalid configuration: %v\n", err)
+			continue
+		}
+		if err := s.Reload(cfg.Server()); err != nil {
+			log.Printf("Failed to reload configuration: %v\n", err)
+			continue
+		}
+		for _, section := range cfg.RestartRequired(running) {
+			log.Printf("Changes to %s take effect after a restart\n", section)
+		}
+		// Compare the next reload with this one, so changes that need a
+		// restart are reported once.
+		running = cfg
+		log.Println("Reloaded configuration")
 	}
 }
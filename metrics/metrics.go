@@ -5,10 +5,10 @@ import (
 )
 
 var (
-	IngestTotal = prometheus.NewCounter(prometheus.CounterOpts{
+	IngestTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "tsdb_ingest_total",
 		Help: "Total number of points ingested",
-	})
+	}, []string{"tenant"})
 
 	IngestLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
 		Name:    "tsdb_ingest_latency_seconds",
@@ -20,8 +20,130 @@ var (
 		Name: "tsdb_chunks_compacted_total",
 		Help: "Total compacted chunks",
 	})
+
+	DuplicateSamplesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "tsdb_duplicate_samples_discarded_total",
+		Help: "Total samples discarded because their timestamp was already stored",
+	})
+
+	RetentionPointsReclaimedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "tsdb_retention_points_reclaimed_total",
+		Help: "Total points dropped by retention",
+	})
+
+	RetentionBytesReclaimedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "tsdb_retention_bytes_reclaimed_total",
+		Help: "Total bytes of point storage dropped by retention",
+	})
+
+	RetentionSeriesRemovedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "tsdb_retention_series_removed_total",
+		Help: "Total series removed after retention dropped all of their points",
+	})
+
+	Shards = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "tsdb_shards",
+		Help: "Current number of shards",
+	})
+
+	Series = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "tsdb_series",
+		Help: "Current number of series",
+	})
+
+	SeriesLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tsdb_series_limit",
+		Help: "Configured series cardinality limits, 0 if unlimited",
+	}, []string{"limit"})
+
+	SeriesLimitRejectionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tsdb_series_limit_rejections_total",
+		Help: "Total series creations rejected by a cardinality limit",
+	}, []string{"limit"})
+
+	TenantRateLimitedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tsdb_tenant_rate_limited_points_total",
+		Help: "Total points rejected by a tenant's ingestion rate limit",
+	}, []string{"tenant"})
+
+	RejectedWritesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tsdb_rejected_writes_total",
+		Help: "Total write requests rejected, by reason",
+	}, []string{"reason"})
+
+	RPCDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tsdb_rpc_duration_seconds",
+		Help:    "Latency of gRPC calls, for streams until the stream ends",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "code"})
+
+	QueryPointsScanned = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tsdb_query_points_scanned",
+		Help:    "Stored points and aggregates read per query",
+		Buckets: prometheus.ExponentialBuckets(1, 10, 8),
+	}, []string{"query"})
+
+	CompactionDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "tsdb_compaction_duration_seconds",
+		Help:    "Duration of compaction passes over every shard",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	WALBytesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "tsdb_wal_bytes_written_total",
+		Help: "Total bytes of records written to the WAL",
+	})
+
+	WALFsyncDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "tsdb_wal_fsync_duration_seconds",
+		Help:    "Latency of WAL fsyncs",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+	})
+
+	// The descriptors below are collected from the database when scraped.
+
+	ShardSeriesDesc = prometheus.NewDesc("tsdb_shard_series",
+		"Current number of series per shard", []string{"shard"}, nil)
+
+	ChunksDesc = prometheus.NewDesc("tsdb_chunks",
+		"Current number of chunks: open ones still taking points, compacted ones sealed since the last snapshot, and persisted ones held by a snapshot",
+		[]string{"state"}, nil)
+
+	WALQueueDepthDesc = prometheus.NewDesc("tsdb_wal_queue_depth",
+		"Current number of writes waiting for the WAL", nil, nil)
+
+	TenantSeriesDesc = prometheus.NewDesc("tsdb_tenant_series",
+		"Current number of series of the default tenant, each configured tenant and all others",
+		[]string{"tenant"}, nil)
+
+	MaxMetricSeriesDesc = prometheus.NewDesc("tsdb_max_metric_series",
+		"Current number of series of the metric name with the most series in any one tenant",
+		nil, nil)
 )
 
-func InitMetrics() {
-	prometheus.MustRegister(IngestTotal, IngestLatency, CompactedChunksTotal)
+// InitMetrics registers the metrics, and any collectors given, with the
+// default registry, which already has the Go runtime and process
+// collectors.
+func InitMetrics(collectors ...prometheus.Collector) {
+	prometheus.MustRegister(collectors...)
+	prometheus.MustRegister(
+		IngestTotal,
+		IngestLatency,
+		CompactedChunksTotal,
+		DuplicateSamplesTotal,
+		RetentionPointsReclaimedTotal,
+		RetentionBytesReclaimedTotal,
+		RetentionSeriesRemovedTotal,
+		Shards,
+		Series,
+		SeriesLimit,
+		SeriesLimitRejectionsTotal,
+		TenantRateLimitedTotal,
+		RejectedWritesTotal,
+		RPCDuration,
+		QueryPointsScanned,
+		CompactionDuration,
+		WALBytesTotal,
+		WALFsyncDuration,
+	)
 }
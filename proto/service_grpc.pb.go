@@ -25,6 +25,19 @@ type TsdbLiteClient interface {
 	CreateTimeSeries(ctx context.Context, in *CreateTimeSeriesRequest, opts ...grpc.CallOption) (*CreateTimeSeriesResponse, error)
 	AddPoint(ctx context.Context, in *AddPointRequest, opts ...grpc.CallOption) (*AddPointResponse, error)
 	GetRange(ctx context.Context, in *GetRangeRequest, opts ...grpc.CallOption) (*GetRangeResponse, error)
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+	Reshard(ctx context.Context, in *ReshardRequest, opts ...grpc.CallOption) (*ReshardResponse, error)
+	Snapshot(ctx context.Context, in *SnapshotRequest, opts ...grpc.CallOption) (*SnapshotResponse, error)
+	Backup(ctx context.Context, in *BackupRequest, opts ...grpc.CallOption) (*BackupResponse, error)
+	Export(ctx context.Context, in *ExportRequest, opts ...grpc.CallOption) (TsdbLite_ExportClient, error)
+	Import(ctx context.Context, opts ...grpc.CallOption) (TsdbLite_ImportClient, error)
+	Backfill(ctx context.Context, opts ...grpc.CallOption) (TsdbLite_BackfillClient, error)
+	SetMetadata(ctx context.Context, in *SetMetadataRequest, opts ...grpc.CallOption) (*SetMetadataResponse, error)
+	GetMetadata(ctx context.Context, in *GetMetadataRequest, opts ...grpc.CallOption) (*GetMetadataResponse, error)
+	LabelNames(ctx context.Context, in *LabelNamesRequest, opts ...grpc.CallOption) (*LabelNamesResponse, error)
+	LabelValues(ctx context.Context, in *LabelValuesRequest, opts ...grpc.CallOption) (*LabelValuesResponse, error)
+	Series(ctx context.Context, in *SeriesRequest, opts ...grpc.CallOption) (*SeriesResponse, error)
+	Cardinality(ctx context.Context, in *CardinalityRequest, opts ...grpc.CallOption) (*CardinalityResponse, error)
 }
 
 type tsdbLiteClient struct {
@@ -62,6 +75,193 @@ func (c *tsdbLiteClient) GetRange(ctx context.Context, in *GetRangeRequest, opts
 	return out, nil
 }
 
+func (c *tsdbLiteClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error) {
+	out := new(DeleteResponse)
+	err := c.cc.Invoke(ctx, "/proto.TsdbLite/Delete", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tsdbLiteClient) Reshard(ctx context.Context, in *ReshardRequest, opts ...grpc.CallOption) (*ReshardResponse, error) {
+	out := new(ReshardResponse)
+	err := c.cc.Invoke(ctx, "/proto.TsdbLite/Reshard", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tsdbLiteClient) Snapshot(ctx context.Context, in *SnapshotRequest, opts ...grpc.CallOption) (*SnapshotResponse, error) {
+	out := new(SnapshotResponse)
+	err := c.cc.Invoke(ctx, "/proto.TsdbLite/Snapshot", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tsdbLiteClient) Backup(ctx context.Context, in *BackupRequest, opts ...grpc.CallOption) (*BackupResponse, error) {
+	out := new(BackupResponse)
+	err := c.cc.Invoke(ctx, "/proto.TsdbLite/Backup", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tsdbLiteClient) Export(ctx context.Context, in *ExportRequest, opts ...grpc.CallOption) (TsdbLite_ExportClient, error) {
+	stream, err := c.cc.NewStream(ctx, &TsdbLite_ServiceDesc.Streams[0], "/proto.TsdbLite/Export", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &tsdbLiteExportClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type TsdbLite_ExportClient interface {
+	Recv() (*ExportResponse, error)
+	grpc.ClientStream
+}
+
+type tsdbLiteExportClient struct {
+	grpc.ClientStream
+}
+
+func (x *tsdbLiteExportClient) Recv() (*ExportResponse, error) {
+	m := new(ExportResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *tsdbLiteClient) Import(ctx context.Context, opts ...grpc.CallOption) (TsdbLite_ImportClient, error) {
+	stream, err := c.cc.NewStream(ctx, &TsdbLite_ServiceDesc.Streams[1], "/proto.TsdbLite/Import", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &tsdbLiteImportClient{stream}
+	return x, nil
+}
+
+type TsdbLite_ImportClient interface {
+	Send(*ImportRequest) error
+	Recv() (*ImportResponse, error)
+	grpc.ClientStream
+}
+
+type tsdbLiteImportClient struct {
+	grpc.ClientStream
+}
+
+func (x *tsdbLiteImportClient) Send(m *ImportRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *tsdbLiteImportClient) Recv() (*ImportResponse, error) {
+	m := new(ImportResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *tsdbLiteClient) Backfill(ctx context.Context, opts ...grpc.CallOption) (TsdbLite_BackfillClient, error) {
+	stream, err := c.cc.NewStream(ctx, &TsdbLite_ServiceDesc.Streams[2], "/proto.TsdbLite/Backfill", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &tsdbLiteBackfillClient{stream}
+	return x, nil
+}
+
+type TsdbLite_BackfillClient interface {
+	Send(*BackfillRequest) error
+	CloseAndRecv() (*BackfillResponse, error)
+	grpc.ClientStream
+}
+
+type tsdbLiteBackfillClient struct {
+	grpc.ClientStream
+}
+
+func (x *tsdbLiteBackfillClient) Send(m *BackfillRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *tsdbLiteBackfillClient) CloseAndRecv() (*BackfillResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(BackfillResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *tsdbLiteClient) SetMetadata(ctx context.Context, in *SetMetadataRequest, opts ...grpc.CallOption) (*SetMetadataResponse, error) {
+	out := new(SetMetadataResponse)
+	err := c.cc.Invoke(ctx, "/proto.TsdbLite/SetMetadata", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tsdbLiteClient) GetMetadata(ctx context.Context, in *GetMetadataRequest, opts ...grpc.CallOption) (*GetMetadataResponse, error) {
+	out := new(GetMetadataResponse)
+	err := c.cc.Invoke(ctx, "/proto.TsdbLite/GetMetadata", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tsdbLiteClient) LabelNames(ctx context.Context, in *LabelNamesRequest, opts ...grpc.CallOption) (*LabelNamesResponse, error) {
+	out := new(LabelNamesResponse)
+	err := c.cc.Invoke(ctx, "/proto.TsdbLite/LabelNames", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tsdbLiteClient) LabelValues(ctx context.Context, in *LabelValuesRequest, opts ...grpc.CallOption) (*LabelValuesResponse, error) {
+	out := new(LabelValuesResponse)
+	err := c.cc.Invoke(ctx, "/proto.TsdbLite/LabelValues", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tsdbLiteClient) Series(ctx context.Context, in *SeriesRequest, opts ...grpc.CallOption) (*SeriesResponse, error) {
+	out := new(SeriesResponse)
+	err := c.cc.Invoke(ctx, "/proto.TsdbLite/Series", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tsdbLiteClient) Cardinality(ctx context.Context, in *CardinalityRequest, opts ...grpc.CallOption) (*CardinalityResponse, error) {
+	out := new(CardinalityResponse)
+	err := c.cc.Invoke(ctx, "/proto.TsdbLite/Cardinality", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // TsdbLiteServer is the server API for TsdbLite service.
 // All implementations must embed UnimplementedTsdbLiteServer
 // for forward compatibility
@@ -69,6 +269,19 @@ type TsdbLiteServer interface {
 	CreateTimeSeries(context.Context, *CreateTimeSeriesRequest) (*CreateTimeSeriesResponse, error)
 	AddPoint(context.Context, *AddPointRequest) (*AddPointResponse, error)
 	GetRange(context.Context, *GetRangeRequest) (*GetRangeResponse, error)
+	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	Reshard(context.Context, *ReshardRequest) (*ReshardResponse, error)
+	Snapshot(context.Context, *SnapshotRequest) (*SnapshotResponse, error)
+	Backup(context.Context, *BackupRequest) (*BackupResponse, error)
+	Export(*ExportRequest, TsdbLite_ExportServer) error
+	Import(TsdbLite_ImportServer) error
+	Backfill(TsdbLite_BackfillServer) error
+	SetMetadata(context.Context, *SetMetadataRequest) (*SetMetadataResponse, error)
+	GetMetadata(context.Context, *GetMetadataRequest) (*GetMetadataResponse, error)
+	LabelNames(context.Context, *LabelNamesRequest) (*LabelNamesResponse, error)
+	LabelValues(context.Context, *LabelValuesRequest) (*LabelValuesResponse, error)
+	Series(context.Context, *SeriesRequest) (*SeriesResponse, error)
+	Cardinality(context.Context, *CardinalityRequest) (*CardinalityResponse, error)
 	mustEmbedUnimplementedTsdbLiteServer()
 }
 
@@ -85,6 +298,45 @@ func (UnimplementedTsdbLiteServer) AddPoint(context.Context, *AddPointRequest) (
 func (UnimplementedTsdbLiteServer) GetRange(context.Context, *GetRangeRequest) (*GetRangeResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetRange not implemented")
 }
+func (UnimplementedTsdbLiteServer) Delete(context.Context, *DeleteRequest) (*DeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedTsdbLiteServer) Reshard(context.Context, *ReshardRequest) (*ReshardResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Reshard not implemented")
+}
+func (UnimplementedTsdbLiteServer) Snapshot(context.Context, *SnapshotRequest) (*SnapshotResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Snapshot not implemented")
+}
+func (UnimplementedTsdbLiteServer) Backup(context.Context, *BackupRequest) (*BackupResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Backup not implemented")
+}
+func (UnimplementedTsdbLiteServer) Export(*ExportRequest, TsdbLite_ExportServer) error {
+	return status.Errorf(codes.Unimplemented, "method Export not implemented")
+}
+func (UnimplementedTsdbLiteServer) Import(TsdbLite_ImportServer) error {
+	return status.Errorf(codes.Unimplemented, "method Import not implemented")
+}
+func (UnimplementedTsdbLiteServer) Backfill(TsdbLite_BackfillServer) error {
+	return status.Errorf(codes.Unimplemented, "method Backfill not implemented")
+}
+func (UnimplementedTsdbLiteServer) SetMetadata(context.Context, *SetMetadataRequest) (*SetMetadataResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetMetadata not implemented")
+}
+func (UnimplementedTsdbLiteServer) GetMetadata(context.Context, *GetMetadataRequest) (*GetMetadataResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMetadata not implemented")
+}
+func (UnimplementedTsdbLiteServer) LabelNames(context.Context, *LabelNamesRequest) (*LabelNamesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LabelNames not implemented")
+}
+func (UnimplementedTsdbLiteServer) LabelValues(context.Context, *LabelValuesRequest) (*LabelValuesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LabelValues not implemented")
+}
+func (UnimplementedTsdbLiteServer) Series(context.Context, *SeriesRequest) (*SeriesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Series not implemented")
+}
+func (UnimplementedTsdbLiteServer) Cardinality(context.Context, *CardinalityRequest) (*CardinalityResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Cardinality not implemented")
+}
 func (UnimplementedTsdbLiteServer) mustEmbedUnimplementedTsdbLiteServer() {}
 
 // UnsafeTsdbLiteServer may be embedded to opt out of forward compatibility for this service.
@@ -152,6 +404,259 @@ func _TsdbLite_GetRange_Handler(srv interface{}, ctx context.Context, dec func(i
 	return interceptor(ctx, in, info, handler)
 }
 
+func _TsdbLite_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TsdbLiteServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.TsdbLite/Delete",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TsdbLiteServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TsdbLite_Reshard_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReshardRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TsdbLiteServer).Reshard(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.TsdbLite/Reshard",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TsdbLiteServer).Reshard(ctx, req.(*ReshardRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TsdbLite_Snapshot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SnapshotRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TsdbLiteServer).Snapshot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.TsdbLite/Snapshot",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TsdbLiteServer).Snapshot(ctx, req.(*SnapshotRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TsdbLite_Backup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BackupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TsdbLiteServer).Backup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.TsdbLite/Backup",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TsdbLiteServer).Backup(ctx, req.(*BackupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TsdbLite_Export_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExportRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TsdbLiteServer).Export(m, &tsdbLiteExportServer{stream})
+}
+
+type TsdbLite_ExportServer interface {
+	Send(*ExportResponse) error
+	grpc.ServerStream
+}
+
+type tsdbLiteExportServer struct {
+	grpc.ServerStream
+}
+
+func (x *tsdbLiteExportServer) Send(m *ExportResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _TsdbLite_Import_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(TsdbLiteServer).Import(&tsdbLiteImportServer{stream})
+}
+
+type TsdbLite_ImportServer interface {
+	Send(*ImportResponse) error
+	Recv() (*ImportRequest, error)
+	grpc.ServerStream
+}
+
+type tsdbLiteImportServer struct {
+	grpc.ServerStream
+}
+
+func (x *tsdbLiteImportServer) Send(m *ImportResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *tsdbLiteImportServer) Recv() (*ImportRequest, error) {
+	m := new(ImportRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _TsdbLite_Backfill_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(TsdbLiteServer).Backfill(&tsdbLiteBackfillServer{stream})
+}
+
+type TsdbLite_BackfillServer interface {
+	SendAndClose(*BackfillResponse) error
+	Recv() (*BackfillRequest, error)
+	grpc.ServerStream
+}
+
+type tsdbLiteBackfillServer struct {
+	grpc.ServerStream
+}
+
+func (x *tsdbLiteBackfillServer) SendAndClose(m *BackfillResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *tsdbLiteBackfillServer) Recv() (*BackfillRequest, error) {
+	m := new(BackfillRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _TsdbLite_SetMetadata_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetMetadataRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TsdbLiteServer).SetMetadata(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.TsdbLite/SetMetadata",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TsdbLiteServer).SetMetadata(ctx, req.(*SetMetadataRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TsdbLite_GetMetadata_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetMetadataRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TsdbLiteServer).GetMetadata(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.TsdbLite/GetMetadata",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TsdbLiteServer).GetMetadata(ctx, req.(*GetMetadataRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TsdbLite_LabelNames_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LabelNamesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TsdbLiteServer).LabelNames(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.TsdbLite/LabelNames",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TsdbLiteServer).LabelNames(ctx, req.(*LabelNamesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TsdbLite_LabelValues_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LabelValuesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TsdbLiteServer).LabelValues(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.TsdbLite/LabelValues",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TsdbLiteServer).LabelValues(ctx, req.(*LabelValuesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TsdbLite_Series_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SeriesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TsdbLiteServer).Series(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.TsdbLite/Series",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TsdbLiteServer).Series(ctx, req.(*SeriesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TsdbLite_Cardinality_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CardinalityRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TsdbLiteServer).Cardinality(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.TsdbLite/Cardinality",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TsdbLiteServer).Cardinality(ctx, req.(*CardinalityRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // TsdbLite_ServiceDesc is the grpc.ServiceDesc for TsdbLite service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -171,7 +676,64 @@ var TsdbLite_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetRange",
 			Handler:    _TsdbLite_GetRange_Handler,
 		},
+		{
+			MethodName: "Delete",
+			Handler:    _TsdbLite_Delete_Handler,
+		},
+		{
+			MethodName: "Reshard",
+			Handler:    _TsdbLite_Reshard_Handler,
+		},
+		{
+			MethodName: "Snapshot",
+			Handler:    _TsdbLite_Snapshot_Handler,
+		},
+		{
+			MethodName: "Backup",
+			Handler:    _TsdbLite_Backup_Handler,
+		},
+		{
+			MethodName: "SetMetadata",
+			Handler:    _TsdbLite_SetMetadata_Handler,
+		},
+		{
+			MethodName: "GetMetadata",
+			Handler:    _TsdbLite_GetMetadata_Handler,
+		},
+		{
+			MethodName: "LabelNames",
+			Handler:    _TsdbLite_LabelNames_Handler,
+		},
+		{
+			MethodName: "LabelValues",
+			Handler:    _TsdbLite_LabelValues_Handler,
+		},
+		{
+			MethodName: "Series",
+			Handler:    _TsdbLite_Series_Handler,
+		},
+		{
+			MethodName: "Cardinality",
+			Handler:    _TsdbLite_Cardinality_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Export",
+			Handler:       _TsdbLite_Export_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Import",
+			Handler:       _TsdbLite_Import_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Backfill",
+			Handler:       _TsdbLite_Backfill_Handler,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/service.proto",
 }
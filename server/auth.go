@@ -0,0 +1,386 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	pb "github.com/sinnlos-ffff/tsdb-lite/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Role is what an identity may do. Each role includes the ones below it.
+type Role int
+
+const (
+	RoleNone Role = iota
+	RoleRead
+	RoleWrite
+	RoleAdmin
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleRead:
+		return "read"
+	case RoleWrite:
+		return "write"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return "none"
+	}
+}
+
+func (r *Role) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "read":
+		*r = RoleRead
+	case "write":
+		*r = RoleWrite
+	case "admin":
+		*r = RoleAdmin
+	default:
+		return fmt.Errorf("unknown role %q", text)
+	}
+	return nil
+}
+
+func (r Role) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// Identity is a client allowed to use the server, authenticated either by
+// a bearer token or by the common name of a verified client certificate.
+type Identity struct {
+	Name       string `json:"name"`
+	Token      string `json:"token,omitempty"`
+	CommonName string `json:"common_name,omitempty"`
+	Role       Role   `json:"role"`
+	// Tenant, if set, is the only tenant the identity may act on behalf of,
+	// and is used when requests do not name one.
+	Tenant string `json:"tenant,omitempty"`
+	// MetricPrefixes, if set, restrict requests that name metrics to
+	// metrics starting with one of them. Requests that would reach every
+	// metric are refused.
+	MetricPrefixes []string `json:"metric_prefixes,omitempty"`
+	// IngestRate, if set, replaces Config.ClientRateLimit for the identity.
+	IngestRate *RateLimit `json:"ingest_rate,omitempty"`
+}
+
+type identityKey struct{}
+
+// identityFrom returns the identity a request authenticated as, if any.
+func identityFrom(ctx context.Context) *Identity {
+	id, _ := ctx.Value(identityKey{}).(*Identity)
+	return id
+}
+
+// allowsMetric reports whether the identity may name metric.
+func (id *Identity) allowsMetric(metric string) bool {
+	if len(id.MetricPrefixes) == 0 {
+		return true
+	}
+	if metric == "" {
+		return false
+	}
+	for _, prefix := range id.MetricPrefixes {
+		if strings.HasPrefix(metric, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator checks the credentials of requests against a fixed set of
+// identities.
+type Authenticator struct {
+	tokens      map[[sha256.Size]byte]*Identity
+	commonNames map[string]*Identity
+}
+
+// LoadAuthenticator reads identities from a JSON file of the form
+// {"identities": [{"name": "ingest", "token": "...", "role": "write"}]}.
+func LoadAuthenticator(path string) (*Authenticator, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Identities []Identity `json:"identities"`
+	}
+	if err := json.Unmarshal(b, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return NewAuthenticator(file.Identities)
+}
+
+func NewAuthenticator(identities []Identity) (*Authenticator, error) {
+	a := &Authenticator{
+		tokens:      make(map[[sha256.Size]byte]*Identity),
+		commonNames: make(map[string]*Identity),
+	}
+	for i := range identities {
+		id := &identities[i]
+		if id.Role == RoleNone {
+			return nil, fmt.Errorf("identity %q has no role", id.Name)
+		}
+		if id.Token == "" && id.CommonName == "" {
+			return nil, fmt.Errorf("identity %q has neither a token nor a common name", id.Name)
+		}
+		if id.Token != "" {
+			// Tokens are looked up by hash, so lookups take the same time
+			// however much of a guess is right.
+			sum := sha256.Sum256([]byte(id.Token))
+			if _, ok := a.tokens[sum]; ok {
+				return nil, fmt.Errorf("identity %q reuses a token", id.Name)
+			}
+			a.tokens[sum] = id
+		}
+		if id.CommonName != "" {
+			if _, ok := a.commonNames[id.CommonName]; ok {
+				return nil, fmt.Errorf("identity %q reuses common name %q", id.Name, id.CommonName)
+			}
+			a.commonNames[id.CommonName] = id
+		}
+	}
+	return a, nil
+}
+
+// identify returns the identity of the bearer token in authorization, if
+// any, or else of the verified client certificate chains.
+func (a *Authenticator) identify(authorization string, chains [][]*x509.Certificate) (*Identity, error) {
+	if authorization != "" {
+		token, ok := strings.CutPrefix(authorization, "Bearer ")
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "authorization is not a bearer token")
+		}
+		id, ok := a.tokens[sha256.Sum256([]byte(token))]
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "unknown token")
+		}
+		return id, nil
+	}
+
+	for _, chain := range chains {
+		if len(chain) == 0 {
+			continue
+		}
+		if id, ok := a.commonNames[chain[0].Subject.CommonName]; ok {
+			return id, nil
+		}
+	}
+	if len(chains) > 0 {
+		return nil, status.Error(codes.Unauthenticated, "unknown client certificate")
+	}
+	return nil, status.Error(codes.Unauthenticated, "missing credentials")
+}
+
+// authenticate returns the identity of a gRPC request and its context
+// with the identity's tenant applied.
+func (a *Authenticator) authenticate(ctx context.Context) (*Identity, context.Context, error) {
+	var authorization string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			authorization = values[0]
+		}
+	}
+	var chains [][]*x509.Certificate
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			chains = info.State.VerifiedChains
+		}
+	}
+
+	id, err := a.identify(authorization, chains)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, err = id.applyTenant(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return id, context.WithValue(ctx, identityKey{}, id), nil
+}
+
+// applyTenant binds ctx to the identity's tenant, if it has one.
+func (id *Identity) applyTenant(ctx context.Context) (context.Context, error) {
+	if id.Tenant == "" {
+		return ctx, nil
+	}
+	if tenant := tenantFrom(ctx); tenant != "" && tenant != id.Tenant {
+		return nil, status.Errorf(codes.PermissionDenied, "%s may not act on behalf of tenant %q", id.Name, tenant)
+	}
+	return WithTenant(ctx, id.Tenant), nil
+}
+
+// methodRoles is the role each RPC requires. RPCs not listed require
+// RoleAdmin, except health checks, which need no authentication.
+var methodRoles = map[string]Role{
+	"/proto.TsdbLite/GetRange":         RoleRead,
+	"/proto.TsdbLite/Export":           RoleRead,
+	"/proto.TsdbLite/GetMetadata":      RoleRead,
+	"/proto.TsdbLite/LabelNames":       RoleRead,
+	"/proto.TsdbLite/LabelValues":      RoleRead,
+	"/proto.TsdbLite/Series":           RoleRead,
+	"/proto.TsdbLite/CreateTimeSeries": RoleWrite,
+	"/proto.TsdbLite/AddPoint":         RoleWrite,
+	"/proto.TsdbLite/Delete":           RoleWrite,
+	"/proto.TsdbLite/Import":           RoleWrite,
+	"/proto.TsdbLite/Backfill":         RoleWrite,
+	"/proto.TsdbLite/SetMetadata":      RoleWrite,
+
+	"/grpc.reflection.v1.ServerReflection/ServerReflectionInfo":      RoleRead,
+	"/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo": RoleRead,
+}
+
+func methodRole(method string) Role {
+	if role, ok := methodRoles[method]; ok {
+		return role
+	}
+	return RoleAdmin
+}
+
+// authorize checks that id may call method.
+func (id *Identity) authorize(method string) error {
+	if role := methodRole(method); id.Role < role {
+		return status.Errorf(codes.PermissionDenied, "%s has role %s, %s requires %s", id.Name, id.Role, method, role)
+	}
+	return nil
+}
+
+// authorizeMetrics checks that id may name the metrics of a request
+// message.
+func (id *Identity) authorizeMetrics(msg any) error {
+	if len(id.MetricPrefixes) == 0 {
+		return nil
+	}
+
+	var metrics []string
+	switch m := msg.(type) {
+	case *pb.ImportRequest:
+		for _, s := range m.Samples {
+			metrics = append(metrics, s.GetMetric())
+		}
+	case interface{ GetSelector() *pb.SeriesSelector }:
+		metrics = append(metrics, m.GetSelector().GetMetric())
+	case interface{ GetMetric() string }:
+		metrics = append(metrics, m.GetMetric())
+	}
+	for _, metric := range metrics {
+		if !id.allowsMetric(metric) {
+			if metric == "" {
+				return status.Errorf(codes.PermissionDenied, "%s must name a metric", id.Name)
+			}
+			return status.Errorf(codes.PermissionDenied, "%s may not access metric %q", id.Name, metric)
+		}
+	}
+	return nil
+}
+
+func (s *Server) unaryAuthInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if a := s.auth.Load(); a != nil && !isHealthCheck(info.FullMethod) {
+		return a.unaryInterceptor(ctx, req, info, handler)
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) streamAuthInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if a := s.auth.Load(); a != nil && !isHealthCheck(info.FullMethod) {
+		return a.streamInterceptor(srv, ss, info, handler)
+	}
+	return handler(srv, ss)
+}
+
+// authenticated requires role of HTTP requests to next while authentication
+// is enabled.
+func (s *Server) authenticated(role Role, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a := s.auth.Load(); a != nil {
+			a.Middleware(role, next).ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *Authenticator) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	id, ctx, err := a.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := id.authorize(info.FullMethod); err != nil {
+		return nil, err
+	}
+	if err := id.authorizeMetrics(req); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *Authenticator) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	id, ctx, err := a.authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+	if err := id.authorize(info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, &authorizedStream{ServerStream: ss, ctx: ctx, id: id})
+}
+
+// authorizedStream checks every message a client streams in against the
+// identity that opened the stream.
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+	id  *Identity
+}
+
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *authorizedStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return s.id.authorizeMetrics(m)
+}
+
+// Middleware authenticates HTTP requests the same way as gRPC ones and
+// requires role of them.
+func (a *Authenticator) Middleware(role Role, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var chains [][]*x509.Certificate
+		if r.TLS != nil {
+			chains = r.TLS.VerifiedChains
+		}
+		id, err := a.identify(r.Header.Get("Authorization"), chains)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, status.Convert(err).Message(), http.StatusUnauthorized)
+			return
+		}
+		if id.Role < role {
+			http.Error(w, fmt.Sprintf("%s has role %s, %s requires %s", id.Name, id.Role, r.URL.Path, role), http.StatusForbidden)
+			return
+		}
+		ctx, err := id.applyTenant(TenantFromHTTP(r))
+		if err != nil {
+			http.Error(w, status.Convert(err).Message(), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, identityKey{}, id)))
+	})
+}
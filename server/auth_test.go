@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"os"
+	"path/filepath"
+	"testing"
+
+	pb "github.com/sinnlos-ffff/tsdb-lite/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestNewAuthenticator(t *testing.T) {
+	_, err := NewAuthenticator([]Identity{{Name: "a", Token: "t"}})
+	assert.ErrorContains(t, err, "no role")
+	_, err = NewAuthenticator([]Identity{{Name: "a", Role: RoleRead}})
+	assert.ErrorContains(t, err, "neither a token nor a common name")
+	_, err = NewAuthenticator([]Identity{{Name: "a", Token: "t", Role: RoleRead}, {Name: "b", Token: "t", Role: RoleAdmin}})
+	assert.ErrorContains(t, err, "reuses a token")
+}
+
+func TestAuthenticator_Identify(t *testing.T) {
+	auth, err := NewAuthenticator([]Identity{
+		{Name: "reader", Token: "secret", Role: RoleRead},
+		{Name: "collector", CommonName: "collector.internal", Role: RoleWrite},
+	})
+	require.NoError(t, err)
+
+	id, err := auth.identify("Bearer secret", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "reader", id.Name)
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "collector.internal"}}
+	id, err = auth.identify("", [][]*x509.Certificate{{cert}})
+	require.NoError(t, err)
+	assert.Equal(t, "collector", id.Name)
+
+	for _, authorization := range []string{"Bearer wrong", "Basic c2VjcmV0", ""} {
+		_, err = auth.identify(authorization, nil)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err), authorization)
+	}
+	other := &x509.Certificate{Subject: pkix.Name{CommonName: "other"}}
+	_, err = auth.identify("", [][]*x509.Certificate{{other}})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestIdentity_Authorize(t *testing.T) {
+	reader := &Identity{Name: "reader", Role: RoleRead}
+	writer := &Identity{Name: "writer", Role: RoleWrite, MetricPrefixes: []string{"app_"}}
+
+	assert.NoError(t, reader.authorize("/proto.TsdbLite/GetRange"))
+	assert.Equal(t, codes.PermissionDenied, status.Code(reader.authorize("/proto.TsdbLite/AddPoint")))
+	assert.NoError(t, writer.authorize("/proto.TsdbLite/GetRange"))
+	assert.Equal(t, codes.PermissionDenied, status.Code(writer.authorize("/proto.TsdbLite/Reshard")))
+
+	assert.NoError(t, reader.authorizeMetrics(&pb.ExportRequest{}))
+	assert.NoError(t, writer.authorizeMetrics(&pb.AddPointRequest{Metric: "app_requests"}))
+	assert.Error(t, writer.authorizeMetrics(&pb.AddPointRequest{Metric: "cpu_usage"}))
+	assert.Error(t, writer.authorizeMetrics(&pb.ExportRequest{}))
+	assert.Error(t, writer.authorizeMetrics(&pb.SeriesRequest{Selector: &pb.SeriesSelector{Metric: "cpu_usage"}}))
+	assert.Error(t, writer.authorizeMetrics(&pb.ImportRequest{Samples: []*pb.Sample{{Metric: "app_requests"}, {Metric: "cpu_usage"}}}))
+}
+
+func TestAuth_Integration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"identities": [
+		{"name": "ingest", "token": "write-token", "role": "write", "tenant": "team_a"},
+		{"name": "dashboard", "token": "read-token", "role": "read", "tenant": "team_a", "metric_prefixes": ["app_"]}
+	]}`), 0o600))
+	client, _ := setupTestServer(t, func(c *Config) { c.AuthFile = path })
+
+	as := func(token string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+	}
+
+	_, err := client.CreateTimeSeries(context.Background(), &pb.CreateTimeSeriesRequest{Metric: "app_requests"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.CreateTimeSeries(as("write-token"), &pb.CreateTimeSeriesRequest{Metric: "app_requests"})
+	require.NoError(t, err)
+	_, err = client.AddPoint(as("write-token"), &pb.AddPointRequest{Metric: "app_requests", Timestamp: 1, Value: 1})
+	require.NoError(t, err)
+
+	resp, err := client.GetRange(as("read-token"), &pb.GetRangeRequest{Metric: "app_requests", Start: 0, End: 10})
+	require.NoError(t, err)
+	assert.Len(t, resp.Points, 1)
+
+	_, err = client.AddPoint(as("read-token"), &pb.AddPointRequest{Metric: "app_requests", Timestamp: 2, Value: 1})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = client.GetRange(as("read-token"), &pb.GetRangeRequest{Metric: "cpu_usage", Start: 0, End: 10})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = client.Snapshot(as("write-token"), &pb.SnapshotRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// Identities bound to a tenant cannot switch to another
+	ctx := metadata.AppendToOutgoingContext(as("write-token"), TenantMetadataKey, "team_b")
+	_, err = client.AddPoint(ctx, &pb.AddPointRequest{Metric: "app_requests", Timestamp: 2, Value: 1})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// Stream messages are checked one by one
+	stream, err := client.Import(as("write-token"))
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.ImportRequest{Samples: []*pb.Sample{{Metric: "app_requests", Timestamp: 3, Value: 1}}}))
+	_, err = stream.Recv()
+	require.NoError(t, err)
+
+	export, err := client.Export(as("read-token"), &pb.ExportRequest{})
+	require.NoError(t, err)
+	_, err = export.Recv()
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestServer_ReloadAuth(t *testing.T) {
+	client, server := setupTestServer(t)
+	_, err := client.Series(context.Background(), &pb.SeriesRequest{})
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "auth.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"identities": [{"name": "admin", "token": "secret", "role": "admin"}]}`), 0o600))
+	require.NoError(t, server.Reload(&Config{AuthFile: path}))
+
+	_, err = client.Series(context.Background(), &pb.SeriesRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer secret")
+	_, err = client.Series(ctx, &pb.SeriesRequest{})
+	assert.NoError(t, err)
+
+	// A broken file leaves the previous identities in place
+	assert.Error(t, server.Reload(&Config{AuthFile: filepath.Join(t.TempDir(), "missing.json")}))
+	_, err = client.Series(context.Background(), &pb.SeriesRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
@@ -2,26 +2,57 @@ package server
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
 
+	"github.com/sinnlos-ffff/tsdb-lite/database"
 	pb "github.com/sinnlos-ffff/tsdb-lite/proto"
 )
 
 func (s *Server) CreateTimeSeries(ctx context.Context, req *pb.CreateTimeSeriesRequest) (*pb.CreateTimeSeriesResponse, error) {
-	if err := s.Db.AddTimeSeries(req.Metric, req.Tags); err != nil {
+	if err := checkWritable(req.Metric); err != nil {
+		return nil, err
+	}
+	// Metadata belongs to the metric, not the series, so it is set even
+	// if the series turns out to exist already.
+	tags, err := tenantTags(ctx, req.Tags)
+	if err != nil {
+		return nil, err
+	}
+	if req.Metadata != nil {
+		if err := s.Db.SetMetadata(tenantFrom(ctx), req.Metric, fromPbMetadata(req.Metadata)); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.Db.AddTimeSeries(req.Metric, tags); err != nil {
 		return nil, err
 	}
 	return &pb.CreateTimeSeriesResponse{}, nil
 }
 
 func (s *Server) AddPoint(ctx context.Context, req *pb.AddPointRequest) (*pb.AddPointResponse, error) {
-	if err := s.Db.AddPoint(req.Metric, req.Tags, req.Timestamp, req.Value); err != nil {
+	if err := checkWritable(req.Metric); err != nil {
+		return nil, err
+	}
+	tags, err := tenantTags(ctx, req.Tags)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Db.AddPointContext(ctx, req.Metric, tags, req.Timestamp, req.Value); err != nil {
 		return nil, err
 	}
 	return &pb.AddPointResponse{}, nil
 }
 
 func (s *Server) GetRange(ctx context.Context, req *pb.GetRangeRequest) (*pb.GetRangeResponse, error) {
-	points, err := s.Db.GetRange(req.Metric, req.Tags, req.Start, req.End)
+	tags, err := tenantTags(ctx, req.Tags)
+	if err != nil {
+		return nil, err
+	}
+	points, err := s.Db.GetRangeStepContext(ctx, req.Metric, tags, req.Start, req.End, req.Step, database.Aggregation(req.Aggregation))
 	if err != nil {
 		return nil, err
 	}
@@ -31,5 +62,315 @@ func (s *Server) GetRange(ctx context.Context, req *pb.GetRangeRequest) (*pb.Get
 		pbPoints[i] = &pb.Point{Timestamp: p.Timestamp, Value: p.Value}
 	}
 
-	return &pb.GetRangeResponse{Points: pbPoints}, nil
+	return &pb.GetRangeResponse{
+		Points:   pbPoints,
+		Warnings: s.Db.CheckAggregation(tenantFrom(ctx), req.Metric, database.Aggregation(req.Aggregation)),
+	}, nil
+}
+
+// timeRange returns the range given by optional start and end bounds,
+// defaulting to everything.
+func timeRange(start, end *int64) (int64, int64) {
+	s, e := int64(math.MinInt64), int64(math.MaxInt64)
+	if start != nil {
+		s = *start
+	}
+	if end != nil {
+		e = *end
+	}
+	return s, e
+}
+
+func (s *Server) Delete(ctx context.Context, req *pb.DeleteRequest) (*pb.DeleteResponse, error) {
+	if err := checkWritable(req.Metric); err != nil {
+		return nil, err
+	}
+	tags, err := tenantSelector(ctx, req.Tags)
+	if err != nil {
+		return nil, err
+	}
+	start, end := timeRange(req.Start, req.End)
+	matched, err := s.Db.Delete(req.Metric, tags, start, end)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.DeleteResponse{SeriesMatched: int64(matched)}, nil
+}
+
+func (s *Server) Reshard(ctx context.Context, req *pb.ReshardRequest) (*pb.ReshardResponse, error) {
+	if err := s.Db.Reshard(int(req.ShardCount)); err != nil {
+		return nil, err
+	}
+	return &pb.ReshardResponse{}, nil
+}
+
+func (s *Server) Snapshot(ctx context.Context, req *pb.SnapshotRequest) (*pb.SnapshotResponse, error) {
+	path, manifest, err := s.Db.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &pb.SnapshotResponse{Path: path, Lsn: manifest.LSN, Series: int64(manifest.Series)}, nil
+}
+
+func (s *Server) Backup(ctx context.Context, req *pb.BackupRequest) (*pb.BackupResponse, error) {
+	path, manifest, err := s.Db.Backup(req.Incremental)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.BackupResponse{
+		Path:          path,
+		Lsn:           manifest.LSN,
+		Series:        int64(manifest.Series),
+		ChunksWritten: int64(manifest.ChunksWritten),
+		ChunksReused:  int64(manifest.ChunksReused),
+	}, nil
+}
+
+// exportBatchSize is the most points sent in one ExportResponse.
+const exportBatchSize = 1000
+
+func (s *Server) Export(req *pb.ExportRequest, stream pb.TsdbLite_ExportServer) error {
+	tags, err := tenantSelector(stream.Context(), req.Tags)
+	if err != nil {
+		return err
+	}
+	start, end := timeRange(req.Start, req.End)
+	return s.Db.ExportContext(stream.Context(), req.Metric, tags, start, end, func(series *database.SeriesPoints) error {
+		tags := withoutTenant(series.Tags)
+		for points := series.Points; len(points) > 0; {
+			n := min(len(points), exportBatchSize)
+			pbPoints := make([]*pb.Point, n)
+			for i, p := range points[:n] {
+				pbPoints[i] = &pb.Point{Timestamp: p.Timestamp, Value: p.Value}
+			}
+			points = points[n:]
+
+			if err := stream.Send(&pb.ExportResponse{Metric: series.Metric, Tags: tags, Points: pbPoints}); err != nil {
+				return err
+			}
+		}
+		return stream.Context().Err()
+	})
+}
+
+func (s *Server) Import(stream pb.TsdbLite_ImportServer) error {
+	var progress pb.ImportResponse
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, sample := range req.Samples {
+			created, err := s.importSample(stream.Context(), sample)
+			if created {
+				progress.SeriesCreated++
+			}
+			if err != nil {
+				return err
+			}
+			progress.PointsWritten++
+		}
+
+		if err := stream.Send(&progress); err != nil {
+			return err
+		}
+	}
+}
+
+// importSample writes a sample, creating its series first if needed, and
+// reports whether it did.
+func (s *Server) importSample(ctx context.Context, sample *pb.Sample) (bool, error) {
+	if err := checkWritable(sample.Metric); err != nil {
+		return false, err
+	}
+	tags, err := tenantTags(ctx, sample.Tags)
+	if err != nil {
+		return false, err
+	}
+	return s.writeOrCreate(sample.Metric, tags, func() error {
+		return s.Db.AddPointContext(ctx, sample.Metric, tags, sample.Timestamp, sample.Value)
+	})
+}
+
+// checkWritable rejects writes to the metrics self-monitoring stores the
+// server's own metrics under.
+func checkWritable(metric string) error {
+	if strings.HasPrefix(metric, database.SelfMetricPrefix) {
+		return fmt.Errorf("%w: metric names starting with %q are reserved", database.ErrInvalidSelector, database.SelfMetricPrefix)
+	}
+	return nil
+}
+
+// writeOrCreate calls write and, if the series does not exist, creates it
+// and calls write again. It reports whether it created the series.
+func (s *Server) writeOrCreate(metric string, tags map[string]string, write func() error) (bool, error) {
+	err := write()
+	if !errors.Is(err, database.ErrSeriesNotFound) {
+		return false, err
+	}
+
+	created := true
+	if err := s.Db.AddTimeSeries(metric, tags); errors.Is(err, database.ErrSeriesExists) {
+		created = false
+	} else if err != nil {
+		return false, err
+	}
+	return created, write()
+}
+
+func (s *Server) Backfill(stream pb.TsdbLite_BackfillServer) error {
+	var resp pb.BackfillResponse
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&resp)
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := checkWritable(req.Metric); err != nil {
+			return err
+		}
+		tags, err := tenantTags(stream.Context(), req.Tags)
+		if err != nil {
+			return err
+		}
+		points := make([]database.Point, len(req.Points))
+		for i, p := range req.Points {
+			points[i] = database.Point{Timestamp: p.Timestamp, Value: p.Value}
+		}
+		created, err := s.writeOrCreate(req.Metric, tags, func() error {
+			return s.Db.Backfill(req.Metric, tags, points)
+		})
+		if created {
+			resp.SeriesCreated++
+		}
+		if err != nil {
+			return err
+		}
+		resp.PointsWritten += int64(len(points))
+	}
+}
+
+func fromPbMetadata(md *pb.Metadata) database.Metadata {
+	return database.Metadata{Type: database.MetricType(md.GetType()), Unit: md.GetUnit(), Help: md.GetHelp()}
+}
+
+func (s *Server) SetMetadata(ctx context.Context, req *pb.SetMetadataRequest) (*pb.SetMetadataResponse, error) {
+	if err := checkWritable(req.Metric); err != nil {
+		return nil, err
+	}
+	if err := s.Db.SetMetadata(tenantFrom(ctx), req.Metric, fromPbMetadata(req.GetMetadata())); err != nil {
+		return nil, err
+	}
+	return &pb.SetMetadataResponse{}, nil
+}
+
+func (s *Server) GetMetadata(ctx context.Context, req *pb.GetMetadataRequest) (*pb.GetMetadataResponse, error) {
+	metadata := s.Db.ListMetadata(tenantFrom(ctx), req.Metric)
+	resp := &pb.GetMetadataResponse{Metadata: make(map[string]*pb.Metadata, len(metadata))}
+	for metric, md := range metadata {
+		resp.Metadata[metric] = &pb.Metadata{Type: pb.MetricType(md.Type), Unit: md.Unit, Help: md.Help}
+	}
+	return resp, nil
+}
+
+// seriesQuery converts sel into a query over the series of the request's
+// tenant.
+func seriesQuery(ctx context.Context, sel *pb.SeriesSelector) (*database.SeriesQuery, error) {
+	if sel == nil {
+		sel = &pb.SeriesSelector{}
+	}
+
+	tenant, err := database.NewMatcher(database.MatchEqual, database.TenantLabel, tenantFrom(ctx))
+	if err != nil {
+		return nil, err
+	}
+	q := &database.SeriesQuery{Matchers: []*database.Matcher{tenant}, Start: sel.Start, End: sel.End, Limit: int(sel.Limit)}
+	if sel.Metric != "" {
+		m, err := database.NewMatcher(database.MatchEqual, database.MetricNameLabel, sel.Metric)
+		if err != nil {
+			return nil, err
+		}
+		q.Matchers = append(q.Matchers, m)
+	}
+	for _, pm := range sel.Matchers {
+		if pm.Name == database.TenantLabel {
+			return nil, fmt.Errorf("%w: label %q is reserved", database.ErrInvalidSelector, database.TenantLabel)
+		}
+		m, err := database.NewMatcher(database.MatchType(pm.Type), pm.Name, pm.Value)
+		if err != nil {
+			return nil, err
+		}
+		q.Matchers = append(q.Matchers, m)
+	}
+	return q, nil
+}
+
+func (s *Server) LabelNames(ctx context.Context, req *pb.LabelNamesRequest) (*pb.LabelNamesResponse, error) {
+	q, err := seriesQuery(ctx, req.GetSelector())
+	if err != nil {
+		return nil, err
+	}
+	// The limit is applied after the tenant label is dropped.
+	limit := q.Limit
+	q.Limit = 0
+
+	var names []string
+	for _, name := range s.Db.LabelNames(q) {
+		if name != database.TenantLabel && (limit <= 0 || len(names) < limit) {
+			names = append(names, name)
+		}
+	}
+	return &pb.LabelNamesResponse{Names: names}, nil
+}
+
+func (s *Server) LabelValues(ctx context.Context, req *pb.LabelValuesRequest) (*pb.LabelValuesResponse, error) {
+	if req.Name == "" || req.Name == database.TenantLabel {
+		return nil, database.ErrInvalidSelector
+	}
+	q, err := seriesQuery(ctx, req.GetSelector())
+	if err != nil {
+		return nil, err
+	}
+	return &pb.LabelValuesResponse{Values: s.Db.LabelValues(req.Name, q)}, nil
+}
+
+func (s *Server) Series(ctx context.Context, req *pb.SeriesRequest) (*pb.SeriesResponse, error) {
+	q, err := seriesQuery(ctx, req.GetSelector())
+	if err != nil {
+		return nil, err
+	}
+
+	series := s.Db.Series(q)
+	resp := &pb.SeriesResponse{Series: make([]*pb.SeriesLabels, len(series))}
+	for i, l := range series {
+		resp.Series[i] = &pb.SeriesLabels{Metric: l.Metric, Tags: withoutTenant(l.Tags)}
+	}
+	return resp, nil
+}
+
+func (s *Server) Cardinality(ctx context.Context, req *pb.CardinalityRequest) (*pb.CardinalityResponse, error) {
+	report := s.Db.Cardinality(tenantFrom(ctx), int(req.Limit), req.Approximate)
+	stats := func(stats []database.CardinalityStat) []*pb.CardinalityStat {
+		result := make([]*pb.CardinalityStat, len(stats))
+		for i, stat := range stats {
+			result[i] = &pb.CardinalityStat{Name: stat.Name, Value: stat.Value}
+		}
+		return result
+	}
+
+	return &pb.CardinalityResponse{
+		Series:       int64(report.Series),
+		Approximate:  report.Approximate,
+		TopMetrics:   stats(report.TopMetrics),
+		TopTagKeys:   stats(report.TopTagKeys),
+		TopTagValues: stats(report.TopTagValues),
+		TopMemory:    stats(report.TopMemory),
+	}, nil
 }
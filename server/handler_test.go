@@ -115,3 +115,101 @@ func TestGetRange(t *testing.T) {
 	assert.Equal(t, value1, resp.Points[0].Value)
 	assert.Equal(t, value2, resp.Points[1].Value)
 }
+
+func TestGetRange_Step(t *testing.T) {
+	db := database.NewDatabase()
+	server := &Server{Db: db}
+
+	metric := "test_metric"
+	tags := map[string]string{"tag1": "value1"}
+	err := db.AddTimeSeries(metric, tags)
+	assert.NoError(t, err)
+
+	for i := int64(0); i < 10; i++ {
+		db.AddPoint(metric, tags, i*100, float64(i))
+	}
+
+	req := &pb.GetRangeRequest{
+		Metric:      metric,
+		Tags:        tags,
+		Start:       0,
+		End:         1000,
+		Step:        500,
+		Aggregation: pb.Aggregation_AGGREGATION_MAX,
+	}
+
+	resp, err := server.GetRange(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Len(t, resp.Points, 2)
+	assert.Equal(t, int64(0), resp.Points[0].Timestamp)
+	assert.Equal(t, 4.0, resp.Points[0].Value)
+	assert.Equal(t, int64(500), resp.Points[1].Timestamp)
+	assert.Equal(t, 9.0, resp.Points[1].Value)
+}
+
+func TestGetRange_Warnings(t *testing.T) {
+	db := database.NewDatabase()
+	server := &Server{Db: db}
+
+	_, err := server.CreateTimeSeries(context.Background(), &pb.CreateTimeSeriesRequest{
+		Metric:   "cpu_usage",
+		Metadata: &pb.Metadata{Type: pb.MetricType_METRIC_TYPE_GAUGE, Unit: "percent"},
+	})
+	assert.NoError(t, err)
+
+	resp, err := server.GetMetadata(context.Background(), &pb.GetMetadataRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "percent", resp.Metadata["cpu_usage"].Unit)
+
+	rangeResp, err := server.GetRange(context.Background(), &pb.GetRangeRequest{
+		Metric:      "cpu_usage",
+		End:         1000,
+		Step:        100,
+		Aggregation: pb.Aggregation_AGGREGATION_RATE,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, rangeResp.Warnings, 1)
+}
+
+func TestLabelValues(t *testing.T) {
+	db := database.NewDatabase()
+	server := &Server{Db: db}
+
+	assert.NoError(t, db.AddTimeSeries("cpu_usage", map[string]string{"host": "a"}))
+	assert.NoError(t, db.AddTimeSeries("cpu_usage", map[string]string{"host": "b"}))
+	assert.NoError(t, db.AddTimeSeries("mem_usage", map[string]string{"host": "c"}))
+
+	resp, err := server.LabelValues(context.Background(), &pb.LabelValuesRequest{
+		Name:     "host",
+		Selector: &pb.SeriesSelector{Metric: "cpu_usage"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, resp.Values)
+
+	names, err := server.LabelNames(context.Background(), &pb.LabelNamesRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"host"}, names.Names)
+
+	series, err := server.Series(context.Background(), &pb.SeriesRequest{Selector: &pb.SeriesSelector{
+		Matchers: []*pb.Matcher{{Type: pb.Matcher_REGEX, Name: "host", Value: "[bc]"}},
+	}})
+	assert.NoError(t, err)
+	assert.Len(t, series.Series, 2)
+
+	_, err = server.Series(context.Background(), &pb.SeriesRequest{Selector: &pb.SeriesSelector{
+		Matchers: []*pb.Matcher{{Type: pb.Matcher_REGEX, Name: "host", Value: "("}},
+	}})
+	assert.ErrorIs(t, err, database.ErrInvalidSelector)
+}
+
+func TestSelfMetricsReserved(t *testing.T) {
+	server := &Server{Db: database.NewDatabase()}
+	metric := database.SelfMetricName("ingest_total")
+
+	_, err := server.CreateTimeSeries(context.Background(), &pb.CreateTimeSeriesRequest{Metric: metric})
+	assert.ErrorIs(t, err, database.ErrInvalidSelector)
+	_, err = server.AddPoint(context.Background(), &pb.AddPointRequest{Metric: metric, Timestamp: 1, Value: 1})
+	assert.ErrorIs(t, err, database.ErrInvalidSelector)
+	_, err = server.SetMetadata(context.Background(), &pb.SetMetadataRequest{Metric: metric})
+	assert.ErrorIs(t, err, database.ErrInvalidSelector)
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	pb "github.com/sinnlos-ffff/tsdb-lite/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// ServiceName is the service health checks can ask about besides the
+// server as a whole, named by "".
+var ServiceName = pb.TsdbLite_ServiceDesc.ServiceName
+
+// isHealthCheck reports whether method belongs to the health service, which
+// needs no authentication.
+func isHealthCheck(method string) bool {
+	return strings.HasPrefix(method, "/grpc.health.v1.Health/")
+}
+
+// alwaysAvailable reports whether method is answered while the database is
+// still loading: health checks and reflection.
+func alwaysAvailable(method string) bool {
+	return isHealthCheck(method) || strings.HasPrefix(method, "/grpc.reflection.")
+}
+
+// setServing reports every service as serving or not.
+func (s *Server) setServing(serving bool) {
+	st := healthpb.HealthCheckResponse_NOT_SERVING
+	if serving {
+		st = healthpb.HealthCheckResponse_SERVING
+	}
+	s.health.SetServingStatus("", st)
+	s.health.SetServingStatus(ServiceName, st)
+}
+
+func (s *Server) unaryReadyInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if !s.ready.Load() && !alwaysAvailable(info.FullMethod) {
+		return nil, status.Error(codes.Unavailable, "the database is still loading")
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) streamReadyInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if !s.ready.Load() && !alwaysAvailable(info.FullMethod) {
+		return status.Error(codes.Unavailable, "the database is still loading")
+	}
+	if isHealthCheck(info.FullMethod) {
+		// Watch streams never end by themselves, and would hold up
+		// Shutdown until its deadline.
+		ctx, cancel := context.WithCancel(ss.Context())
+		defer cancel()
+		stop := context.AfterFunc(s.stopping, cancel)
+		defer stop()
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+	return handler(srv, ss)
+}
+
+// contextStream replaces the context of a stream.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// HTTPHandler serves /healthz, which succeeds while the process is up,
+// /readyz, which succeeds while the server is serving requests, and the
+// Prometheus metrics of the default registry on /metrics, which requires
+// RoleRead if authentication is enabled.
+func (s *Server) HTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		resp, err := s.health.Check(r.Context(), &healthpb.HealthCheckRequest{})
+		if err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+	mux.Handle("GET /metrics", s.authenticated(RoleRead, promhttp.Handler()))
+	return mux
+}
+
+// ListenAndServeHTTP serves HTTPHandler on addr, over TLS if the gRPC API
+// is, until Shutdown.
+func (s *Server) ListenAndServeHTTP(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if s.httpServer.TLSConfig != nil {
+		lis = tls.NewListener(lis, s.httpServer.TLSConfig)
+	}
+	if err := s.httpServer.Serve(lis); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
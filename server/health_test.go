@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sinnlos-ffff/tsdb-lite/metrics"
+	pb "github.com/sinnlos-ffff/tsdb-lite/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestServer_Health(t *testing.T) {
+	authFile := filepath.Join(t.TempDir(), "auth.json")
+	require.NoError(t, os.WriteFile(authFile, []byte(`{"identities": [{"name": "admin", "token": "secret", "role": "admin"}]}`), 0o600))
+	server, err := New(&Config{CompactionInterval: time.Minute, WALDir: t.TempDir(), AuthFile: authFile})
+	require.NoError(t, err)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go server.grpcServer.Serve(lis)
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	health := healthpb.NewHealthClient(conn)
+	client := pb.NewTsdbLiteClient(conn)
+
+	ready := func() int {
+		rec := httptest.NewRecorder()
+		server.HTTPHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return rec.Code
+	}
+	check := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := health.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		return resp.Status
+	}
+
+	// Health checks need no token, and are answered before the database
+	// is open
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check(""))
+	assert.Equal(t, http.StatusServiceUnavailable, ready())
+	_, err = client.Series(context.Background(), &pb.SeriesRequest{})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Zero(t, testutil.CollectAndCount(server.Collector()))
+
+	require.NoError(t, server.Open())
+	assert.Positive(t, testutil.CollectAndCount(server.Collector(), "tsdb_wal_queue_depth"))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, check(""))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, check(ServiceName))
+	assert.Equal(t, http.StatusOK, ready())
+	_, err = client.Series(context.Background(), &pb.SeriesRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	rec := httptest.NewRecorder()
+	server.HTTPHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Watch streams do not hold up shutdown
+	watch, err := health.Watch(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	resp, err := watch.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, server.Shutdown(ctx))
+	assert.Equal(t, http.StatusServiceUnavailable, ready())
+}
+
+func TestServer_Reflection(t *testing.T) {
+	_, server := setupTestServer(t)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go server.grpcServer.Serve(lis)
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	}))
+	resp, err := stream.Recv()
+	require.NoError(t, err)
+
+	var services []string
+	for _, s := range resp.GetListServicesResponse().Service {
+		services = append(services, s.Name)
+	}
+	assert.Contains(t, services, ServiceName)
+	assert.Contains(t, services, "grpc.health.v1.Health")
+}
+
+func TestServer_Metrics(t *testing.T) {
+	authFile := filepath.Join(t.TempDir(), "auth.json")
+	require.NoError(t, os.WriteFile(authFile, []byte(`{"identities": [{"name": "prometheus", "token": "secret", "role": "read"}]}`), 0o600))
+	_, server := setupTestServer(t, func(c *Config) { c.AuthFile = authFile })
+
+	scrape := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		server.HTTPHandler().ServeHTTP(rec, req)
+		return rec
+	}
+	assert.Equal(t, http.StatusUnauthorized, scrape("").Code)
+	rec := scrape("secret")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "go_goroutines")
+}
+
+func TestServer_RejectedWrites(t *testing.T) {
+	client, _ := setupTestServer(t)
+	rejected := func(reason string) float64 {
+		return testutil.ToFloat64(metrics.RejectedWritesTotal.WithLabelValues(reason))
+	}
+	before := rejected("series_not_found")
+
+	_, err := client.AddPoint(context.Background(), &pb.AddPointRequest{Metric: "missing", Timestamp: 1, Value: 1})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, before+1, rejected("series_not_found"))
+
+	// Failed reads are not rejected writes
+	_, err = client.GetRange(context.Background(), &pb.GetRangeRequest{Metric: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, before+1, rejected("series_not_found"))
+	assert.Positive(t, testutil.CollectAndCount(metrics.RPCDuration, "tsdb_rpc_duration_seconds"))
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sinnlos-ffff/tsdb-lite/database"
+	"github.com/sinnlos-ffff/tsdb-lite/metrics"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// toStatus converts errors returned by the database package into gRPC status
+// errors. Errors that already carry a status are passed through unchanged.
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	var code codes.Code
+	switch {
+	case errors.Is(err, database.ErrSeriesExists), errors.Is(err, database.ErrDuplicate):
+		code = codes.AlreadyExists
+	case errors.Is(err, database.ErrSeriesNotFound):
+		code = codes.NotFound
+	case errors.Is(err, database.ErrOutOfBounds),
+		errors.Is(err, database.ErrNotSorted),
+		errors.Is(err, database.ErrInvalidSelector),
+		errors.Is(err, database.ErrInvalidShardCount),
+		errors.Is(err, database.ErrInvalidMetadata):
+		code = codes.InvalidArgument
+	case errors.Is(err, database.ErrLimitExceeded):
+		code = codes.ResourceExhausted
+	case errors.Is(err, database.ErrNotConfigured):
+		code = codes.FailedPrecondition
+	case errors.Is(err, database.ErrUnknownTenant):
+		code = codes.PermissionDenied
+	case errors.Is(err, database.ErrClosed):
+		code = codes.Unavailable
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	default:
+		code = codes.Internal
+	}
+
+	st := status.New(code, err.Error())
+
+	var seriesErr *database.SeriesError
+	if errors.As(err, &seriesErr) {
+		if detailed, derr := st.WithDetails(&errdetails.ResourceInfo{
+			ResourceType: "time_series",
+			ResourceName: seriesErr.Key(),
+			Description:  seriesErr.Err.Error(),
+		}); derr == nil {
+			st = detailed
+		}
+	}
+
+	return st.Err()
+}
+
+// rejectionReason returns why a write failed, for RejectedWritesTotal, or
+// "" if it was rejected before and counted already, or not rejected at all.
+func rejectionReason(err error) string {
+	if err == nil {
+		return ""
+	}
+	if st, ok := status.FromError(err); ok {
+		switch st.Code() {
+		case codes.Unauthenticated, codes.PermissionDenied:
+			return "unauthorized"
+		case codes.InvalidArgument:
+			return "invalid"
+		case codes.Unavailable:
+			return "unavailable"
+		}
+		// ResourceExhausted comes from the ingest limiter, which counts its
+		// own rejections.
+		return ""
+	}
+
+	switch {
+	case errors.Is(err, database.ErrOutOfBounds):
+		return "out_of_bounds"
+	case errors.Is(err, database.ErrDuplicate):
+		return "duplicate"
+	case errors.Is(err, database.ErrLimitExceeded):
+		return "limit"
+	case errors.Is(err, database.ErrSeriesNotFound):
+		return "series_not_found"
+	case errors.Is(err, database.ErrSeriesExists):
+		return "series_exists"
+	case errors.Is(err, database.ErrUnknownTenant):
+		return "unauthorized"
+	case errors.Is(err, database.ErrNotSorted),
+		errors.Is(err, database.ErrInvalidSelector),
+		errors.Is(err, database.ErrInvalidMetadata):
+		return "invalid"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return ""
+	}
+	return "error"
+}
+
+// countRejection counts a failed call to a write method in
+// RejectedWritesTotal.
+func countRejection(method string, err error) {
+	if methodRole(method) != RoleWrite {
+		return
+	}
+	if reason := rejectionReason(err); reason != "" {
+		metrics.RejectedWritesTotal.WithLabelValues(reason).Inc()
+	}
+}
+
+// observeCall records the duration of a call and, if it was a rejected
+// write, why. err is the error before toStatus converts it.
+func observeCall(method string, start time.Time, err error) {
+	countRejection(method, err)
+	code := status.Code(toStatus(err))
+	metrics.RPCDuration.WithLabelValues(method, code.String()).Observe(time.Since(start).Seconds())
+}
+
+func unaryErrorInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	resp, err := handler(ctx, req)
+	return resp, toStatus(err)
+}
+
+func streamErrorInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return toStatus(handler(srv, ss))
+}
+
+func unaryMetricsInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	observeCall(info.FullMethod, start, err)
+	return resp, err
+}
+
+func streamMetricsInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	observeCall(info.FullMethod, start, err)
+	return err
+}
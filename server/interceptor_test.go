@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/sinnlos-ffff/tsdb-lite/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestToStatus(t *testing.T) {
+	tags := map[string]string{"host": "server1"}
+
+	tests := []struct {
+		name string
+		err  error
+		code codes.Code
+	}{
+		{"exists", &database.SeriesError{Err: database.ErrSeriesExists, Metric: "cpu", Tags: tags}, codes.AlreadyExists},
+		{"not found", &database.SeriesError{Err: database.ErrSeriesNotFound, Metric: "cpu", Tags: tags}, codes.NotFound},
+		{"out of bounds", &database.SeriesError{Err: database.ErrOutOfBounds, Metric: "cpu", Tags: tags}, codes.InvalidArgument},
+		{"limit", database.ErrLimitExceeded, codes.ResourceExhausted},
+		{"closed", database.ErrClosed, codes.Unavailable},
+		{"internal", errors.New("boom"), codes.Internal},
+		{"status", status.Error(codes.Unavailable, "down"), codes.Unavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, ok := status.FromError(toStatus(tt.err))
+			require.True(t, ok)
+			assert.Equal(t, tt.code, st.Code())
+		})
+	}
+
+	assert.NoError(t, toStatus(nil))
+}
+
+func TestToStatus_SeriesDetails(t *testing.T) {
+	db := database.NewDatabase()
+
+	_, err := unaryErrorInterceptor(context.Background(), nil, nil, func(ctx context.Context, req any) (any, error) {
+		return nil, db.AddPoint("cpu", map[string]string{"host": "server1"}, 1000, 1.0)
+	})
+
+	st, ok := status.FromError(err)
+	require.True(t, ok)
+	assert.Equal(t, codes.NotFound, st.Code())
+	require.Len(t, st.Details(), 1)
+
+	info, ok := st.Details()[0].(*errdetails.ResourceInfo)
+	require.True(t, ok)
+	assert.Equal(t, "cpu{host=server1}", info.ResourceName)
+}
@@ -0,0 +1,266 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"runtime/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sinnlos-ffff/tsdb-lite/database"
+	tsdbmetrics "github.com/sinnlos-ffff/tsdb-lite/metrics"
+	pb "github.com/sinnlos-ffff/tsdb-lite/proto"
+	"golang.org/x/time/rate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// RateLimit is a token bucket of points per second, allowing bursts of up
+// to Burst points, or PointsPerSecond rounded up if zero. A zero
+// PointsPerSecond means no limit.
+type RateLimit struct {
+	PointsPerSecond float64 `json:"points_per_second"`
+	Burst           int     `json:"burst"`
+}
+
+func (r RateLimit) burst() int {
+	if r.Burst > 0 {
+		return r.Burst
+	}
+	return max(int(math.Ceil(r.PointsPerSecond)), 1)
+}
+
+// LoadShedding rejects writes while the server is overloaded, so that it
+// slows clients down instead of running out of memory. Zero thresholds are
+// not checked.
+type LoadShedding struct {
+	// MaxHeapBytes is the most live heap memory to accept writes with.
+	MaxHeapBytes uint64
+	// MaxWALQueue is the most writes waiting for the WAL to accept more
+	// with.
+	MaxWALQueue int
+	// RetryAfter is how long rejected clients are told to wait, 1s if
+	// zero.
+	RetryAfter time.Duration
+}
+
+// heapSampleInterval is how often the heap size is read for load shedding.
+const heapSampleInterval = 100 * time.Millisecond
+
+// limiterSweepInterval is how often the rate limiters of clients that have
+// gone quiet are dropped.
+const limiterSweepInterval = time.Minute
+
+// ingestLimiter rate-limits the points each client writes and sheds writes
+// under load.
+type ingestLimiter struct {
+	db       *database.Database
+	settings atomic.Pointer[limiterSettings]
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+	swept    time.Time
+
+	heapBytes   atomic.Uint64
+	heapSampled atomic.Int64
+}
+
+type limiterSettings struct {
+	limit    RateLimit
+	shedding LoadShedding
+}
+
+func newIngestLimiter(db *database.Database, limit RateLimit, shedding LoadShedding) *ingestLimiter {
+	l := &ingestLimiter{db: db}
+	l.configure(limit, shedding)
+	return l
+}
+
+// configure replaces the limits, giving every client a fresh budget.
+func (l *ingestLimiter) configure(limit RateLimit, shedding LoadShedding) {
+	if shedding.RetryAfter <= 0 {
+		shedding.RetryAfter = time.Second
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.settings.Store(&limiterSettings{limit: limit, shedding: shedding})
+	l.limiters = make(map[string]*rate.Limiter)
+}
+
+// clientKey names the client of a request for rate limiting: its identity
+// if it authenticated, else its address. Nothing the client merely claims,
+// such as its tenant, is part of the key, so a client cannot pick a fresh
+// budget for itself.
+func clientKey(ctx context.Context) string {
+	if id := identityFrom(ctx); id != nil {
+		return "identity:" + id.Name
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return "addr:" + host
+		}
+		return "addr:" + p.Addr.String()
+	}
+	return ""
+}
+
+// limiter returns the rate limiter of the request's client, or nil if it
+// has no limit.
+func (l *ingestLimiter) limiter(ctx context.Context) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limit := l.settings.Load().limit
+	if id := identityFrom(ctx); id != nil && id.IngestRate != nil {
+		limit = *id.IngestRate
+	}
+	if limit.PointsPerSecond <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	if now.Sub(l.swept) >= limiterSweepInterval {
+		l.sweep(now)
+	}
+
+	key := clientKey(ctx)
+	limiter := l.limiters[key]
+	if limiter == nil {
+		limiter = rate.NewLimiter(rate.Limit(limit.PointsPerSecond), limit.burst())
+		l.limiters[key] = limiter
+	}
+	return limiter
+}
+
+// sweep drops the limiters whose buckets have refilled. A full bucket is
+// what a new client starts with, so no client gains budget by it, and the
+// limiters of clients that went away do not pile up. l.mu must be held.
+func (l *ingestLimiter) sweep(now time.Time) {
+	for key, limiter := range l.limiters {
+		if limiter.TokensAt(now) >= float64(limiter.Burst()) {
+			delete(l.limiters, key)
+		}
+	}
+	l.swept = now
+}
+
+// heapInUse returns the bytes of heap memory occupied by objects, sampled
+// at most every heapSampleInterval.
+func (l *ingestLimiter) heapInUse() uint64 {
+	now := time.Now().UnixNano()
+	if last := l.heapSampled.Load(); now-last >= int64(heapSampleInterval) && l.heapSampled.CompareAndSwap(last, now) {
+		sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+		metrics.Read(sample)
+		l.heapBytes.Store(sample[0].Value.Uint64())
+	}
+	return l.heapBytes.Load()
+}
+
+// shed returns an error if writes should be rejected to relieve load.
+func (l *ingestLimiter) shed() error {
+	shedding := l.settings.Load().shedding
+	if shedding.MaxHeapBytes > 0 {
+		if heap := l.heapInUse(); heap > shedding.MaxHeapBytes {
+			tsdbmetrics.RejectedWritesTotal.WithLabelValues("heap").Inc()
+			return retryAfter(codes.ResourceExhausted, shedding.RetryAfter, "server overloaded: %d bytes of heap in use", heap)
+		}
+	}
+	if shedding.MaxWALQueue > 0 {
+		if depth := l.db.WALQueueDepth(); depth > shedding.MaxWALQueue {
+			tsdbmetrics.RejectedWritesTotal.WithLabelValues("wal_queue").Inc()
+			return retryAfter(codes.ResourceExhausted, shedding.RetryAfter, "server overloaded: %d writes waiting for the WAL", depth)
+		}
+	}
+	return nil
+}
+
+// allow takes the points of a write request out of its client's budget.
+func (l *ingestLimiter) allow(ctx context.Context, msg any) error {
+	n := countPoints(msg)
+	if n == 0 {
+		return nil
+	}
+	limiter := l.limiter(ctx)
+	if limiter == nil {
+		return nil
+	}
+
+	now := time.Now()
+	r := limiter.ReserveN(now, n)
+	if !r.OK() {
+		tsdbmetrics.RejectedWritesTotal.WithLabelValues("rate_limit").Inc()
+		return status.Errorf(codes.ResourceExhausted, "request of %d points exceeds the burst of %d points", n, limiter.Burst())
+	}
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		tsdbmetrics.RejectedWritesTotal.WithLabelValues("rate_limit").Inc()
+		return retryAfter(codes.ResourceExhausted, delay, "rate limit of %g points/s exceeded", float64(limiter.Limit()))
+	}
+	return nil
+}
+
+// countPoints returns how many points a request message writes.
+func countPoints(msg any) int {
+	switch m := msg.(type) {
+	case *pb.AddPointRequest:
+		return 1
+	case *pb.ImportRequest:
+		return len(m.Samples)
+	case *pb.BackfillRequest:
+		return len(m.Points)
+	}
+	return 0
+}
+
+// retryAfter returns a status error telling the client when to try again.
+func retryAfter(code codes.Code, delay time.Duration, format string, args ...any) error {
+	st := status.New(code, fmt.Sprintf(format, args...))
+	if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(delay)}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+func (l *ingestLimiter) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if methodRole(info.FullMethod) == RoleWrite {
+		if err := l.shed(); err != nil {
+			return nil, err
+		}
+	}
+	if err := l.allow(ctx, req); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (l *ingestLimiter) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if methodRole(info.FullMethod) != RoleWrite {
+		return handler(srv, ss)
+	}
+	return handler(srv, &limitedStream{ServerStream: ss, limiter: l})
+}
+
+// limitedStream checks every message a client streams in against the load
+// and its rate limit.
+type limitedStream struct {
+	grpc.ServerStream
+	limiter *ingestLimiter
+}
+
+func (s *limitedStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if err := s.limiter.shed(); err != nil {
+		return err
+	}
+	return s.limiter.allow(s.Context(), m)
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sinnlos-ffff/tsdb-lite/database"
+	pb "github.com/sinnlos-ffff/tsdb-lite/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// retryDelay returns the retry hint of a status error, or 0 if it has none.
+func retryDelay(err error) time.Duration {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			return info.RetryDelay.AsDuration()
+		}
+	}
+	return 0
+}
+
+func TestIngestLimiter_Allow(t *testing.T) {
+	l := newIngestLimiter(database.NewDatabase(), RateLimit{PointsPerSecond: 1, Burst: 2}, LoadShedding{})
+	client := func(addr string) context.Context {
+		return peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(addr), Port: 1234}})
+	}
+
+	ctx := client("10.0.0.1")
+	require.NoError(t, l.allow(ctx, &pb.AddPointRequest{}))
+	require.NoError(t, l.allow(ctx, &pb.AddPointRequest{}))
+	err := l.allow(ctx, &pb.AddPointRequest{})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Greater(t, retryDelay(err), time.Duration(0))
+
+	// Requests that write nothing are not limited, and other clients have
+	// their own budget
+	assert.NoError(t, l.allow(ctx, &pb.GetRangeRequest{}))
+	assert.NoError(t, l.allow(client("10.0.0.2"), &pb.ImportRequest{Samples: make([]*pb.Sample, 2)}))
+
+	// A request larger than the burst can never succeed
+	err = l.allow(client("10.0.0.3"), &pb.BackfillRequest{Points: make([]*pb.Point, 3)})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Zero(t, retryDelay(err))
+
+	// Claiming another tenant does not give a client a fresh budget
+	err = l.allow(context.WithValue(ctx, tenantKey{}, "team_a"), &pb.AddPointRequest{})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// Identities can have their own limit, whose burst defaults to the
+	// rate
+	id := &Identity{Name: "bulk", IngestRate: &RateLimit{PointsPerSecond: 100, Burst: 100}}
+	assert.NoError(t, l.allow(context.WithValue(ctx, identityKey{}, id), &pb.ImportRequest{Samples: make([]*pb.Sample, 50)}))
+	id = &Identity{Name: "unbounded", IngestRate: &RateLimit{PointsPerSecond: 100}}
+	assert.NoError(t, l.allow(context.WithValue(ctx, identityKey{}, id), &pb.ImportRequest{Samples: make([]*pb.Sample, 100)}))
+}
+
+func TestIngestLimiter_Sweep(t *testing.T) {
+	l := newIngestLimiter(database.NewDatabase(), RateLimit{PointsPerSecond: 1, Burst: 2}, LoadShedding{})
+	for _, addr := range []string{"10.0.0.1", "10.0.0.2"} {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(addr), Port: 1234}})
+		require.NoError(t, l.allow(ctx, &pb.AddPointRequest{}))
+	}
+	require.Len(t, l.limiters, 2)
+
+	// Only the limiters that have refilled are dropped
+	now := time.Now()
+	l.limiters["addr:10.0.0.2"].AllowN(now, 1)
+	l.sweep(now.Add(1500 * time.Millisecond))
+	assert.Len(t, l.limiters, 1)
+	assert.Contains(t, l.limiters, "addr:10.0.0.2")
+}
+
+func TestIngestLimiter_Shed(t *testing.T) {
+	l := newIngestLimiter(database.NewDatabase(), RateLimit{}, LoadShedding{MaxWALQueue: 10})
+	assert.NoError(t, l.shed())
+
+	l = newIngestLimiter(database.NewDatabase(), RateLimit{}, LoadShedding{MaxHeapBytes: 1, RetryAfter: 5 * time.Second})
+	err := l.shed()
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, 5*time.Second, retryDelay(err))
+}
+
+func TestServer_LoadShedding(t *testing.T) {
+	client, _ := setupTestServer(t, func(c *Config) {
+		c.LoadShedding = LoadShedding{MaxHeapBytes: 1}
+	})
+
+	// Reads carry on while writes are shed
+	_, err := client.CreateTimeSeries(context.Background(), &pb.CreateTimeSeriesRequest{Metric: "cpu_usage"})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, time.Second, retryDelay(err))
+	_, err = client.Series(context.Background(), &pb.SeriesRequest{})
+	assert.NoError(t, err)
+
+	stream, err := client.Import(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.ImportRequest{Samples: []*pb.Sample{{Metric: "cpu_usage", Timestamp: 1}}}))
+	_, err = stream.Recv()
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
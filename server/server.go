@@ -1,33 +1,236 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
 	"net"
+	"net/http"
+	"sync/atomic"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sinnlos-ffff/tsdb-lite/database"
 	pb "github.com/sinnlos-ffff/tsdb-lite/proto"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc/filters"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 )
 
 type Server struct {
 	Db         *database.Database
 	grpcServer *grpc.Server
+	// auth is nil while authentication is disabled.
+	auth    atomic.Pointer[Authenticator]
+	limiter *ingestLimiter
+	config  *Config
+	// ready is set once Open has restored the database.
+	ready      atomic.Bool
+	health     *health.Server
+	httpServer *http.Server
+	// stopping is cancelled when Shutdown starts, and stopBackground stops
+	// the compactors and retention.
+	stopping       context.Context
+	stop           context.CancelFunc
+	stopBackground context.CancelFunc
 	pb.UnimplementedTsdbLiteServer
 }
 
+// Config configures a Server. The fields Reload can change are noted.
 type Config struct {
+	// ShardCount defaults to database.DefaultOptions().ShardCount.
+	ShardCount int
+	// ChunkSize defaults to database.ChunkSize.
+	ChunkSize          int
 	CompactionInterval time.Duration
+	// OutOfOrderWindow is in the same unit as point timestamps. Zero
+	// accepts samples however late they are.
+	OutOfOrderWindow int64
+
+	DuplicatePolicy         database.DuplicatePolicy
+	MetricDuplicatePolicies map[string]database.DuplicatePolicy
+
+	// Retention is checked every CompactionInterval. Retention,
+	// MetricRetention, Limits and Tenants can be reloaded.
+	Retention       time.Duration
+	MetricRetention map[string]time.Duration
+	Rollups         []database.RollupTier
+	Limits          database.Limits
+	// Tenants holds the quotas of tenants, keyed by tenant ID. Requests
+	// name their tenant through TenantMetadataKey. If any are set, writes
+	// for tenants not listed, other than the default one, are rejected.
+	Tenants map[string]database.TenantLimits
+
+	// WALFlushInterval is how often the write-ahead log is synced, which
+	// WALSync holds writes back until.
+	WALFlushInterval time.Duration
+	WALSync          bool
+	// WALDir enables the write-ahead log. SnapshotDir is where the Snapshot
+	// RPC writes to and where the newest snapshot is restored from on
+	// startup, unless RestoreSnapshot names another one.
+	WALDir          string
+	SnapshotDir     string
+	RestoreSnapshot string
+	// BackupDir is where the Backup RPC writes full and incremental
+	// backups to.
+	BackupDir string
+	// SnapshotOnShutdown takes a snapshot during Shutdown, so the next
+	// start has less of the WAL to replay.
+	SnapshotOnShutdown bool
+	// SelfMonitorInterval, if positive, is how often the metrics of the
+	// default Prometheus registry are written into the database as series
+	// starting with database.SelfMetricPrefix.
+	SelfMonitorInterval time.Duration
+
+	// TLS, if enabled, serves the API over TLS.
+	TLS TLSConfig
+	// AuthFile, if set, names the identities file LoadAuthenticator reads,
+	// and every request must then authenticate as one of them. It can be
+	// reloaded.
+	AuthFile string
+
+	// ClientRateLimit caps the points each client writes, telling clients
+	// apart by identity, or by address if they have none. It and
+	// LoadShedding can be reloaded.
+	ClientRateLimit RateLimit
+	LoadShedding    LoadShedding
 }
 
-func NewServer(config *Config) *Server {
-	db := database.NewDatabase()
+// NewServer creates a server and opens its database.
+func NewServer(config *Config) (*Server, error) {
+	s, err := New(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// New creates a server without opening its database, so that it can
+// already listen while Open restores it. Until then, health checks report
+// NOT_SERVING and other requests fail with Unavailable.
+func New(config *Config) (*Server, error) {
 	s := &Server{
-		Db:         db,
-		grpcServer: grpc.NewServer(),
+		config:  config,
+		health:  health.NewServer(),
+		limiter: newIngestLimiter(nil, config.ClientRateLimit, config.LoadShedding),
+	}
+	s.stopping, s.stop = context.WithCancel(context.Background())
+	s.setServing(false)
+	if config.AuthFile != "" {
+		auth, err := LoadAuthenticator(config.AuthFile)
+		if err != nil {
+			return nil, err
+		}
+		s.auth.Store(auth)
 	}
-	db.StartCompactors(config.CompactionInterval)
+
+	opts := []grpc.ServerOption{
+		grpc.StatsHandler(otelgrpc.NewServerHandler(otelgrpc.WithFilter(filters.Not(filters.HealthCheck())))),
+		grpc.ChainUnaryInterceptor(unaryErrorInterceptor, unaryMetricsInterceptor, s.unaryReadyInterceptor, s.unaryAuthInterceptor, s.limiter.unaryInterceptor),
+		grpc.ChainStreamInterceptor(streamErrorInterceptor, streamMetricsInterceptor, s.streamReadyInterceptor, s.streamAuthInterceptor, s.limiter.streamInterceptor),
+	}
+	s.httpServer = &http.Server{Handler: s.HTTPHandler()}
+	if config.TLS.Enabled() {
+		certs, err := newCertReloader(config.TLS)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(certs.serverConfig("h2"))))
+		s.httpServer.TLSConfig = certs.serverConfig("h2", "http/1.1")
+	}
+
+	s.grpcServer = grpc.NewServer(opts...)
 	pb.RegisterTsdbLiteServer(s.grpcServer, s)
-	return s
+	healthpb.RegisterHealthServer(s.grpcServer, s.health)
+	reflection.Register(s.grpcServer)
+	return s, nil
+}
+
+// Open restores the database from disk, replaying the WAL, and starts
+// serving requests.
+func (s *Server) Open() error {
+	return s.OpenContext(context.Background())
+}
+
+// OpenContext is Open, giving up on replaying the WAL once ctx is done.
+func (s *Server) OpenContext(ctx context.Context) error {
+	config := s.config
+	db, err := database.OpenContext(ctx, &database.Options{
+		ShardCount:              config.ShardCount,
+		ChunkSize:               config.ChunkSize,
+		OutOfOrderWindow:        config.OutOfOrderWindow,
+		DuplicatePolicy:         config.DuplicatePolicy,
+		MetricDuplicatePolicies: config.MetricDuplicatePolicies,
+		Retention:               config.Retention,
+		MetricRetention:         config.MetricRetention,
+		Rollups:                 config.Rollups,
+		Limits:                  config.Limits,
+		Tenants:                 config.Tenants,
+		WALDir:                  config.WALDir,
+		WALFlushInterval:        config.WALFlushInterval,
+		WALSync:                 config.WALSync,
+		SnapshotDir:             config.SnapshotDir,
+		RestoreSnapshot:         config.RestoreSnapshot,
+		BackupDir:               config.BackupDir,
+	})
+	if err != nil {
+		return err
+	}
+
+	s.Db = db
+	s.limiter.db = db
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopBackground = cancel
+	db.StartCompactors(ctx, config.CompactionInterval)
+	db.StartRetention(ctx, config.CompactionInterval)
+	if config.SelfMonitorInterval > 0 {
+		db.StartSelfMonitor(ctx, prometheus.DefaultGatherer, config.SelfMonitorInterval)
+	}
+
+	s.ready.Store(true)
+	s.setServing(true)
+	return nil
+}
+
+// Collector returns a collector of the database's state, which collects
+// nothing until Open has restored the database.
+func (s *Server) Collector() prometheus.Collector {
+	return database.LazyCollector(func() *database.Database {
+		if !s.ready.Load() {
+			return nil
+		}
+		return s.Db
+	})
+}
+
+// Reload applies the reloadable fields of config and ignores the others.
+// If the auth file cannot be loaded, nothing is changed.
+func (s *Server) Reload(config *Config) error {
+	var auth *Authenticator
+	if config.AuthFile != "" {
+		var err error
+		if auth, err = LoadAuthenticator(config.AuthFile); err != nil {
+			return err
+		}
+	}
+
+	s.Db.Reload(database.ReloadableOptions{
+		Retention:       config.Retention,
+		MetricRetention: config.MetricRetention,
+		Limits:          config.Limits,
+		Tenants:         config.Tenants,
+	})
+	s.auth.Store(auth)
+	s.limiter.configure(config.ClientRateLimit, config.LoadShedding)
+	return nil
 }
 
 func (s *Server) ListenAndServe(addr string) error {
@@ -38,6 +241,54 @@ func (s *Server) ListenAndServe(addr string) error {
 	return s.grpcServer.Serve(lis)
 }
 
-func (s *Server) Shutdown() {
-	s.grpcServer.GracefulStop()
+// Shutdown reports NOT_SERVING to health checks, stops accepting requests
+// and waits for those in flight, stops the compactors and retention,
+// optionally takes a snapshot, then flushes and syncs the WAL. Once ctx is
+// done, the remaining requests are cancelled, the snapshot is abandoned and
+// Shutdown stops waiting for the rest, so that it returns soon after.
+func (s *Server) Shutdown(ctx context.Context) error {
+	var errs []error
+
+	s.health.Shutdown()
+	s.stop()
+	shutdownHTTP := make(chan error, 1)
+	go func() { shutdownHTTP <- s.httpServer.Shutdown(ctx) }()
+
+	drained := make(chan struct{})
+	go func() {
+		s.grpcServer.GracefulStop()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		s.grpcServer.Stop()
+		<-drained
+		errs = append(errs, fmt.Errorf("draining requests: %w", ctx.Err()))
+	}
+
+	if err := <-shutdownHTTP; err != nil {
+		errs = append(errs, fmt.Errorf("stopping HTTP server: %w", err))
+	}
+	if s.Db == nil {
+		return errors.Join(errs...)
+	}
+
+	s.stopBackground()
+
+	if s.config.SnapshotOnShutdown && ctx.Err() == nil {
+		// An abandoned snapshot leaves the WAL with every write it would
+		// have held.
+		path, _, err := s.Db.SnapshotContext(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("snapshot: %w", err))
+		} else {
+			log.Printf("Wrote snapshot %s\n", path)
+		}
+	}
+
+	if err := s.Db.CloseContext(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("closing database: %w", err))
+	}
+	return errors.Join(errs...)
 }
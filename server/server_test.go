@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"io"
 	"log"
 	"net"
 	"testing"
@@ -12,14 +13,25 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
-func setupTestServer(t *testing.T) (pb.TsdbLiteClient, *Server) {
+func setupTestServer(t *testing.T, opts ...func(*Config)) (pb.TsdbLiteClient, *Server) {
 	// Create a new server
-	server := NewServer(&Config{
+	config := &Config{
 		CompactionInterval: time.Minute,
-	})
+		WALDir:             t.TempDir(),
+		SnapshotDir:        t.TempDir(),
+		BackupDir:          t.TempDir(),
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+	server, err := NewServer(config)
+	require.NoError(t, err)
 
 	// Create a listener on a random port
 	lis, err := net.Listen("tcp", ":0")
@@ -66,6 +78,13 @@ func TestServer_Integration_GRPC(t *testing.T) {
 		assert.True(t, ok)
 		assert.Equal(t, metric, ts.Metric)
 		assert.Equal(t, tags, ts.Tags)
+
+		// Creating it again maps to AlreadyExists
+		_, err = client.CreateTimeSeries(context.Background(), &pb.CreateTimeSeriesRequest{
+			Metric: metric,
+			Tags:   tags,
+		})
+		assert.Equal(t, codes.AlreadyExists, status.Code(err))
 	})
 
 	t.Run("AddPoint", func(t *testing.T) {
@@ -191,4 +210,232 @@ func TestServer_Integration_GRPC(t *testing.T) {
 		assert.Equal(t, int64(3000), resp.Points[1].Timestamp)
 		assert.Equal(t, 95.0, resp.Points[1].Value)
 	})
+	t.Run("Delete", func(t *testing.T) {
+		metric := "humidity"
+		tags := map[string]string{"sensor": "C3"}
+
+		_, err := client.CreateTimeSeries(context.Background(), &pb.CreateTimeSeriesRequest{
+			Metric: metric,
+			Tags:   tags,
+		})
+		require.NoError(t, err)
+
+		for _, ts := range []int64{1000, 2000, 3000} {
+			_, err = client.AddPoint(context.Background(), &pb.AddPointRequest{
+				Metric:    metric,
+				Timestamp: ts,
+				Value:     50.0,
+				Tags:      tags,
+			})
+			require.NoError(t, err)
+		}
+
+		// Delete the middle point only
+		start, end := int64(1500), int64(2500)
+		resp, err := client.Delete(context.Background(), &pb.DeleteRequest{
+			Metric: metric,
+			Tags:   tags,
+			Start:  &start,
+			End:    &end,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), resp.SeriesMatched)
+
+		rangeResp, err := client.GetRange(context.Background(), &pb.GetRangeRequest{
+			Metric: metric,
+			Tags:   tags,
+			Start:  0,
+			End:    5000,
+		})
+		require.NoError(t, err)
+		require.Len(t, rangeResp.Points, 2)
+		assert.Equal(t, int64(1000), rangeResp.Points[0].Timestamp)
+		assert.Equal(t, int64(3000), rangeResp.Points[1].Timestamp)
+
+		// Without a range the whole series goes away
+		_, err = client.Delete(context.Background(), &pb.DeleteRequest{
+			Metric: metric,
+			Tags:   tags,
+		})
+		require.NoError(t, err)
+
+		_, err = client.GetRange(context.Background(), &pb.GetRangeRequest{
+			Metric: metric,
+			Tags:   tags,
+			Start:  0,
+			End:    5000,
+		})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+
+		// Self-monitoring series cannot be deleted
+		_, err = client.Delete(context.Background(), &pb.DeleteRequest{
+			Metric: database.SelfMetricPrefix + "series",
+		})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+	t.Run("Snapshot", func(t *testing.T) {
+		resp, err := client.Snapshot(context.Background(), &pb.SnapshotRequest{})
+		require.NoError(t, err)
+		assert.DirExists(t, resp.Path)
+		assert.Positive(t, resp.Series)
+	})
+	t.Run("Backup", func(t *testing.T) {
+		resp, err := client.Backup(context.Background(), &pb.BackupRequest{Incremental: true})
+		require.NoError(t, err)
+		assert.DirExists(t, resp.Path)
+		assert.Positive(t, resp.Series)
+	})
+	t.Run("ExportImport", func(t *testing.T) {
+		imp, err := client.Import(context.Background())
+		require.NoError(t, err)
+
+		var samples []*pb.Sample
+		for i := range 2*exportBatchSize + 1 {
+			samples = append(samples, &pb.Sample{Metric: "imported", Tags: map[string]string{"host": "a"}, Timestamp: int64(i), Value: float64(i)})
+		}
+		require.NoError(t, imp.Send(&pb.ImportRequest{Samples: samples[:10]}))
+		progress, err := imp.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), progress.SeriesCreated)
+		assert.Equal(t, int64(10), progress.PointsWritten)
+
+		require.NoError(t, imp.Send(&pb.ImportRequest{Samples: samples[10:]}))
+		progress, err = imp.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), progress.SeriesCreated)
+		assert.Equal(t, int64(len(samples)), progress.PointsWritten)
+		require.NoError(t, imp.CloseSend())
+		_, err = imp.Recv()
+		assert.Equal(t, io.EOF, err)
+
+		exp, err := client.Export(context.Background(), &pb.ExportRequest{Metric: "imported"})
+		require.NoError(t, err)
+		var batches, points int
+		for {
+			resp, err := exp.Recv()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			assert.Equal(t, map[string]string{"host": "a"}, resp.Tags)
+			for _, p := range resp.Points {
+				assert.Equal(t, int64(points), p.Timestamp)
+				points++
+			}
+			batches++
+		}
+		assert.Equal(t, 3, batches)
+		assert.Equal(t, len(samples), points)
+	})
+	t.Run("Backfill", func(t *testing.T) {
+		stream, err := client.Backfill(context.Background())
+		require.NoError(t, err)
+		for _, start := range []int64{0, 10} {
+			require.NoError(t, stream.Send(&pb.BackfillRequest{
+				Metric: "backfilled",
+				Points: []*pb.Point{{Timestamp: start, Value: 1}, {Timestamp: start + 1, Value: 2}},
+			}))
+		}
+		resp, err := stream.CloseAndRecv()
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), resp.SeriesCreated)
+		assert.Equal(t, int64(4), resp.PointsWritten)
+
+		stream, err = client.Backfill(context.Background())
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&pb.BackfillRequest{
+			Metric: "backfilled",
+			Points: []*pb.Point{{Timestamp: 5, Value: 1}, {Timestamp: 4, Value: 2}},
+		}))
+		_, err = stream.CloseAndRecv()
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+	t.Run("Tenants", func(t *testing.T) {
+		teamA := metadata.AppendToOutgoingContext(context.Background(), TenantMetadataKey, "team_a")
+		teamB := metadata.AppendToOutgoingContext(context.Background(), TenantMetadataKey, "team_b")
+		tags := map[string]string{"host": "server1"}
+
+		for i, ctx := range []context.Context{teamA, teamB} {
+			_, err := client.CreateTimeSeries(ctx, &pb.CreateTimeSeriesRequest{Metric: "tenant_metric", Tags: tags})
+			require.NoError(t, err)
+			_, err = client.AddPoint(ctx, &pb.AddPointRequest{Metric: "tenant_metric", Tags: tags, Timestamp: 1, Value: float64(i)})
+			require.NoError(t, err)
+		}
+
+		resp, err := client.GetRange(teamB, &pb.GetRangeRequest{Metric: "tenant_metric", Tags: tags, Start: 0, End: 10})
+		require.NoError(t, err)
+		require.Len(t, resp.Points, 1)
+		assert.Equal(t, 1.0, resp.Points[0].Value)
+
+		// The default tenant sees neither, and the tenant label is hidden
+		_, err = client.GetRange(context.Background(), &pb.GetRangeRequest{Metric: "tenant_metric", Tags: tags, Start: 0, End: 10})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+		series, err := client.Series(teamA, &pb.SeriesRequest{Selector: &pb.SeriesSelector{Metric: "tenant_metric"}})
+		require.NoError(t, err)
+		require.Len(t, series.Series, 1)
+		assert.Equal(t, tags, series.Series[0].Tags)
+		series, err = client.Series(context.Background(), &pb.SeriesRequest{Selector: &pb.SeriesSelector{Metric: "tenant_metric"}})
+		require.NoError(t, err)
+		assert.Empty(t, series.Series)
+
+		// Clients cannot name a tenant through tags
+		_, err = client.AddPoint(teamA, &pb.AddPointRequest{
+			Metric: "tenant_metric",
+			Tags:   map[string]string{"host": "server1", database.TenantLabel: "team_b"},
+		})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		deleted, err := client.Delete(teamA, &pb.DeleteRequest{Metric: "tenant_metric"})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), deleted.SeriesMatched)
+		_, err = client.GetRange(teamB, &pb.GetRangeRequest{Metric: "tenant_metric", Tags: tags, Start: 0, End: 10})
+		assert.NoError(t, err)
+	})
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	var config *Config
+	client, server := setupTestServer(t, func(c *Config) {
+		c.SnapshotOnShutdown = true
+		config = c
+	})
+
+	_, err := client.CreateTimeSeries(context.Background(), &pb.CreateTimeSeriesRequest{Metric: "cpu_usage"})
+	require.NoError(t, err)
+	_, err = client.AddPoint(context.Background(), &pb.AddPointRequest{Metric: "cpu_usage", Timestamp: 1, Value: 1})
+	require.NoError(t, err)
+
+	require.NoError(t, server.Shutdown(context.Background()))
+	_, err = client.AddPoint(context.Background(), &pb.AddPointRequest{Metric: "cpu_usage", Timestamp: 2, Value: 2})
+	assert.Error(t, err)
+
+	path, err := database.LatestSnapshot(config.SnapshotDir)
+	require.NoError(t, err)
+	assert.NotEmpty(t, path)
+
+	restarted, err := NewServer(config)
+	require.NoError(t, err)
+	defer restarted.Shutdown(context.Background())
+	points, err := restarted.Db.GetRange("cpu_usage", nil, 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []database.Point{{Timestamp: 1, Value: 1}}, points)
+}
+
+func TestServer_ShutdownDeadline(t *testing.T) {
+	client, server := setupTestServer(t, func(c *Config) { c.SnapshotOnShutdown = true })
+
+	// An open stream keeps the server from draining
+	stream, err := client.Import(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.ImportRequest{}))
+	_, err = stream.Recv()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = server.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	_, err = stream.Recv()
+	assert.Error(t, err)
 }
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+
+	"github.com/sinnlos-ffff/tsdb-lite/database"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// TenantMetadataKey is the gRPC metadata key carrying the tenant ID.
+	// Requests without it use the default tenant.
+	TenantMetadataKey = "x-tenant-id"
+	// TenantHeader is the HTTP header carrying the tenant ID.
+	TenantHeader = "X-Tenant-ID"
+)
+
+type tenantKey struct{}
+
+// WithTenant returns a copy of ctx whose requests act on behalf of tenant,
+// taking precedence over any tenant in the gRPC metadata.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromHTTP returns a copy of the request's context carrying the
+// tenant named by its TenantHeader.
+func TenantFromHTTP(r *http.Request) context.Context {
+	return WithTenant(r.Context(), r.Header.Get(TenantHeader))
+}
+
+// tenantFrom returns the tenant a request acts on behalf of.
+func tenantFrom(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantKey{}).(string); ok {
+		return tenant
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(TenantMetadataKey); len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// tenantTags returns tags placed in the request's tenant, for naming a
+// single series.
+func tenantTags(ctx context.Context, tags map[string]string) (map[string]string, error) {
+	if _, ok := tags[database.TenantLabel]; ok {
+		return nil, fmt.Errorf("%w: tag %q is reserved", database.ErrInvalidSelector, database.TenantLabel)
+	}
+	tenant := tenantFrom(ctx)
+	if tenant == "" {
+		return tags, nil
+	}
+	result := maps.Clone(tags)
+	if result == nil {
+		result = make(map[string]string, 1)
+	}
+	result[database.TenantLabel] = tenant
+	return result, nil
+}
+
+// tenantSelector returns tags restricted to the request's tenant, for
+// selecting any number of series. Unlike tenantTags, it also sets the label
+// for the default tenant, so that only series without it are selected.
+func tenantSelector(ctx context.Context, tags map[string]string) (map[string]string, error) {
+	if _, ok := tags[database.TenantLabel]; ok {
+		return nil, fmt.Errorf("%w: tag %q is reserved", database.ErrInvalidSelector, database.TenantLabel)
+	}
+	result := maps.Clone(tags)
+	if result == nil {
+		result = make(map[string]string, 1)
+	}
+	result[database.TenantLabel] = tenantFrom(ctx)
+	return result, nil
+}
+
+// withoutTenant returns tags without the tenant label, for sending back to
+// clients.
+func withoutTenant(tags map[string]string) map[string]string {
+	if _, ok := tags[database.TenantLabel]; !ok {
+		return tags
+	}
+	result := maps.Clone(tags)
+	delete(result, database.TenantLabel)
+	return result
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSConfig enables TLS on the listener when CertFile is set. The files are
+// checked for changes at most every certCheckInterval, as clients connect,
+// so rotated certificates are picked up without a restart. Connections
+// already established keep the certificates they were made with.
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile, if set, verifies client certificates, which identities
+	// can then be authenticated by. RequireClientCert refuses clients
+	// without one.
+	ClientCAFile      string
+	RequireClientCert bool
+}
+
+func (c *TLSConfig) Enabled() bool {
+	return c.CertFile != ""
+}
+
+// certCheckInterval is how often certificate files are checked for changes.
+var certCheckInterval = time.Second
+
+// certReloader holds the certificates of a TLSConfig, reloading them when
+// their files change.
+type certReloader struct {
+	config TLSConfig
+
+	mu        sync.Mutex
+	checked   time.Time
+	versions  map[string]fileVersion
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+// fileVersion tells apart the contents of a file without reading it.
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+func newCertReloader(config TLSConfig) (*certReloader, error) {
+	if config.KeyFile == "" {
+		return nil, errors.New("TLS key file is required with a certificate file")
+	}
+	if config.RequireClientCert && config.ClientCAFile == "" {
+		return nil, errors.New("requiring client certificates needs a client CA file")
+	}
+
+	r := &certReloader{config: config}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	r.checked = time.Now()
+	return r, nil
+}
+
+func (r *certReloader) files() []string {
+	files := []string{r.config.CertFile, r.config.KeyFile}
+	if r.config.ClientCAFile != "" {
+		files = append(files, r.config.ClientCAFile)
+	}
+	return files
+}
+
+// load reads the certificates. Must be called with r.mu locked, or before r
+// is shared.
+func (r *certReloader) load() error {
+	versions := make(map[string]fileVersion)
+	for _, path := range r.files() {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		versions[path] = fileVersion{modTime: info.ModTime(), size: info.Size()}
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return err
+	}
+	var clientCAs *x509.CertPool
+	if r.config.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.config.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%s: no certificates found", r.config.ClientCAFile)
+		}
+	}
+
+	r.versions = versions
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	return nil
+}
+
+// changed reports whether any of the files differs from what was loaded.
+// Must be called with r.mu locked.
+func (r *certReloader) changed() bool {
+	for _, path := range r.files() {
+		info, err := os.Stat(path)
+		if err != nil {
+			// Files are often replaced by a rename; try again next time.
+			return false
+		}
+		if (fileVersion{modTime: info.ModTime(), size: info.Size()}) != r.versions[path] {
+			return true
+		}
+	}
+	return false
+}
+
+// current returns the certificates to use, reloading them first if their
+// files changed. If reloading fails, the previous ones are kept.
+func (r *certReloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checked) >= certCheckInterval {
+		r.checked = time.Now()
+		if r.changed() {
+			if err := r.load(); err != nil {
+				log.Printf("Failed to reload TLS certificates, keeping the previous ones: %v\n", err)
+			} else {
+				log.Printf("Reloaded TLS certificates from %s\n", r.config.CertFile)
+			}
+		}
+	}
+	return r.cert, r.clientCAs
+}
+
+// serverConfig returns a TLS configuration that uses the current
+// certificates for every handshake, negotiating one of nextProtos.
+func (r *certReloader) serverConfig(nextProtos ...string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: nextProtos,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, clientCAs := r.current()
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				NextProtos:   nextProtos,
+				Certificates: []tls.Certificate{*cert},
+			}
+			if clientCAs != nil {
+				config.ClientCAs = clientCAs
+				config.ClientAuth = tls.VerifyClientCertIfGiven
+				if r.config.RequireClientCert {
+					config.ClientAuth = tls.RequireAndVerifyClientCert
+				}
+			}
+			return config, nil
+		},
+	}
+}
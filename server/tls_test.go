@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "github.com/sinnlos-ffff/tsdb-lite/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// testCA issues certificates for tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue writes a certificate for commonName and its key to dir, returning
+// their paths.
+func (ca *testCA) issue(t *testing.T, dir, commonName string, serial int64) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, commonName+".crt")
+	keyFile := filepath.Join(dir, commonName+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+func TestServer_TLS(t *testing.T) {
+	interval := certCheckInterval
+	certCheckInterval = 0
+	t.Cleanup(func() { certCheckInterval = interval })
+
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	caFile := filepath.Join(dir, "ca.crt")
+	require.NoError(t, os.WriteFile(caFile, ca.pem, 0o644))
+	certFile, keyFile := ca.issue(t, dir, "server", 2)
+	clientCert, clientKey := ca.issue(t, dir, "client", 3)
+
+	server, err := NewServer(&Config{
+		CompactionInterval: time.Minute,
+		TLS: TLSConfig{
+			CertFile:          certFile,
+			KeyFile:           keyFile,
+			ClientCAFile:      caFile,
+			RequireClientCert: true,
+		},
+	})
+	require.NoError(t, err)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go server.grpcServer.Serve(lis)
+	t.Cleanup(server.grpcServer.Stop)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	dial := func(t *testing.T, certs ...tls.Certificate) (pb.TsdbLiteClient, *x509.Certificate, error) {
+		var peerCert *x509.Certificate
+		config := &tls.Config{
+			RootCAs:      roots,
+			Certificates: certs,
+			VerifyConnection: func(cs tls.ConnectionState) error {
+				peerCert = cs.PeerCertificates[0]
+				return nil
+			},
+		}
+		conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(config)))
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		client := pb.NewTsdbLiteClient(conn)
+		_, err = client.Series(context.Background(), &pb.SeriesRequest{})
+		return client, peerCert, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+	require.NoError(t, err)
+	client, peerCert, err := dial(t, cert)
+	require.NoError(t, err)
+	assert.Equal(t, big.NewInt(2), peerCert.SerialNumber)
+
+	// Clients without a certificate are refused
+	_, _, err = dial(t)
+	assert.Error(t, err)
+
+	// A rotated certificate is used for new connections, while existing
+	// ones carry on
+	certFile2, keyFile2 := ca.issue(t, t.TempDir(), "server", 4)
+	for src, dst := range map[string]string{certFile2: certFile, keyFile2: keyFile} {
+		b, err := os.ReadFile(src)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(dst, b, 0o600))
+		later := time.Now().Add(time.Minute)
+		require.NoError(t, os.Chtimes(dst, later, later))
+	}
+	_, peerCert, err = dial(t, cert)
+	require.NoError(t, err)
+	assert.Equal(t, big.NewInt(4), peerCert.SerialNumber)
+
+	_, err = client.Series(context.Background(), &pb.SeriesRequest{})
+	assert.NoError(t, err)
+}
+
+func TestNewCertReloader_Invalid(t *testing.T) {
+	_, err := newCertReloader(TLSConfig{CertFile: "server.crt"})
+	assert.Error(t, err)
+	_, err = newCertReloader(TLSConfig{CertFile: "server.crt", KeyFile: "server.key", RequireClientCert: true})
+	assert.Error(t, err)
+	_, err = newCertReloader(TLSConfig{CertFile: "missing.crt", KeyFile: "missing.key"})
+	assert.Error(t, err)
+}
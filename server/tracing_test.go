@@ -0,0 +1,43 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	pb "github.com/sinnlos-ffff/tsdb-lite/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestServer_Tracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	client, _ := setupTestServer(t)
+
+	_, err := client.CreateTimeSeries(context.Background(), &pb.CreateTimeSeriesRequest{Metric: "cpu_usage"})
+	require.NoError(t, err)
+	_, err = client.AddPoint(context.Background(), &pb.AddPointRequest{Metric: "cpu_usage", Timestamp: 1, Value: 1})
+	require.NoError(t, err)
+	_, err = client.GetRange(context.Background(), &pb.GetRangeRequest{Metric: "cpu_usage", Start: 0, End: 10})
+	require.NoError(t, err)
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	rpc, query, scan := spans["proto.TsdbLite/GetRange"], spans["Database.GetRange"], spans["scan chunks"]
+	require.NotNil(t, rpc)
+	require.NotNil(t, query)
+	require.NotNil(t, scan)
+	assert.Equal(t, rpc.SpanContext().SpanID(), query.Parent().SpanID())
+	assert.Equal(t, query.SpanContext().SpanID(), scan.Parent().SpanID())
+	assert.Contains(t, scan.Attributes(), attribute.Int("points_scanned", 1))
+
+	for _, name := range []string{"Database.AddPoint", "lookup series", "lock series", "WAL.Log"} {
+		assert.Contains(t, spans, name)
+	}
+}
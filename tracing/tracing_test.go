@@ -0,0 +1,36 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+)
+
+func TestSetup_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+	stop, err := Setup(context.Background(), Options{Exporter: ExporterFile, File: path, SampleRatio: 1})
+	require.NoError(t, err)
+
+	_, span := otel.Tracer("test").Start(context.Background(), "test span")
+	span.End()
+	require.NoError(t, stop(context.Background()))
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"Name":"test span"`)
+	assert.Contains(t, string(b), ServiceName)
+}
+
+func TestSetup_Invalid(t *testing.T) {
+	_, err := Setup(context.Background(), Options{Exporter: "jaeger"})
+	assert.ErrorContains(t, err, `unknown trace exporter "jaeger"`)
+
+	stop, err := Setup(context.Background(), Options{})
+	require.NoError(t, err)
+	assert.NoError(t, stop(context.Background()))
+}